@@ -34,6 +34,9 @@ var (
 	commandsMap        = make(map[string]commands.Command)
 	masterId           string
 	masterDebugChannel string
+	activityStats      *commands.StatsCommand
+	antiRaid           *commands.RaidCommand
+	captcha            *commands.CaptchaHandler
 )
 
 /*
@@ -53,11 +56,27 @@ func SetupMoebot(session *discordgo.Session, redditHandle *reddit.Handle) {
 	startOperationsTimer(commands.NewSchedulerFactory(session))
 }
 
+/*
+Gives every operation a chance to save anything it's holding onto before moebot exits. Discord and the database should still be open
+*/
+func ShutdownMoebot(session *discordgo.Session) {
+	for _, o := range operations {
+		if shutdown, ok := o.(commands.ShutdownHandler); ok {
+			shutdown.Shutdown(session)
+		}
+	}
+}
+
 /*
 Create all the operations to handle commands and events within moebot.
 Whenever a new operation, command, or event is added it should be added to this list
 */
 func setupOperations(session *discordgo.Session, redditHandle *reddit.Handle) {
+	// messageCreate counts activity for stats, so it needs to hold onto the command
+	activityStats = commands.NewStatsCommand()
+	// same for anti-raid, which needs to know about every join
+	antiRaid = commands.NewRaidCommand(ComPrefix)
+	captcha = commands.NewCaptchaHandler(antiRaid)
 	operations = []interface{}{
 		&commands.RoleCommand{},
 		&commands.RoleSetCommand{ComPrefix: ComPrefix},
@@ -73,13 +92,42 @@ func setupOperations(session *discordgo.Session, redditHandle *reddit.Handle) {
 		&commands.PollCommand{PollsHandler: commands.NewPollsHandler()},
 		&commands.MentionCommand{},
 		&commands.ServerCommand{ComPrefix: ComPrefix},
-		&commands.ProfileCommand{MasterId: masterId},
+		commands.NewConfigCommand(ComPrefix),
+		commands.NewBulkRoleCommand(),
+		commands.NewProfileCommand(masterId),
+		&commands.LeaderboardCommand{},
+		&commands.TierCommand{ComPrefix: ComPrefix},
+		&commands.PointsCommand{ComPrefix: ComPrefix},
+		commands.NewShopCommand(ComPrefix),
+		&commands.ShopSetCommand{ComPrefix: ComPrefix},
+		activityStats,
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionWarn, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionMute, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionUnmute, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionKick, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionBan, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionTempBan, Checker: checker},
+		&commands.ModerationCommand{ComPrefix: ComPrefix, Action: types.ModActionUnban, Checker: checker},
+		&commands.CaseCommand{ComPrefix: ComPrefix},
+		&commands.CasesCommand{ComPrefix: ComPrefix},
+		&commands.PurgeCommand{ComPrefix: ComPrefix},
+		&commands.LockdownCommand{ComPrefix: ComPrefix},
+		&commands.LockdownCommand{ComPrefix: ComPrefix, Unlock: true},
+		commands.NewSlowmodeCommand(ComPrefix),
+		commands.NewModerationHandler(),
+		commands.NewAutomodCommand(ComPrefix, checker),
+		commands.NewMessageLogHandler(),
+		antiRaid,
+		commands.NewUnverifiedCommand(ComPrefix),
+		captcha,
 		&commands.PinMoveCommand{},
+		&commands.StarboardHandler{},
 		&commands.SubCommand{RedditHandle: redditHandle},
 		&commands.FetchCommand{MasterId: masterId},
 		commands.NewTimerCommand(),
 		commands.NewVeteranHandler(ComPrefix, masterDebugChannel, masterId),
 		commands.NewScheduleCommand(commands.NewSchedulerFactory(session)),
+		commands.NewRoleSyncHandler(),
 	}
 
 	setupCommands()
@@ -139,6 +187,7 @@ func addGlobalHandlers(discord *discordgo.Session) {
 	discord.AddHandler(ready)
 	discord.AddHandler(messageCreate)
 	discord.AddHandler(guildMemberAdd)
+	discord.AddHandler(guildMemberRemove)
 }
 
 /*
@@ -154,21 +203,10 @@ func guildMemberAdd(session *discordgo.Session, member *discordgo.GuildMemberAdd
 	if err != nil || !server.Enabled {
 		return
 	}
-	// only send out a welcome message is the server has one
-	if server.WelcomeMessage.Valid {
-		// then decide if we want to PM this welcome or post in a channel
-		var channelId string
-		if server.WelcomeChannel.Valid {
-			channelId = server.WelcomeChannel.String
-		} else {
-			dmChannel, err := session.UserChannelCreate(member.User.ID)
-			if err != nil {
-				log.Println("ERROR! Unable to make DM channel with userID ", member.User.ID)
-				return
-			}
-			channelId = dmChannel.ID
-		}
-		session.ChannelMessageSend(channelId, server.WelcomeMessage.String)
+	// joins during a raid don't get welcomed, otherwise the welcome channel just becomes part of the raid
+	inRaid := antiRaid.RecordJoin(session, server, member.Member)
+	if !inRaid {
+		commands.SendWelcome(session, guild, server, member.User)
 	}
 	// then only assign a starter role if they have one set
 	if server.StarterRole.Valid {
@@ -193,10 +231,30 @@ func guildMemberAdd(session *discordgo.Session, member *discordgo.GuildMemberAdd
 			db.ServerFullUpdate(server)
 		} else {
 			session.GuildMemberRoleAdd(member.GuildID, member.User.ID, starterRole.ID)
+			if server.CaptchaEnabled && server.BaseRole.Valid && !member.User.Bot {
+				captcha.StartCaptcha(session, server, member.User.ID)
+			}
 		}
 	}
 }
 
+/*
+Global handler for when members leave a discord guild, whether on their own or by being kicked or banned. Used to say goodbye if the server
+has enabled it.
+*/
+func guildMemberRemove(session *discordgo.Session, member *discordgo.GuildMemberRemove) {
+	guild, err := moeDiscord.GetGuild(member.GuildID, session)
+	if err != nil {
+		return
+	}
+	server, err := db.ServerQueryOrInsert(guild.ID)
+	// a raid being cleaned up would flood the channel with goodbyes
+	if err != nil || !server.Enabled || antiRaid.IsRaidMode(guild.ID) {
+		return
+	}
+	commands.SendGoodbye(session, guild, server, member.User)
+}
+
 /*
 Global handler for when new messages are sent in any guild. The entry point for commands and other general handling
 */
@@ -252,6 +310,9 @@ func messageCreate(session *discordgo.Session, message *discordgo.MessageCreate)
 	if !server.Enabled && !isMaster && !isGuildOwner {
 		return
 	}
+	if server.Enabled {
+		activityStats.RecordMessage(server.Id, channel.ID, message.Author.ID, time.Now())
+	}
 
 	var baseRole *discordgo.Role
 	var starterRole *discordgo.Role
@@ -266,7 +327,7 @@ func messageCreate(session *discordgo.Session, message *discordgo.MessageCreate)
 
 	// Check if this user is a new user. This will determine what they can/can't do on the server.
 	// Masters and guild owners are never a new user
-	isNewUser := !isMaster && !isGuildOwner && server.RuleAgreement.Valid && starterRole != nil &&
+	isNewUser := !isMaster && !isGuildOwner && (server.RuleAgreement.Valid || server.CaptchaEnabled) && starterRole != nil &&
 		util.StrContains(member.Roles, starterRole.ID, util.CaseSensitive)
 
 	if strings.HasPrefix(strings.ToUpper(message.Content), strings.ToUpper(ComPrefix)) {
@@ -285,10 +346,15 @@ func messageCreate(session *discordgo.Session, message *discordgo.MessageCreate)
 	// Disabled for now as we're getting incorrect information in the db...
 	//db.MetricInsertTimer(timer, userProfile)
 
-	// make sure to also check if they agreed to the rules
-	if isNewUser {
+	// make sure to also check if they agreed to the rules, servers with a captcha have their answers checked in DMs instead
+	if isNewUser && !server.CaptchaEnabled {
 		sanitizedMessage := util.MakeAlphaOnly(message.Content)
 		if strings.HasPrefix(strings.ToUpper(sanitizedMessage), strings.ToUpper(server.RuleAgreement.String)) {
+			if antiRaid.IsRaidMode(guild.ID) {
+				session.ChannelMessageSend(channel.ID, "Sorry "+message.Author.Mention()+", this server isn't letting anyone new in right now. "+
+					"Please try again later!")
+				return
+			}
 			if baseRole == nil {
 				// Server only had a partial setup (rule agreement + starter role but no base role)
 				session.ChannelMessageSend(channel.ID, "Hey... this is awkward... It seems like this server's admins setup a rule agreement but no base role. "+
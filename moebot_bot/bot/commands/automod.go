@@ -0,0 +1,488 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/bot/permissions"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	automodDefaultMentions    = 5
+	automodDefaultRepeats     = 3
+	automodDefaultCapsPercent = 70
+	automodDefaultMuteMinutes = 10
+	// short messages like "OK" or "LOL" shouldn't count as caps spam
+	automodCapsMinLetters = 10
+	automodRepeatWindow   = 30 * time.Second
+	// once this many users are being tracked for repeats, anyone that hasn't posted recently gets forgotten
+	automodRepeatPruneSize = 5000
+)
+
+var (
+	automodFilters = []string{types.AutomodFilterWords, types.AutomodFilterInvites, types.AutomodFilterMentions, types.AutomodFilterRepeats,
+		types.AutomodFilterCaps}
+	automodInviteRegex  = regexp.MustCompile(`(?i)(discord\.(gg|io|me|li)|discord(app)?\.com/invite)/\S+`)
+	automodMentionRegex = regexp.MustCompile(`<@[!&]?\d+>|@everyone|@here`)
+)
+
+/*
+Watches every message for spam and anything the server has banned, and lets mods set up what gets caught and what happens when it does
+*/
+type AutomodCommand struct {
+	ComPrefix string
+	Checker   permissions.PermissionChecker
+	// guild ID + user ID -> what they last said
+	repeats struct {
+		sync.Mutex
+		m map[string]*automodRepeat
+	}
+	// banned patterns get used on every message, so they're only compiled once
+	patterns struct {
+		sync.RWMutex
+		m map[string]*regexp.Regexp
+	}
+}
+
+type automodRepeat struct {
+	content string
+	count   int
+	last    time.Time
+}
+
+func NewAutomodCommand(comPrefix string, checker permissions.PermissionChecker) *AutomodCommand {
+	ac := &AutomodCommand{ComPrefix: comPrefix, Checker: checker}
+	ac.repeats.m = make(map[string]*automodRepeat)
+	ac.patterns.m = make(map[string]*regexp.Regexp)
+	return ac
+}
+
+func (ac *AutomodCommand) EventHandlers() []interface{} {
+	return []interface{}{ac.automodMessageCreate}
+}
+
+func (ac *AutomodCommand) automodMessageCreate(session *discordgo.Session, message *discordgo.MessageCreate) {
+	if message.Author == nil || message.Author.ID == session.State.User.ID || message.Author.Bot {
+		return
+	}
+	channel, err := moeDiscord.GetChannel(message.ChannelID, session)
+	if err != nil || channel.GuildID == "" {
+		return
+	}
+	server, err := db.ServerQueryOrInsert(channel.GuildID)
+	if err != nil || !server.Enabled || util.StrContains(server.AutomodExemptChannels, channel.ID, util.CaseSensitive) {
+		return
+	}
+	filters, err := db.AutomodFilterQueryServer(server.Id)
+	if err != nil || len(filters) == 0 {
+		return
+	}
+	guild, err := moeDiscord.GetGuild(channel.GuildID, session)
+	if err != nil {
+		return
+	}
+	member, err := moeDiscord.GetMember(message.Author.ID, guild.ID, session)
+	if err != nil || ac.Checker.HasModPerm(message.Author.ID, member.Roles, guild) {
+		return
+	}
+	for _, r := range member.Roles {
+		if util.StrContains(server.AutomodExemptRoles, r, util.CaseSensitive) {
+			return
+		}
+	}
+
+	for _, f := range filters {
+		if !f.Enabled {
+			continue
+		}
+		if caught, detail := ac.checkFilter(f, guild.ID, message.Message); caught {
+			ac.applyFilterAction(session, guild, server, f, message.Message, detail)
+			return
+		}
+	}
+}
+
+/*
+Checks a single message against a filter, returning what was caught so mods know why the message was removed
+*/
+func (ac *AutomodCommand) checkFilter(f types.AutomodFilter, guildUid string, message *discordgo.Message) (caught bool, detail string) {
+	switch f.Filter {
+	case types.AutomodFilterWords:
+		for _, p := range f.Patterns {
+			if rx := ac.compilePattern(p); rx != nil && rx.MatchString(message.Content) {
+				return true, "banned word `" + p + "`"
+			}
+		}
+	case types.AutomodFilterInvites:
+		if automodInviteRegex.MatchString(message.Content) {
+			return true, "invite link"
+		}
+	case types.AutomodFilterMentions:
+		threshold := int(getAutomodSetting(f.Threshold, automodDefaultMentions))
+		if count := countMentions(message.Content); count >= threshold {
+			return true, strconv.Itoa(count) + " mentions"
+		}
+	case types.AutomodFilterCaps:
+		threshold := int(getAutomodSetting(f.Threshold, automodDefaultCapsPercent))
+		if letters, upper := countCaps(message.Content); letters >= automodCapsMinLetters && upper*100 >= letters*threshold {
+			return true, strconv.Itoa(upper*100/letters) + "% caps"
+		}
+	case types.AutomodFilterRepeats:
+		threshold := int(getAutomodSetting(f.Threshold, automodDefaultRepeats))
+		key := guildUid + ":" + message.Author.ID
+		if count := ac.trackRepeat(key, message.Content, time.Now()); count >= threshold {
+			// start counting again, otherwise every repeat after this would be caught too
+			ac.repeats.Lock()
+			delete(ac.repeats.m, key)
+			ac.repeats.Unlock()
+			return true, "the same message " + strconv.Itoa(count) + " times"
+		}
+	}
+	return false, ""
+}
+
+/*
+Removes the message, then warns or mutes if the filter asks for it. Anything that isn't just a delete gets its own case
+*/
+func (ac *AutomodCommand) applyFilterAction(session *discordgo.Session, guild *discordgo.Guild, server types.Server, f types.AutomodFilter,
+	message *discordgo.Message, detail string) {
+
+	err := session.ChannelMessageDelete(message.ChannelID, message.ID)
+	if err != nil {
+		log.Println("Error deleting message caught by automod", err)
+	}
+	action := f.Action
+	muteRoleExists := server.MuteRole.Valid && moeDiscord.FindRoleById(guild.Roles, server.MuteRole.String) != nil
+	if action == types.AutomodActionMute && !muteRoleExists {
+		// can't mute without a role, but a warning is still better than nothing
+		action = types.AutomodActionWarn
+	}
+	if action == types.AutomodActionDelete {
+		if server.ModLogChannel.Valid {
+			session.ChannelMessageSend(server.ModLogChannel.String, util.MakeStringBold("Automod")+" removed a message from "+
+				util.UserIdToMention(message.Author.ID)+" in <#"+message.ChannelID+"> for "+detail+".")
+		}
+		return
+	}
+
+	user, err := db.UserQueryOrInsert(message.Author.ID)
+	if err != nil {
+		return
+	}
+	moebot, err := db.UserQueryOrInsert(session.State.User.ID)
+	if err != nil {
+		return
+	}
+	modCase := types.ModCase{
+		ServerId:     server.Id,
+		GuildUid:     guild.ID,
+		Action:       types.ModActionWarn,
+		UserId:       user.Id,
+		UserUid:      message.Author.ID,
+		ModeratorId:  moebot.Id,
+		ModeratorUid: session.State.User.ID,
+	}
+	modCase.Reason.Scan("Automod: " + detail + " in <#" + message.ChannelID + ">")
+	if action == types.AutomodActionMute {
+		duration := time.Duration(getAutomodSetting(f.MuteMinutes, automodDefaultMuteMinutes)) * time.Minute
+		modCase.Action = types.ModActionMute
+		modCase.DurationMinutes.Scan(int64(duration / time.Minute))
+		modCase.ExpiresAt = time.Now().Add(duration)
+		if err = session.GuildMemberRoleAdd(guild.ID, message.Author.ID, server.MuteRole.String); err != nil {
+			log.Println("Error muting User UID: "+message.Author.ID+" from automod", err)
+			return
+		}
+		db.ModCaseResolveUser(server.Id, user.Id, []string{types.ModActionMute})
+	}
+	modCase, err = db.ModCaseInsert(modCase)
+	if err != nil {
+		return
+	}
+	notifyModAction(session, guild, modCase)
+	postModCase(session, server, modCase, ac.ComPrefix)
+}
+
+/*
+Counts how many times in a row someone has sent the same message, as long as they keep sending it within the repeat window
+*/
+func (ac *AutomodCommand) trackRepeat(key string, content string, now time.Time) int {
+	content = strings.ToLower(strings.TrimSpace(content))
+	ac.repeats.Lock()
+	defer ac.repeats.Unlock()
+	if len(ac.repeats.m) >= automodRepeatPruneSize {
+		for k, r := range ac.repeats.m {
+			if now.Sub(r.last) > automodRepeatWindow {
+				delete(ac.repeats.m, k)
+			}
+		}
+	}
+	r, ok := ac.repeats.m[key]
+	if !ok || content == "" || r.content != content || now.Sub(r.last) > automodRepeatWindow {
+		ac.repeats.m[key] = &automodRepeat{content: content, count: 1, last: now}
+		return 1
+	}
+	r.count++
+	r.last = now
+	return r.count
+}
+
+/*
+Gets the regex for a banned pattern, compiling it the first time it's seen. Returns nil for invalid regex
+*/
+func (ac *AutomodCommand) compilePattern(pattern string) *regexp.Regexp {
+	ac.patterns.RLock()
+	rx, ok := ac.patterns.m[pattern]
+	ac.patterns.RUnlock()
+	if ok {
+		return rx
+	}
+	rx, err := compileAutomodPattern(pattern)
+	if err != nil {
+		rx = nil
+	}
+	ac.patterns.Lock()
+	ac.patterns.m[pattern] = rx
+	ac.patterns.Unlock()
+	return rx
+}
+
+func (ac *AutomodCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	filters, err := db.AutomodFilterQueryServer(server.Id)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the automod filters. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(pack.params) == 0 {
+		ac.listFilters(pack, server, filters)
+		return
+	}
+
+	args := ParseCommand(pack.params, []string{"-filter", "-enabled", "-action", "-duration", "-threshold", "-add", "-remove", "-exempt", "-exemptrole"})
+	if channelText, ok := args["-exempt"]; ok {
+		channelUid := channelText
+		if strings.HasPrefix(channelText, "<#") {
+			channelUid, _ = util.ExtractChannelIdFromString(channelText)
+		}
+		c, err := moeDiscord.GetChannel(channelUid, pack.session)
+		if err != nil || c.GuildID != pack.guild.ID {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid channel in this server.")
+			return
+		}
+		server.AutomodExemptChannels = toggleString(server.AutomodExemptChannels, c.ID)
+		ac.saveExemptions(pack, server, util.StrContains(server.AutomodExemptChannels, c.ID, util.CaseSensitive), "<#"+c.ID+">")
+		return
+	}
+	if roleName, ok := args["-exemptrole"]; ok {
+		role := moeDiscord.FindRoleByName(pack.guild.Roles, roleName)
+		if role == nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid role and make sure it's the full role name")
+			return
+		}
+		server.AutomodExemptRoles = toggleString(server.AutomodExemptRoles, role.ID)
+		ac.saveExemptions(pack, server, util.StrContains(server.AutomodExemptRoles, role.ID, util.CaseSensitive), role.Name)
+		return
+	}
+
+	name := strings.ToLower(args["-filter"])
+	if !util.StrContains(automodFilters, name, util.CaseSensitive) {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a -filter of "+strings.Join(automodFilters, ", ")+".")
+		return
+	}
+	filter := types.AutomodFilter{ServerId: server.Id, Filter: name, Action: types.AutomodActionDelete}
+	for _, f := range filters {
+		if f.Filter == name {
+			// only change what was given
+			filter = f
+			break
+		}
+	}
+	if enabledText, ok := args["-enabled"]; ok {
+		filter.Enabled, err = strconv.ParseBool(enabledText)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide either true or false for -enabled.")
+			return
+		}
+	}
+	if action, ok := args["-action"]; ok {
+		action = strings.ToLower(action)
+		if action != types.AutomodActionDelete && action != types.AutomodActionWarn && action != types.AutomodActionMute {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide an -action of delete, warn, or mute.")
+			return
+		}
+		filter.Action = action
+	}
+	if durationText, ok := args["-duration"]; ok {
+		duration, err := util.ParseDuration(durationText)
+		if err != nil || duration < time.Minute {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a -duration of at least a minute, like `30m` or `1D`.")
+			return
+		}
+		filter.MuteMinutes.Scan(int64(duration / time.Minute))
+	}
+	if thresholdText, ok := args["-threshold"]; ok {
+		threshold, err := strconv.Atoi(thresholdText)
+		if err != nil || threshold <= 0 || (name == types.AutomodFilterCaps && threshold > 100) {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a -threshold greater than 0, or a percent up to 100 for caps.")
+			return
+		}
+		filter.Threshold.Scan(int64(threshold))
+	}
+	if pattern, ok := args["-add"]; ok {
+		if _, err := compileAutomodPattern(pattern); err != nil || pattern == "" {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, that isn't a valid word or regex.")
+			return
+		}
+		if len(filter.Patterns) >= db.AutomodMaxPatterns {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, each server can only have "+db.AutomodMaxPatternsString+" banned words.")
+			return
+		}
+		if !util.StrContains(filter.Patterns, pattern, util.CaseInsensitive) {
+			// copied so the cached filter isn't changed
+			filter.Patterns = append(append([]string{}, filter.Patterns...), pattern)
+		}
+	}
+	if pattern, ok := args["-remove"]; ok {
+		var patterns []string
+		for _, p := range filter.Patterns {
+			if !strings.EqualFold(p, pattern) {
+				patterns = append(patterns, p)
+			}
+		}
+		filter.Patterns = patterns
+	}
+	if db.AutomodFilterInsertOrUpdate(filter) != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue saving the filter. This is an issue with moebot and not Discord.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Saved the automod filter: "+describeAutomodFilter(filter))
+}
+
+func (ac *AutomodCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (ac *AutomodCommand) GetCommandKeys() []string {
+	return []string{"AUTOMOD"}
+}
+
+func (ac *AutomodCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s automod -filter <words|invites|mentions|repeats|caps> [-enabled <true|false> -action <delete|warn|mute> "+
+		"-duration <mute length> -threshold <number> -add <word or /regex/> -remove <word>]` - Mod. Sets up an automod filter. -threshold is "+
+		"mentions per message, messages in a row, or percent caps. `%[1]s automod -exempt <channel>` and `%[1]s automod -exemptrole <role name>` "+
+		"toggle where automod doesn't apply, and `%[1]s automod` lists the current settings.", commPrefix)
+}
+
+func (ac *AutomodCommand) saveExemptions(pack *CommPackage, server types.Server, exempt bool, name string) {
+	if db.ServerFullUpdate(server) != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue saving the exemption. This is an issue with moebot and not Discord.")
+		return
+	}
+	if exempt {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Automod will now ignore "+name+".")
+	} else {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Automod will no longer ignore "+name+".")
+	}
+}
+
+func (ac *AutomodCommand) listFilters(pack *CommPackage, server types.Server, filters []types.AutomodFilter) {
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Automod filters:"))
+	for _, name := range automodFilters {
+		filter := types.AutomodFilter{Filter: name, Action: types.AutomodActionDelete}
+		for _, f := range filters {
+			if f.Filter == name {
+				filter = f
+			}
+		}
+		message.WriteString("\n" + describeAutomodFilter(filter))
+	}
+	if len(server.AutomodExemptChannels) > 0 {
+		var channels []string
+		for _, c := range server.AutomodExemptChannels {
+			channels = append(channels, "<#"+c+">")
+		}
+		message.WriteString("\nIgnored channels: " + strings.Join(channels, ", "))
+	}
+	if len(server.AutomodExemptRoles) > 0 {
+		var roles []string
+		for _, r := range server.AutomodExemptRoles {
+			if role := moeDiscord.FindRoleById(pack.guild.Roles, r); role != nil {
+				roles = append(roles, role.Name)
+			}
+		}
+		message.WriteString("\nIgnored roles: " + strings.Join(roles, ", "))
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func describeAutomodFilter(f types.AutomodFilter) string {
+	if !f.Enabled {
+		return util.MakeStringCode(f.Filter) + " - off"
+	}
+	text := util.MakeStringCode(f.Filter) + " - " + f.Action
+	if f.Action == types.AutomodActionMute {
+		text += " for " + (time.Duration(getAutomodSetting(f.MuteMinutes, automodDefaultMuteMinutes)) * time.Minute).String()
+	}
+	switch f.Filter {
+	case types.AutomodFilterWords:
+		text += ", " + strconv.Itoa(len(f.Patterns)) + " banned words"
+	case types.AutomodFilterMentions:
+		text += ", " + strconv.FormatInt(getAutomodSetting(f.Threshold, automodDefaultMentions), 10) + " or more mentions"
+	case types.AutomodFilterRepeats:
+		text += ", " + strconv.FormatInt(getAutomodSetting(f.Threshold, automodDefaultRepeats), 10) + " of the same message in a row"
+	case types.AutomodFilterCaps:
+		text += ", " + strconv.FormatInt(getAutomodSetting(f.Threshold, automodDefaultCapsPercent), 10) + "% or more caps"
+	}
+	return text
+}
+
+/*
+Turns a banned pattern into a regex. Anything wrapped in slashes is used as regex, otherwise it has to match as a whole word. Always ignores case
+*/
+func compileAutomodPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+	}
+	return regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(pattern) + `($|\W)`)
+}
+
+func getAutomodSetting(setting sql.NullInt64, defaultValue int64) int64 {
+	if setting.Valid {
+		return setting.Int64
+	}
+	return defaultValue
+}
+
+func countMentions(content string) int {
+	return len(automodMentionRegex.FindAllString(content, -1))
+}
+
+func countCaps(content string) (letters int, upper int) {
+	for _, r := range content {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package commands
+
+import "testing"
+
+func TestAutomodCommand_CompileAutomodPattern(t *testing.T) {
+	checks := []struct {
+		pattern string
+		content string
+		match   bool
+	}{
+		{"heck", "what the heck", true},
+		{"heck", "HECK!", true},
+		{"heck", "checking in", false},
+		{"/fr[e3]e nitro/", "get FR3E NITRO here", true},
+		{"/fr[e3]e nitro/", "free pizza", false},
+		{"a.b", "aXb", false},
+	}
+	for _, c := range checks {
+		rx, err := compileAutomodPattern(c.pattern)
+		if err != nil {
+			t.Errorf("compileAutomodPattern(%q) failed: %v", c.pattern, err)
+			continue
+		}
+		if rx.MatchString(c.content) != c.match {
+			t.Errorf("compileAutomodPattern(%q) matching %q, expected %v", c.pattern, c.content, c.match)
+		}
+	}
+	if _, err := compileAutomodPattern("/([a-z/"); err == nil {
+		t.Error("compileAutomodPattern should fail on invalid regex")
+	}
+}
+
+func TestAutomodCommand_CountMentions(t *testing.T) {
+	checks := []struct {
+		content string
+		count   int
+	}{
+		{"hello there", 0},
+		{"<@123> <@!456> <@&789>", 3},
+		{"@everyone look @here", 2},
+		{"<@123><@123><@123>", 3},
+	}
+	for _, c := range checks {
+		if actual := countMentions(c.content); actual != c.count {
+			t.Errorf("countMentions(%q) = %d, expected %d", c.content, actual, c.count)
+		}
+	}
+}
+
+func TestAutomodCommand_CountCaps(t *testing.T) {
+	checks := []struct {
+		content        string
+		letters, upper int
+	}{
+		{"", 0, 0},
+		{"Hello World", 10, 2},
+		{"STOP YELLING!!! 123", 11, 11},
+	}
+	for _, c := range checks {
+		letters, upper := countCaps(c.content)
+		if letters != c.letters || upper != c.upper {
+			t.Errorf("countCaps(%q) = %d, %d, expected %d, %d", c.content, letters, upper, c.letters, c.upper)
+		}
+	}
+}
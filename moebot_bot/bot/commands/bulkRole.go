@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	// discordgo already waits out rate limits, but spacing out requests keeps a big job from starving every other role change in the server
+	bulkRoleRequestInterval = 250 * time.Millisecond
+	// How often the status message gets edited with the current progress
+	bulkRoleStatusInterval = 5 * time.Second
+	bulkRoleDateFormat     = "2006-01-02"
+)
+
+/*
+Adds or removes a role from a whole set of members in the background, one member at a time
+*/
+type BulkRoleCommand struct {
+	// guild ID -> running job. Only one job can run per guild so that two jobs can't fight over the same members
+	jobs struct {
+		sync.Mutex
+		m map[string]*bulkRoleJob
+	}
+}
+
+type bulkRoleJob struct {
+	role      *discordgo.Role
+	add       bool
+	members   []*discordgo.Member
+	startedBy string
+	// closed to cancel the job
+	cancelCh chan struct{}
+
+	statusChannel string
+	statusMessage string
+	processed     int
+	changed       int
+	failed        int
+}
+
+func NewBulkRoleCommand() *BulkRoleCommand {
+	result := &BulkRoleCommand{}
+	result.jobs.m = make(map[string]*bulkRoleJob)
+	return result
+}
+
+func (bc *BulkRoleCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-add", "-remove", "-withrole", "-joinedbefore", "-joinedafter", "-all", "-cancel"})
+	if _, ok := args["-cancel"]; ok {
+		bc.cancelJob(pack)
+		return
+	}
+	addName, hasAdd := args["-add"]
+	removeName, hasRemove := args["-remove"]
+	withRoleName, hasWithRole := args["-withrole"]
+	beforeText, hasBefore := args["-joinedbefore"]
+	afterText, hasAfter := args["-joinedafter"]
+	_, hasAll := args["-all"]
+
+	if hasAdd == hasRemove {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide either -add or -remove along with a role name.")
+		return
+	}
+	if !hasAll && !hasWithRole && !hasBefore && !hasAfter {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide who to change: -withrole, -joinedbefore, -joinedafter, or -all for everyone.")
+		return
+	}
+	roleName := addName
+	if hasRemove {
+		roleName = removeName
+	}
+	role := moeDiscord.FindRoleByName(pack.guild.Roles, roleName)
+	if role == nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, it doesn't seem like the role `"+roleName+"` exists on this server.")
+		return
+	}
+	if ok, err := moeDiscord.CanManageRole(pack.session, pack.guild, pack.member, role); err != nil || !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, `"+role.Name+"` is at or above your highest role or moebot's, so it can't be "+
+			"changed in bulk.")
+		return
+	}
+	var withRole *discordgo.Role
+	if hasWithRole {
+		withRole = moeDiscord.FindRoleByName(pack.guild.Roles, withRoleName)
+		if withRole == nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, it doesn't seem like the role `"+withRoleName+"` exists on this server.")
+			return
+		}
+	}
+	var joinedBefore, joinedAfter time.Time
+	var err error
+	if hasBefore {
+		if joinedBefore, err = time.Parse(bulkRoleDateFormat, beforeText); err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide -joinedbefore as a date like "+bulkRoleDateFormat)
+			return
+		}
+	}
+	if hasAfter {
+		if joinedAfter, err = time.Parse(bulkRoleDateFormat, afterText); err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide -joinedafter as a date like "+bulkRoleDateFormat)
+			return
+		}
+	}
+
+	bc.jobs.Lock()
+	_, running := bc.jobs.m[pack.guild.ID]
+	bc.jobs.Unlock()
+	if running {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There's already a bulk role job running in this server. You can stop it with -cancel.")
+		return
+	}
+
+	guildMembers, err := moeDiscord.GetAllGuildMembers(pack.session, pack.guild.ID)
+	if err != nil {
+		log.Println("Error fetching guild members for bulk role", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching the members of this server. Please try again later.")
+		return
+	}
+	job := &bulkRoleJob{
+		role:          role,
+		add:           hasAdd,
+		startedBy:     pack.message.Author.ID,
+		cancelCh:      make(chan struct{}),
+		statusChannel: pack.channel.ID,
+	}
+	for _, m := range guildMembers {
+		if m.User.Bot || util.StrContains(m.Roles, role.ID, util.CaseSensitive) == job.add {
+			// skip anyone that wouldn't change anyways
+			continue
+		}
+		if withRole != nil && !util.StrContains(m.Roles, withRole.ID, util.CaseSensitive) {
+			continue
+		}
+		if hasBefore || hasAfter {
+			joined, err := m.JoinedAt.Parse()
+			if err != nil || (hasBefore && !joined.Before(joinedBefore)) || (hasAfter && joined.Before(joinedAfter)) {
+				continue
+			}
+		}
+		job.members = append(job.members, m)
+	}
+	if len(job.members) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "No members matched, so there's nothing to change!")
+		return
+	}
+
+	bc.jobs.Lock()
+	if _, running = bc.jobs.m[pack.guild.ID]; running {
+		// someone else snuck one in while we were loading members
+		bc.jobs.Unlock()
+		pack.session.ChannelMessageSend(pack.channel.ID, "There's already a bulk role job running in this server. You can stop it with -cancel.")
+		return
+	}
+	bc.jobs.m[pack.guild.ID] = job
+	bc.jobs.Unlock()
+
+	status, err := pack.session.ChannelMessageSend(pack.channel.ID, job.statusText("Starting"))
+	if err == nil {
+		job.statusMessage = status.ID
+	}
+	go bc.runJob(pack.session, pack.guild.ID, job)
+}
+
+func (bc *BulkRoleCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (bc *BulkRoleCommand) GetCommandKeys() []string {
+	return []string{"BULKROLE"}
+}
+
+func (bc *BulkRoleCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s bulkrole -add|-remove <role name> [-withrole <role name> -joinedbefore <yyyy-mm-dd> -joinedafter <yyyy-mm-dd> -all]` - "+
+		"Mod. Adds or removes a role for every member matching all the given filters (or everyone with -all). `%[1]s bulkrole -cancel` stops a "+
+		"running job.", commPrefix)
+}
+
+func (bc *BulkRoleCommand) cancelJob(pack *CommPackage) {
+	bc.jobs.Lock()
+	defer bc.jobs.Unlock()
+	job, ok := bc.jobs.m[pack.guild.ID]
+	if !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There's no bulk role job running in this server.")
+		return
+	}
+	// removing it from the map here means a double cancel can't close the channel twice
+	delete(bc.jobs.m, pack.guild.ID)
+	close(job.cancelCh)
+	pack.session.ChannelMessageSend(pack.channel.ID, "Cancelling the bulk role job...")
+}
+
+func (bc *BulkRoleCommand) runJob(session *discordgo.Session, guildUid string, job *bulkRoleJob) {
+	ticker := time.NewTicker(bulkRoleRequestInterval)
+	defer ticker.Stop()
+	lastStatus := time.Now()
+	state := "Finished"
+	for _, m := range job.members {
+		select {
+		case <-job.cancelCh:
+			state = "Cancelled"
+		case <-ticker.C:
+		}
+		if state == "Cancelled" {
+			break
+		}
+		var err error
+		if job.add {
+			err = session.GuildMemberRoleAdd(guildUid, m.User.ID, job.role.ID)
+		} else {
+			err = session.GuildMemberRoleRemove(guildUid, m.User.ID, job.role.ID)
+		}
+		job.processed++
+		if err != nil {
+			// most likely the member left, or the role is above moebot's. Either way keep going with everyone else
+			log.Println("Error changing role for member "+m.User.ID+" during bulk role", err)
+			job.failed++
+		} else {
+			job.changed++
+		}
+		if time.Since(lastStatus) > bulkRoleStatusInterval {
+			lastStatus = time.Now()
+			job.updateStatus(session, "Running")
+		}
+	}
+
+	bc.jobs.Lock()
+	if bc.jobs.m[guildUid] == job {
+		delete(bc.jobs.m, guildUid)
+	}
+	bc.jobs.Unlock()
+	job.updateStatus(session, state)
+}
+
+func (job *bulkRoleJob) updateStatus(session *discordgo.Session, state string) {
+	if job.statusMessage == "" {
+		session.ChannelMessageSend(job.statusChannel, job.statusText(state))
+		return
+	}
+	session.ChannelMessageEdit(job.statusChannel, job.statusMessage, job.statusText(state))
+}
+
+func (job *bulkRoleJob) statusText(state string) string {
+	action := "Adding"
+	if !job.add {
+		action = "Removing"
+	}
+	return "**" + state + "** - " + action + " `" + job.role.Name + "` (started by " + util.UserIdToMention(job.startedBy) + ")\nProgress: " +
+		strconv.Itoa(job.processed) + "/" + strconv.Itoa(len(job.members)) + " members. Changed: " + strconv.Itoa(job.changed) + ", failed: " +
+		strconv.Itoa(job.failed)
+}
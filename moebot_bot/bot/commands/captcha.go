@@ -0,0 +1,337 @@
+package commands
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	captchaLength         = 6
+	captchaDefaultMinutes = 10
+	captchaDefaultTries   = 3
+	captchaCheckInterval  = 30 * time.Second
+)
+
+/*
+DMs new members a captcha image and lets them into the server once they reply with what it says. Anyone that runs out of time or attempts is
+kicked, and can join again for another go. Pending captchas only live in memory, so anyone mid-captcha when moebot restarts stays in the
+starter role until a mod lets them in
+*/
+type CaptchaHandler struct {
+	raid    *RaidCommand
+	pending struct {
+		sync.Mutex
+		// user ID -> captchas waiting on them, oldest first. Replies always go to the oldest since DMs don't say which server they're for
+		m map[string][]*pendingCaptcha
+		// user ID -> guild IDs they've solved a captcha for but are waiting on raid mode to end
+		passed map[string][]string
+	}
+	stopCh chan struct{}
+}
+
+type pendingCaptcha struct {
+	guildUid     string
+	answer       string
+	attemptsLeft int
+	length       time.Duration
+	// zero until the captcha has been sent, so time spent on another server's captcha doesn't count
+	expires time.Time
+}
+
+func NewCaptchaHandler(raid *RaidCommand) *CaptchaHandler {
+	ch := &CaptchaHandler{raid: raid, stopCh: make(chan struct{})}
+	ch.pending.m = make(map[string][]*pendingCaptcha)
+	ch.pending.passed = make(map[string][]string)
+	return ch
+}
+
+func (ch *CaptchaHandler) EventHandlers() []interface{} {
+	return []interface{}{ch.captchaReply}
+}
+
+func (ch *CaptchaHandler) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(captchaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ch.expireCaptchas(session)
+				ch.admitPassed(session)
+			case <-ch.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (ch *CaptchaHandler) Shutdown(session *discordgo.Session) {
+	close(ch.stopCh)
+}
+
+/*
+Starts a captcha for someone that just joined. If they already have one going for another server, this one is sent once that's done
+*/
+func (ch *CaptchaHandler) StartCaptcha(session *discordgo.Session, server types.Server, userUid string) {
+	minutes := captchaDefaultMinutes
+	if server.CaptchaMinutes.Valid && server.CaptchaMinutes.Int64 > 0 {
+		minutes = int(server.CaptchaMinutes.Int64)
+	}
+	attempts := captchaDefaultTries
+	if server.CaptchaAttempts.Valid && server.CaptchaAttempts.Int64 > 0 {
+		attempts = int(server.CaptchaAttempts.Int64)
+	}
+	captcha := &pendingCaptcha{
+		guildUid:     server.GuildUid,
+		answer:       util.MakeCaptchaText(captchaLength),
+		attemptsLeft: attempts,
+		length:       time.Duration(minutes) * time.Minute,
+	}
+	ch.pending.Lock()
+	ch.pending.m[userUid] = append(ch.pending.m[userUid], captcha)
+	first := len(ch.pending.m[userUid]) == 1
+	ch.pending.Unlock()
+	if first {
+		ch.sendCaptcha(session, userUid, captcha)
+	}
+}
+
+func (ch *CaptchaHandler) captchaReply(session *discordgo.Session, message *discordgo.MessageCreate) {
+	if message.Author == nil || message.Author.Bot {
+		return
+	}
+	channel, err := moeDiscord.GetChannel(message.ChannelID, session)
+	if err != nil || channel.Type != discordgo.ChannelTypeDM {
+		return
+	}
+	userUid := message.Author.ID
+	ch.pending.Lock()
+	captchas := ch.pending.m[userUid]
+	if len(captchas) == 0 {
+		ch.pending.Unlock()
+		return
+	}
+	captcha := captchas[0]
+	if !checkCaptchaAnswer(captcha.answer, message.Content) {
+		captcha.attemptsLeft--
+		attemptsLeft := captcha.attemptsLeft
+		ch.pending.Unlock()
+		if attemptsLeft > 0 {
+			session.ChannelMessageSend(message.ChannelID, "Sorry, that's not right. You have "+strconv.Itoa(attemptsLeft)+" tries left.")
+			return
+		}
+		ch.failCaptcha(session, userUid, captcha, "Sorry, that's not right and you're out of tries.")
+		return
+	}
+	ch.pending.m[userUid] = captchas[1:]
+	ch.pending.Unlock()
+	ch.passCaptcha(session, message.ChannelID, userUid, captcha.guildUid)
+	ch.sendNext(session, userUid)
+}
+
+/*
+Moves someone that solved the captcha from the starter role to the base role, unless the server is in raid mode in which case they're let in
+once it's over
+*/
+func (ch *CaptchaHandler) passCaptcha(session *discordgo.Session, dmChannelUid string, userUid string, guildUid string) {
+	guild, err := moeDiscord.GetGuild(guildUid, session)
+	if err != nil {
+		return
+	}
+	if ch.raid.IsRaidMode(guildUid) {
+		ch.pending.Lock()
+		ch.pending.passed[userUid] = append(ch.pending.passed[userUid], guildUid)
+		ch.pending.Unlock()
+		session.ChannelMessageSend(dmChannelUid, "That's right! "+guild.Name+" isn't letting anyone new in right now, but you'll be let in "+
+			"as soon as it is.")
+		return
+	}
+	if ch.admit(session, guildUid, userUid) {
+		session.ChannelMessageSend(dmChannelUid, "That's right! Welcome to "+guild.Name+", we hope you enjoy your stay!")
+	} else {
+		session.ChannelMessageSend(dmChannelUid, "That's right! But there was an issue letting you into "+guild.Name+", please let a mod know.")
+	}
+}
+
+func (ch *CaptchaHandler) admit(session *discordgo.Session, guildUid string, userUid string) bool {
+	server, err := db.ServerQueryOrInsert(guildUid)
+	if err != nil || !server.BaseRole.Valid || !server.StarterRole.Valid {
+		return false
+	}
+	if err = session.GuildMemberRoleAdd(guildUid, userUid, server.BaseRole.String); err != nil {
+		log.Println("Error adding base role after captcha for User UID: "+userUid, err)
+		return false
+	}
+	session.GuildMemberRoleRemove(guildUid, userUid, server.StarterRole.String)
+	log.Println("Updated user UID <" + userUid + "> after solving the captcha")
+	return true
+}
+
+/*
+Kicks someone that didn't solve their captcha, and moves on to their next one if they have any
+*/
+func (ch *CaptchaHandler) failCaptcha(session *discordgo.Session, userUid string, captcha *pendingCaptcha, why string) {
+	ch.pending.Lock()
+	captchas := ch.pending.m[userUid]
+	found := false
+	for i, c := range captchas {
+		if c == captcha {
+			ch.pending.m[userUid] = append(captchas[:i:i], captchas[i+1:]...)
+			found = true
+			break
+		}
+	}
+	ch.pending.Unlock()
+	if !found {
+		// already dealt with, either answered or timed out in the meantime
+		return
+	}
+	server, err := db.ServerQueryOrInsert(captcha.guildUid)
+	if err != nil {
+		return
+	}
+	if member, err := moeDiscord.GetMember(userUid, captcha.guildUid, session); err != nil ||
+		!util.StrContains(member.Roles, server.StarterRole.String, util.CaseSensitive) {
+		// either they've left already or a mod let them in by hand
+		ch.sendNext(session, userUid)
+		return
+	}
+
+	guild, err := moeDiscord.GetGuild(captcha.guildUid, session)
+	if err == nil {
+		if dmChannel, err := session.UserChannelCreate(userUid); err == nil {
+			session.ChannelMessageSend(dmChannel.ID, why+" You've been removed from "+guild.Name+", but you're welcome to join again and "+
+				"have another go.")
+		}
+	}
+	if err := session.GuildMemberDeleteWithReason(captcha.guildUid, userUid, "Didn't solve the captcha"); err != nil {
+		log.Println("Error kicking User UID: "+userUid+" after a failed captcha", err)
+	}
+	ch.sendNext(session, userUid)
+}
+
+func (ch *CaptchaHandler) expireCaptchas(session *discordgo.Session) {
+	now := time.Now()
+	type expiredCaptcha struct {
+		userUid string
+		captcha *pendingCaptcha
+	}
+	var expired []expiredCaptcha
+	ch.pending.Lock()
+	for userUid, captchas := range ch.pending.m {
+		if len(captchas) == 0 {
+			delete(ch.pending.m, userUid)
+			continue
+		}
+		for _, c := range captchas {
+			if !c.expires.IsZero() && now.After(c.expires) {
+				expired = append(expired, expiredCaptcha{userUid, c})
+			}
+		}
+	}
+	ch.pending.Unlock()
+	for _, e := range expired {
+		ch.failCaptcha(session, e.userUid, e.captcha, "Sorry, you ran out of time to answer the captcha.")
+	}
+}
+
+/*
+Lets in everyone that solved a captcha while their server was in raid mode, now that it's over
+*/
+func (ch *CaptchaHandler) admitPassed(session *discordgo.Session) {
+	toAdmit := make(map[string][]string)
+	ch.pending.Lock()
+	for userUid, guildUids := range ch.pending.passed {
+		var stillWaiting []string
+		for _, guildUid := range guildUids {
+			if ch.raid.IsRaidMode(guildUid) {
+				stillWaiting = append(stillWaiting, guildUid)
+			} else {
+				toAdmit[userUid] = append(toAdmit[userUid], guildUid)
+			}
+		}
+		if len(stillWaiting) == 0 {
+			delete(ch.pending.passed, userUid)
+		} else {
+			ch.pending.passed[userUid] = stillWaiting
+		}
+	}
+	ch.pending.Unlock()
+	for userUid, guildUids := range toAdmit {
+		for _, guildUid := range guildUids {
+			ch.admit(session, guildUid, userUid)
+		}
+	}
+}
+
+/*
+Sends the next captcha someone has waiting, if there is one
+*/
+func (ch *CaptchaHandler) sendNext(session *discordgo.Session, userUid string) {
+	ch.pending.Lock()
+	var next *pendingCaptcha
+	if len(ch.pending.m[userUid]) > 0 {
+		next = ch.pending.m[userUid][0]
+	}
+	ch.pending.Unlock()
+	if next != nil {
+		ch.sendCaptcha(session, userUid, next)
+	}
+}
+
+/*
+Sends a captcha and starts its clock. If they can't be messaged the mods are told, since there's no way for them to get in on their own
+*/
+func (ch *CaptchaHandler) sendCaptcha(session *discordgo.Session, userUid string, captcha *pendingCaptcha) {
+	ch.pending.Lock()
+	captcha.expires = time.Now().Add(captcha.length)
+	ch.pending.Unlock()
+	guild, err := moeDiscord.GetGuild(captcha.guildUid, session)
+	if err != nil {
+		return
+	}
+	b, err := util.MakeCaptchaImage(captcha.answer)
+	if err != nil {
+		log.Println("Error making captcha image", err)
+		return
+	}
+	dmChannel, err := session.UserChannelCreate(userUid)
+	if err == nil {
+		_, err = session.ChannelMessageSendComplex(dmChannel.ID, &discordgo.MessageSend{
+			Content: "Welcome to " + guild.Name + "! To make sure you're not a bot, please reply with the " + strconv.Itoa(captchaLength) +
+				" letters and numbers in this picture. You have " + strconv.Itoa(int(captcha.length.Minutes())) + " minutes and " +
+				strconv.Itoa(captcha.attemptsLeft) + " tries.",
+			File: &discordgo.File{
+				Name:        "captcha.png",
+				ContentType: "image/png",
+				Reader:      bytes.NewReader(b),
+			},
+		})
+	}
+	if err != nil {
+		log.Println("Error sending captcha to User UID: "+userUid, err)
+		server, err := db.ServerQueryOrInsert(captcha.guildUid)
+		if err == nil && server.BotChannel.Valid {
+			session.ChannelMessageSend(server.BotChannel.String, "Couldn't send a captcha to "+util.UserIdToMention(userUid)+
+				", they may have DMs turned off. They'll be kicked in "+strconv.Itoa(int(captcha.length.Minutes()))+
+				" minutes unless a mod lets them in by hand.")
+		}
+	}
+}
+
+/*
+Answers don't care about case or spaces, since people tend to type what they see with spaces between the letters
+*/
+func checkCaptchaAnswer(answer string, reply string) bool {
+	return strings.EqualFold(answer, strings.Join(strings.Fields(reply), ""))
+}
@@ -0,0 +1,22 @@
+package commands
+
+import "testing"
+
+func TestCaptchaHandler_CheckCaptchaAnswer(t *testing.T) {
+	checks := []struct {
+		reply   string
+		correct bool
+	}{
+		{"AB34XY", true},
+		{"ab34xy", true},
+		{" A B 3 4 X Y ", true},
+		{"AB34X", false},
+		{"AB34XYZ", false},
+		{"", false},
+	}
+	for _, c := range checks {
+		if checkCaptchaAnswer("AB34XY", c.reply) != c.correct {
+			t.Errorf("checkCaptchaAnswer(%q), expected %v", c.reply, c.correct)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	modCasesLimit      = 15
+	modCasesDateFormat = "2006-01-02"
+)
+
+/*
+Looks up a single moderation case, and lets mods change its reason afterwards
+*/
+type CaseCommand struct {
+	ComPrefix string
+}
+
+func (cc *CaseCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-reason"})
+	caseNumber, err := strconv.Atoi(strings.TrimPrefix(args[""], "#"))
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a case number. See `"+cc.ComPrefix+" help` for more info.")
+		return
+	}
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+
+	if reason, ok := args["-reason"]; ok {
+		if len(reason) > db.ModCaseMaxReasonLength {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, the reason has a max length of "+db.ModCaseMaxReasonLengthString)
+			return
+		}
+		newReason := sql.NullString{String: reason, Valid: reason != ""}
+		updated, err := db.ModCaseSetReason(server.Id, caseNumber, newReason)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue updating the case. This is an issue with moebot and not Discord.")
+			return
+		} else if !updated {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't a case #"+strconv.Itoa(caseNumber)+" on this server.")
+			return
+		}
+	}
+
+	modCase, err := db.ModCaseQuery(server.Id, caseNumber)
+	if err == sql.ErrNoRows {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't a case #"+strconv.Itoa(caseNumber)+" on this server.")
+		return
+	} else if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the case. This is an issue with moebot and not Discord.")
+		return
+	}
+	if _, ok := args["-reason"]; ok && modCase.LogMessageUid.Valid {
+		// keep the mod log in line with the case, it's fine if the message has since been deleted
+		pack.session.ChannelMessageEdit(modCase.LogChannelUid.String, modCase.LogMessageUid.String, formatModCase(modCase, cc.ComPrefix))
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, formatModCase(modCase, cc.ComPrefix)+"\nDate: "+modCase.CreatedAt.Format(pointHistoryTimeFormat)+" UTC")
+}
+
+func (cc *CaseCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (cc *CaseCommand) GetCommandKeys() []string {
+	return []string{"CASE"}
+}
+
+func (cc *CaseCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s case <number> [-reason <reason>]` - Mod. Shows a moderation case, or changes its reason with -reason.", commPrefix)
+}
+
+/*
+Lists every case against a user
+*/
+type CasesCommand struct {
+	ComPrefix string
+}
+
+func (cc *CasesCommand) Execute(pack *CommPackage) {
+	if len(pack.params) < 1 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a user. See `"+cc.ComPrefix+" help` for more info.")
+		return
+	}
+	userUid, ok := util.ExtractUserIdFromString(pack.params[0])
+	if !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid user mention or ID.")
+		return
+	}
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	user, err := db.UserQueryOrInsert(userUid)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching that user. This is an issue with moebot and not Discord.")
+		return
+	}
+	cases, err := db.ModCaseQueryUser(server.Id, user.Id, modCasesLimit)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching their cases. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(cases) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That user doesn't have any cases in this server.")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Cases for " + util.UserIdToMention(userUid)))
+	for _, c := range cases {
+		message.WriteString("\n" + util.MakeStringCode("#"+strconv.Itoa(c.CaseNumber)) + " " + c.CreatedAt.Format(modCasesDateFormat) + " " +
+			util.ForceTitleCase(c.Action))
+		if c.DurationMinutes.Valid {
+			message.WriteString(" (" + formatModDuration(c.DurationMinutes) + ")")
+		}
+		message.WriteString(" by " + util.UserIdToMention(c.ModeratorUid))
+		if c.Reason.Valid {
+			message.WriteString(": " + c.Reason.String)
+		}
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func (cc *CasesCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (cc *CasesCommand) GetCommandKeys() []string {
+	return []string{"CASES"}
+}
+
+func (cc *CasesCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s cases <user>` - Mod. Lists the latest %[2]d moderation cases against a user.", commPrefix, modCasesLimit)
+}
@@ -35,6 +35,13 @@ type SetupHandler interface {
 	Setup(session *discordgo.Session)
 }
 
+/*
+Anything that holds onto state that needs to be saved before moebot exits
+*/
+type ShutdownHandler interface {
+	Shutdown(session *discordgo.Session)
+}
+
 func NewCommPackage(session *discordgo.Session, message *discordgo.Message, guild *discordgo.Guild, member *discordgo.Member, channel *discordgo.Channel,
 	params []string, user *types.UserProfile, timer *event.Timer) CommPackage {
 	return CommPackage{
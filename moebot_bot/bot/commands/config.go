@@ -0,0 +1,511 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	configFileName = "moebot-config.json"
+	// Anything bigger than this definitely isn't a config file we made
+	configMaxFileSize = 1024 * 1024
+	// How long an import preview can sit around before it needs to be uploaded again
+	configImportTimeout = 10 * time.Minute
+)
+
+/*
+Exports or imports a server's role and group configuration so that it can be backed up, or copied to another server
+*/
+type ConfigCommand struct {
+	ComPrefix string
+
+	// guild ID + user ID -> import that's waiting to be applied
+	pending struct {
+		sync.Mutex
+		m map[string]*pendingConfigImport
+	}
+}
+
+/*
+The document that gets exported. Roles and groups are stored by name instead of ID so that it can be imported into any server
+*/
+type serverConfigDocument struct {
+	Groups []groupConfig `json:"groups"`
+	Roles  []roleConfig  `json:"roles"`
+}
+
+type groupConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type roleConfig struct {
+	Name                string   `json:"name"`
+	Trigger             string   `json:"trigger,omitempty"`
+	Aliases             []string `json:"aliases,omitempty"`
+	Permission          string   `json:"permission"`
+	ConfirmationMessage string   `json:"confirmationMessage,omitempty"`
+	SecurityAnswer      string   `json:"securityAnswer,omitempty"`
+	Groups              []string `json:"groups"`
+}
+
+type pendingConfigImport struct {
+	created        time.Time
+	groups         []types.RoleGroup
+	roles          []types.Role
+	roleGroupNames map[string][]string
+}
+
+func NewConfigCommand(comPrefix string) *ConfigCommand {
+	result := &ConfigCommand{ComPrefix: comPrefix}
+	result.pending.m = make(map[string]*pendingConfigImport)
+	return result
+}
+
+func (cc *ConfigCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"export", "import", "-apply"})
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching this server. This is an error with moebot not discord!")
+		return
+	}
+	if _, ok := args["export"]; ok {
+		cc.exportConfig(pack, server)
+	} else if _, ok := args["import"]; ok {
+		if _, apply := args["-apply"]; apply {
+			cc.applyImport(pack, server)
+		} else {
+			cc.previewImport(pack, server)
+		}
+	} else {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide either export or import. "+cc.GetCommandHelp(cc.ComPrefix))
+	}
+}
+
+func (cc *ConfigCommand) GetPermLevel() types.Permission {
+	return types.PermGuildOwner
+}
+
+func (cc *ConfigCommand) GetCommandKeys() []string {
+	return []string{"CONFIG"}
+}
+
+func (cc *ConfigCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s config export` - Guild Owner. Exports this server's roles and groups as a file, sent by DM since it has security answers in it. `%[1]s config import` - Guild Owner. "+
+		"Upload an exported file along with this command to preview the changes it would make, then use `%[1]s config import -apply` to save them. "+
+		"Roles and groups not in the file are left alone.", commPrefix)
+}
+
+func (cc *ConfigCommand) exportConfig(pack *CommPackage, server types.Server) {
+	groups, err := db.RoleGroupQueryServer(server)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching groups for this server. This is an error with moebot not discord!")
+		return
+	}
+	roles, err := db.RoleQueryServer(server)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching roles for this server. This is an error with moebot not discord!")
+		return
+	}
+
+	doc := serverConfigDocument{Groups: []groupConfig{}, Roles: []roleConfig{}}
+	groupNames := make(map[int]string)
+	for _, g := range groups {
+		groupNames[g.Id] = g.Name
+		doc.Groups = append(doc.Groups, groupConfig{Name: g.Name, Type: getGroupTypeCode(g.Type)})
+	}
+	var skipped []string
+	for _, r := range roles {
+		guildRole := moeDiscord.FindRoleById(pack.guild.Roles, r.RoleUid)
+		if guildRole == nil {
+			skipped = append(skipped, strings.TrimSpace(r.RoleUid))
+			continue
+		}
+		rc := roleConfig{
+			Name:                guildRole.Name,
+			Trigger:             r.Trigger.String,
+			Aliases:             r.Aliases,
+			Permission:          db.SprintPermission(r.Permission),
+			ConfirmationMessage: r.ConfirmationMessage.String,
+			SecurityAnswer:      r.ConfirmationSecurityAnswer.String,
+			Groups:              []string{},
+		}
+		for _, groupId := range r.Groups {
+			if name, ok := groupNames[groupId]; ok {
+				rc.Groups = append(rc.Groups, name)
+			}
+		}
+		doc.Roles = append(doc.Roles, rc)
+	}
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Println("Error marshalling server config", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error exporting this server. This is an error with moebot not discord!")
+		return
+	}
+	message := "Exported " + strconv.Itoa(len(doc.Roles)) + " roles and " + strconv.Itoa(len(doc.Groups)) + " groups."
+	if len(skipped) > 0 {
+		message += " Skipped roles that no longer exist in this server: " + strings.Join(skipped, ", ")
+	}
+	// the file has every role's security answer in it, so it only goes to whoever asked for it
+	dmChannel, err := pack.session.UserChannelCreate(pack.message.Author.ID)
+	if err == nil {
+		_, err = pack.session.ChannelMessageSendComplex(dmChannel.ID, &discordgo.MessageSend{
+			Content: message,
+			File: &discordgo.File{
+				Name:        configFileName,
+				ContentType: "application/json",
+				Reader:      bytes.NewReader(b),
+			},
+		})
+	}
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, I couldn't DM you the export. Please make sure you can get DMs from members of "+
+			"this server and try again.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Sent you the export in a DM. "+message)
+}
+
+func (cc *ConfigCommand) previewImport(pack *CommPackage, server types.Server) {
+	if len(pack.message.Attachments) != 1 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please attach a single config file to your message. You can get one with `"+
+			cc.ComPrefix+" config export`")
+		return
+	}
+	attachment := pack.message.Attachments[0]
+	if attachment.Size > configMaxFileSize {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That file is too big to be a config file.")
+		return
+	}
+	response, err := http.Get(attachment.URL)
+	if err != nil {
+		log.Println("Error downloading config attachment", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error downloading that file. Please try again.")
+		return
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Println("Error reading config attachment", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error downloading that file. Please try again.")
+		return
+	}
+	var doc serverConfigDocument
+	if err = json.Unmarshal(b, &doc); err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That doesn't look like a valid config file: "+err.Error())
+		return
+	}
+
+	currentGroups, err := db.RoleGroupQueryServer(server)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching groups for this server. This is an error with moebot not discord!")
+		return
+	}
+	currentRoles, err := db.RoleQueryServer(server)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching roles for this server. This is an error with moebot not discord!")
+		return
+	}
+
+	imp, problems := buildConfigImport(doc, pack.guild, server, currentGroups, currentRoles)
+	if len(problems) > 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, truncateLines("Nothing was imported, please fix the following and try again:", problems))
+		return
+	}
+	changes := describeConfigImport(imp, pack.guild, currentGroups, currentRoles)
+	if len(changes) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That config file matches this server already, there's nothing to import.")
+		return
+	}
+
+	cc.pending.Lock()
+	for key, p := range cc.pending.m {
+		// clean up anything that was never applied while we're here
+		if time.Since(p.created) > configImportTimeout {
+			delete(cc.pending.m, key)
+		}
+	}
+	cc.pending.m[pack.guild.ID+pack.message.Author.ID] = imp
+	cc.pending.Unlock()
+	pack.session.ChannelMessageSend(pack.channel.ID, truncateLines("Importing this file will make the following changes. Use `"+cc.ComPrefix+
+		" config import -apply` within "+strconv.Itoa(int(configImportTimeout/time.Minute))+" minutes to save them:", changes))
+}
+
+func (cc *ConfigCommand) applyImport(pack *CommPackage, server types.Server) {
+	key := pack.guild.ID + pack.message.Author.ID
+	cc.pending.Lock()
+	imp, ok := cc.pending.m[key]
+	delete(cc.pending.m, key)
+	cc.pending.Unlock()
+	if !ok || time.Since(imp.created) > configImportTimeout {
+		pack.session.ChannelMessageSend(pack.channel.ID, "You don't have an import waiting to be applied. Upload a config file with `"+cc.ComPrefix+
+			" config import` first.")
+		return
+	}
+	if err := db.ServerRoleConfigApply(server, imp.groups, imp.roles, imp.roleGroupNames); err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error importing that config. No changes were made. This is an error "+
+			"with moebot not discord!")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Imported "+strconv.Itoa(len(imp.roles))+" roles and "+strconv.Itoa(len(imp.groups))+" groups!")
+}
+
+/*
+Validates a config document against the guild it's being imported into, returning either the import ready to apply or everything wrong with it
+*/
+func buildConfigImport(doc serverConfigDocument, guild *discordgo.Guild, server types.Server, currentGroups []types.RoleGroup,
+	currentRoles []types.Role) (imp *pendingConfigImport, problems []string) {
+
+	imp = &pendingConfigImport{created: time.Now(), roleGroupNames: make(map[string][]string)}
+	knownGroups := make(map[string]bool)
+	for _, g := range currentGroups {
+		knownGroups[g.Name] = true
+	}
+	importedGroups := make(map[string]bool)
+	for _, g := range doc.Groups {
+		groupType := db.GetGroupTypeFromString(g.Type)
+		if g.Name == "" {
+			problems = append(problems, "A group is missing a name")
+			continue
+		} else if importedGroups[g.Name] {
+			problems = append(problems, "The group `"+g.Name+"` is listed more than once")
+			continue
+		} else if groupType < 0 {
+			problems = append(problems, "The group `"+g.Name+"` has an unknown type `"+g.Type+"`")
+			continue
+		}
+		importedGroups[g.Name] = true
+		knownGroups[g.Name] = true
+		imp.groups = append(imp.groups, types.RoleGroup{ServerId: server.Id, Name: g.Name, Type: groupType})
+	}
+
+	importedRoles := make(map[string]bool)
+	// upper case trigger -> role ID, so we can catch two roles ending up with the same trigger
+	triggers := make(map[string]string)
+	for _, r := range doc.Roles {
+		guildRole := moeDiscord.FindRoleByName(guild.Roles, r.Name)
+		if guildRole == nil {
+			problems = append(problems, "The role `"+r.Name+"` doesn't exist in this server")
+			continue
+		} else if importedRoles[guildRole.ID] {
+			problems = append(problems, "The role `"+r.Name+"` is listed more than once")
+			continue
+		}
+		importedRoles[guildRole.ID] = true
+
+		role := types.Role{ServerId: server.Id, RoleUid: guildRole.ID, Permission: types.PermAll, Aliases: []string{}}
+		if r.Permission != "" {
+			role.Permission = db.GetPermissionFromString(r.Permission)
+			if !db.IsAssignablePermissionLevel(role.Permission) {
+				problems = append(problems, "The role `"+r.Name+"` has an invalid permission level. Valid levels: "+db.GetAssignableRoles())
+			}
+		}
+		trigger := strings.TrimSpace(r.Trigger)
+		if len(trigger) > db.RoleMaxTriggerLength {
+			problems = append(problems, "The role `"+r.Name+"` has a trigger longer than "+db.RoleMaxTriggerLengthString+" characters")
+		} else if trigger != "" {
+			if other, ok := triggers[strings.ToUpper(trigger)]; ok && other != guildRole.ID {
+				problems = append(problems, "The trigger `"+trigger+"` is used by more than one role")
+			}
+			triggers[strings.ToUpper(trigger)] = guildRole.ID
+			role.Trigger.Scan(trigger)
+		}
+		for _, alias := range r.Aliases {
+			alias = strings.TrimSpace(alias)
+			if alias == "" || len(alias) > db.RoleMaxTriggerLength {
+				problems = append(problems, "The role `"+r.Name+"` has an alias that's empty or longer than "+db.RoleMaxTriggerLengthString+" characters")
+				continue
+			}
+			if other, ok := triggers[strings.ToUpper(alias)]; ok && other != guildRole.ID {
+				problems = append(problems, "The trigger `"+alias+"` is used by more than one role")
+			}
+			triggers[strings.ToUpper(alias)] = guildRole.ID
+			role.Aliases = append(role.Aliases, alias)
+		}
+		if len(r.ConfirmationMessage) > db.MaxMessageLength || len(r.SecurityAnswer) > db.MaxMessageLength {
+			problems = append(problems, "The role `"+r.Name+"` has confirmation text longer than "+db.MaxMessageLengthString+" characters")
+		}
+		if r.ConfirmationMessage != "" {
+			role.ConfirmationMessage.Scan(r.ConfirmationMessage)
+		}
+		if r.SecurityAnswer != "" {
+			securityAnswer := r.SecurityAnswer
+			if !strings.HasPrefix(securityAnswer, "-") {
+				securityAnswer = "-" + securityAnswer
+			}
+			role.ConfirmationSecurityAnswer.Scan(securityAnswer)
+		}
+
+		groupNames := r.Groups
+		if len(groupNames) == 0 {
+			// every role needs to be in a group, same as when it's set up with permit
+			groupNames = []string{db.UncategorizedGroup}
+			if !knownGroups[db.UncategorizedGroup] {
+				knownGroups[db.UncategorizedGroup] = true
+				importedGroups[db.UncategorizedGroup] = true
+				imp.groups = append(imp.groups, types.RoleGroup{ServerId: server.Id, Name: db.UncategorizedGroup, Type: types.GroupTypeAny})
+			}
+		}
+		for _, groupName := range groupNames {
+			if !knownGroups[groupName] {
+				problems = append(problems, "The role `"+r.Name+"` is in the group `"+groupName+"` which isn't in the file or this server")
+			}
+		}
+		imp.roleGroupNames[role.RoleUid] = groupNames
+		imp.roles = append(imp.roles, role)
+	}
+
+	// triggers also can't clash with roles that are already set up but aren't part of the import
+	for _, r := range currentRoles {
+		roleUid := strings.TrimSpace(r.RoleUid)
+		if importedRoles[roleUid] {
+			continue
+		}
+		existingTriggers := r.Aliases
+		if r.Trigger.Valid {
+			existingTriggers = append([]string{r.Trigger.String}, existingTriggers...)
+		}
+		for _, t := range existingTriggers {
+			if _, ok := triggers[strings.ToUpper(t)]; ok {
+				problems = append(problems, "The trigger `"+t+"` is already used by another role in this server")
+			}
+		}
+	}
+	return
+}
+
+/*
+Describes every change an import would make compared to what's currently saved, one line per change
+*/
+func describeConfigImport(imp *pendingConfigImport, guild *discordgo.Guild, currentGroups []types.RoleGroup, currentRoles []types.Role) (changes []string) {
+	groupsByName := make(map[string]types.RoleGroup)
+	groupNames := make(map[int]string)
+	for _, g := range currentGroups {
+		groupsByName[g.Name] = g
+		groupNames[g.Id] = g.Name
+	}
+	for _, g := range imp.groups {
+		old, ok := groupsByName[g.Name]
+		if !ok {
+			changes = append(changes, "+ New group `"+g.Name+"` of type "+db.GetStringFromGroupType(g.Type))
+		} else if old.Type != g.Type {
+			changes = append(changes, "~ Group `"+g.Name+"` type: "+db.GetStringFromGroupType(old.Type)+" -> "+db.GetStringFromGroupType(g.Type))
+		}
+	}
+
+	rolesByUid := make(map[string]types.Role)
+	for _, r := range currentRoles {
+		rolesByUid[strings.TrimSpace(r.RoleUid)] = r
+	}
+	for _, r := range imp.roles {
+		roleName := r.RoleUid
+		if guildRole := moeDiscord.FindRoleById(guild.Roles, r.RoleUid); guildRole != nil {
+			roleName = guildRole.Name
+		}
+		newGroups := imp.roleGroupNames[r.RoleUid]
+		old, ok := rolesByUid[r.RoleUid]
+		if !ok {
+			changes = append(changes, "+ New role `"+roleName+"` with trigger `"+r.Trigger.String+"`, permission "+db.SprintPermission(r.Permission)+
+				", groups `"+strings.Join(newGroups, ", ")+"`")
+			continue
+		}
+		var diffs []string
+		if old.Trigger.String != r.Trigger.String {
+			diffs = append(diffs, "trigger `"+old.Trigger.String+"` -> `"+r.Trigger.String+"`")
+		}
+		if !sameStrings(old.Aliases, r.Aliases) {
+			diffs = append(diffs, "aliases `"+strings.Join(old.Aliases, ", ")+"` -> `"+strings.Join(r.Aliases, ", ")+"`")
+		}
+		if old.Permission != r.Permission {
+			diffs = append(diffs, "permission "+db.SprintPermission(old.Permission)+" -> "+db.SprintPermission(r.Permission))
+		}
+		if old.ConfirmationMessage.String != r.ConfirmationMessage.String {
+			diffs = append(diffs, "confirmation message changed")
+		}
+		if old.ConfirmationSecurityAnswer.String != r.ConfirmationSecurityAnswer.String {
+			diffs = append(diffs, "security answer changed")
+		}
+		var oldGroups []string
+		for _, groupId := range old.Groups {
+			oldGroups = append(oldGroups, groupNames[groupId])
+		}
+		if !sameStrings(oldGroups, newGroups) {
+			diffs = append(diffs, "groups `"+strings.Join(oldGroups, ", ")+"` -> `"+strings.Join(newGroups, ", ")+"`")
+		}
+		if len(diffs) > 0 {
+			changes = append(changes, "~ Role `"+roleName+"`: "+strings.Join(diffs, ", "))
+		}
+	}
+	return
+}
+
+/*
+Checks if both slices have the same strings, ignoring order
+*/
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		if counts[s] == 0 {
+			return false
+		}
+		counts[s]--
+	}
+	return true
+}
+
+/*
+Joins the header and lines into a single message, cutting off any lines that would go over the max message length
+*/
+func truncateLines(header string, lines []string) string {
+	var message strings.Builder
+	message.WriteString(header)
+	for i, line := range lines {
+		remaining := len(lines) - i
+		if message.Len()+len(line)+50 > db.MaxMessageLength {
+			message.WriteString("\n...and " + strconv.Itoa(remaining) + " more")
+			break
+		}
+		message.WriteString("\n")
+		message.WriteString(line)
+	}
+	return message.String()
+}
+
+/*
+Gets the short code for a group type, which is what GetGroupTypeFromString accepts
+*/
+func getGroupTypeCode(groupType types.GroupType) string {
+	switch groupType {
+	case types.GroupTypeAny:
+		return "ANY"
+	case types.GroupTypeExclusive:
+		return "EXC"
+	case types.GroupTypeExclusiveNoRemove:
+		return "ENR"
+	case types.GroupTypeNoMultiples:
+		return "NOM"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const leaderboardPageSize = 10
+
+type LeaderboardCommand struct{}
+
+func (lc *LeaderboardCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-page", "-week", "-month", "-optout", "-optin"})
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	_, optOut := args["-optout"]
+	_, optIn := args["-optin"]
+	if optOut || optIn {
+		lc.setOptOut(pack, server, optOut)
+		return
+	}
+
+	page := 1
+	if pageText, ok := args["-page"]; ok {
+		page, err = strconv.Atoi(pageText)
+		if err != nil || page < 1 {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a page number greater than 0.")
+			return
+		}
+	}
+	var since time.Time
+	windowName := "of all time"
+	if _, ok := args["-week"]; ok {
+		since = time.Now().AddDate(0, 0, -7)
+		windowName = "for the last week"
+	} else if _, ok := args["-month"]; ok {
+		since = time.Now().AddDate(0, -1, 0)
+		windowName = "for the last month"
+	}
+
+	entries, total, err := db.PointEventLeaderboard(server.Id, since, leaderboardPageSize, (page-1)*leaderboardPageSize)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the leaderboard. This is an issue with moebot and not Discord.")
+		return
+	}
+	if total == 0 && page == 1 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Nobody has earned any points "+windowName+" yet!")
+		return
+	}
+	pageCount := (total + leaderboardPageSize - 1) / leaderboardPageSize
+	if len(entries) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There are only "+strconv.Itoa(pageCount)+" pages on the leaderboard.")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Leaderboard " + windowName))
+	message.WriteString(" (page " + strconv.Itoa(page) + "/" + strconv.Itoa(pageCount) + ")")
+	for _, e := range entries {
+		message.WriteString("\n" + strconv.Itoa(e.Position) + ". ")
+		message.WriteString(lc.getDisplayName(pack, e.UserUid))
+		message.WriteString(" - " + strconv.Itoa(e.Points) + " points")
+	}
+	position, _, err := db.PointEventLeaderboardPosition(server.Id, since, pack.message.Author.ID)
+	if err == nil {
+		message.WriteString("\nYou're #" + strconv.Itoa(position.Position) + " with " + strconv.Itoa(position.Points) + " points.")
+	} else if err == sql.ErrNoRows {
+		message.WriteString("\nYou're not on the leaderboard " + windowName + ".")
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func (lc *LeaderboardCommand) GetPermLevel() types.Permission {
+	return types.PermAll
+}
+
+func (lc *LeaderboardCommand) GetCommandKeys() []string {
+	return []string{"LEADERBOARD"}
+}
+
+func (lc *LeaderboardCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s leaderboard [-page <page> -week -month]` - Shows the members with the most points, -week and -month only count "+
+		"points earned from activity. `%[1]s leaderboard -optout` "+
+		"or `-optin` to hide or show yourself on the leaderboard.", commPrefix)
+}
+
+func (lc *LeaderboardCommand) setOptOut(pack *CommPackage, server types.Server, optOut bool) {
+	user, err := db.UserQueryOrInsert(pack.message.Author.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching your user. This is an issue with moebot and not Discord.")
+		return
+	}
+	if db.UserServerRankSetLeaderboardOptOut(user.Id, server.Id, optOut) != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue updating your settings. This is an issue with moebot and not Discord.")
+		return
+	}
+	if optOut {
+		pack.session.ChannelMessageSend(pack.channel.ID, pack.message.Author.Mention()+" you'll no longer show up on the leaderboard.")
+	} else {
+		pack.session.ChannelMessageSend(pack.channel.ID, pack.message.Author.Mention()+" you'll show up on the leaderboard again.")
+	}
+}
+
+/*
+Gets a name for the user without mentioning them, since nobody wants a ping every time someone checks the leaderboard
+*/
+func (lc *LeaderboardCommand) getDisplayName(pack *CommPackage, userUid string) string {
+	member, err := moeDiscord.GetMember(userUid, pack.guild.ID, pack.session)
+	if err != nil || member.User == nil {
+		// most likely left the server
+		return util.MakeStringItalic("Unknown member")
+	}
+	name := member.User.Username
+	if member.Nick != "" {
+		name = member.Nick
+	}
+	// names can have things like @everyone in them, break those up so they don't actually ping
+	return strings.Replace(name, "@", "@\u200b", -1)
+}
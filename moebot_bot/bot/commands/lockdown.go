@@ -0,0 +1,423 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	// the most discord allows, 6 hours
+	slowmodeMaxSeconds    = 21600
+	slowmodeCheckInterval = time.Minute
+)
+
+/*
+Stops @everyone from sending messages in a channel, a category, or the whole server, or lets them talk again. The @everyone overwrite from
+before the lockdown is saved so unlocking puts it back exactly as it was
+*/
+type LockdownCommand struct {
+	ComPrefix string
+	Unlock    bool
+}
+
+func (lc *LockdownCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-category", "-server"})
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	everyone := moeDiscord.GetEveryoneRoleForServer(pack.session, server.Id)
+	if everyone == nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the @everyone role. Please try again later.")
+		return
+	}
+	channelUids, ok := lc.findChannels(pack, server, args)
+	if !ok || len(channelUids) == 0 {
+		return
+	}
+
+	changed, failed := 0, 0
+	for _, channelUid := range channelUids {
+		var done bool
+		if lc.Unlock {
+			done, err = unlockChannel(pack.session, everyone.ID, channelUid)
+		} else {
+			done, err = lockChannel(pack.session, server, everyone.ID, channelUid)
+		}
+		if err != nil {
+			log.Println("Error changing lockdown for channel "+channelUid, err)
+			failed++
+			continue
+		}
+		if done {
+			changed++
+			// hidden staff channels don't need to be told, nobody that was locked out can see them
+			if overwrite, err := moeDiscord.GetCurrentRolePermissionsForChannel(pack.session, channelUid, everyone.ID); err != nil ||
+				!everyoneCanRead(everyone.Permissions, overwrite) {
+				continue
+			}
+			if lc.Unlock {
+				pack.session.ChannelMessageSend(channelUid, "This channel has been unlocked, everyone can talk again.")
+			} else {
+				pack.session.ChannelMessageSend(channelUid, "This channel has been locked by a mod, hang tight.")
+			}
+		}
+	}
+
+	action := "Locked"
+	if lc.Unlock {
+		action = "Unlocked"
+	}
+	message := action + " " + strconv.Itoa(changed) + " channels."
+	if changed == 0 && failed == 0 {
+		if lc.Unlock {
+			message = "None of those channels are locked."
+		} else {
+			message = "Nothing to lock, those channels are already locked or @everyone can't talk in them."
+		}
+	}
+	if failed > 0 {
+		message += " Couldn't change " + strconv.Itoa(failed) + " channels, please make sure moebot can manage permissions in them."
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message)
+	if changed > 0 && server.ModLogChannel.Valid {
+		pack.session.ChannelMessageSend(server.ModLogChannel.String, util.MakeStringBold(action)+" "+strconv.Itoa(changed)+" channels by "+
+			pack.message.Author.Mention()+" from <#"+pack.channel.ID+">.")
+	}
+}
+
+func (lc *LockdownCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (lc *LockdownCommand) GetCommandKeys() []string {
+	if lc.Unlock {
+		return []string{"UNLOCK"}
+	}
+	return []string{"LOCKDOWN"}
+}
+
+func (lc *LockdownCommand) GetCommandHelp(commPrefix string) string {
+	if lc.Unlock {
+		return fmt.Sprintf("`%[1]s unlock [channel] [-category [channel]] [-server]` - Mod. Undoes a lockdown, putting @everyone's permissions "+
+			"back to what they were before.", commPrefix)
+	}
+	return fmt.Sprintf("`%[1]s lockdown [channel] [-category [channel]] [-server]` - Mod. Stops @everyone from sending messages in this or "+
+		"the given channel, every channel in its category, or every channel in the server. Undo it with `%[1]s unlock`.", commPrefix)
+}
+
+/*
+Works out which channels to lock or unlock, letting the caller know if there's an issue. With no channel given it's the one the command was
+used in, and a category on its own means every channel in it
+*/
+func (lc *LockdownCommand) findChannels(pack *CommPackage, server types.Server, args map[string]string) (channelUids []string, ok bool) {
+	if _, wholeServer := args["-server"]; wholeServer {
+		if lc.Unlock {
+			// go by what's locked rather than what's there, in case a channel was moved or changed type since
+			lockdowns, err := db.ChannelLockdownQueryServer(server.Id)
+			if err != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching locked channels. This is an issue with moebot and not Discord.")
+				return nil, false
+			}
+			for _, l := range lockdowns {
+				channelUids = append(channelUids, l.ChannelUid)
+			}
+			if len(channelUids) == 0 {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Nothing in this server is locked.")
+			}
+			return channelUids, true
+		}
+		return lockdownTextChannels(pack.guild.Channels, ""), true
+	}
+
+	target := pack.channel
+	channelText := args[""]
+	categoryText, wholeCategory := args["-category"]
+	if categoryText != "" {
+		channelText = categoryText
+	}
+	if channelText != "" {
+		channelUid := channelText
+		if strings.HasPrefix(channelText, "<#") {
+			channelUid, _ = util.ExtractChannelIdFromString(channelText)
+		}
+		c, err := moeDiscord.GetChannel(channelUid, pack.session)
+		if err != nil || c.GuildID != pack.guild.ID {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid channel or category in this server.")
+			return nil, false
+		}
+		target = c
+	}
+
+	if target.Type == discordgo.ChannelTypeGuildCategory {
+		wholeCategory = true
+	}
+	if !wholeCategory {
+		if target.Type != discordgo.ChannelTypeGuildText {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Only text channels can be locked.")
+			return nil, false
+		}
+		return []string{target.ID}, true
+	}
+	categoryUid := target.ParentID
+	if target.Type == discordgo.ChannelTypeGuildCategory {
+		categoryUid = target.ID
+	}
+	if categoryUid == "" {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That channel isn't in a category.")
+		return nil, false
+	}
+	channelUids = lockdownTextChannels(pack.guild.Channels, categoryUid)
+	if len(channelUids) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That category doesn't have any text channels.")
+	}
+	return channelUids, true
+}
+
+/*
+Gets every text channel, or only the ones in a category if one is given
+*/
+func lockdownTextChannels(channels []*discordgo.Channel, categoryUid string) (channelUids []string) {
+	for _, c := range channels {
+		if c.Type == discordgo.ChannelTypeGuildText && (categoryUid == "" || c.ParentID == categoryUid) {
+			channelUids = append(channelUids, c.ID)
+		}
+	}
+	return
+}
+
+/*
+Denies @everyone sending messages in a channel, saving their overwrite first. Returns false if there was nothing to do, either because it's
+already locked or nobody could talk there anyway
+*/
+func lockChannel(session *discordgo.Session, server types.Server, everyoneUid string, channelUid string) (bool, error) {
+	if _, err := db.ChannelLockdownQueryChannel(channelUid); err == nil {
+		return false, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	current, err := moeDiscord.GetCurrentRolePermissionsForChannel(session, channelUid, everyoneUid)
+	if err != nil {
+		return false, err
+	}
+	if current.Deny&discordgo.PermissionSendMessages != 0 {
+		return false, nil
+	}
+	lockdown, err := db.ChannelLockdownInsert(types.ChannelLockdown{
+		ServerId:      server.Id,
+		ChannelUid:    channelUid,
+		PreviousAllow: current.Allow,
+		PreviousDeny:  current.Deny,
+	})
+	if err != nil {
+		return false, err
+	}
+	allow, deny := lockdownOverwrite(current.Allow, current.Deny)
+	if err = session.ChannelPermissionSet(channelUid, everyoneUid, "role", allow, deny); err != nil {
+		db.ChannelLockdownDelete(lockdown.Id)
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+Puts @everyone's overwrite in a channel back to what it was before it was locked. Returns false if it wasn't locked
+*/
+func unlockChannel(session *discordgo.Session, everyoneUid string, channelUid string) (bool, error) {
+	lockdown, err := db.ChannelLockdownQueryChannel(channelUid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if lockdown.PreviousAllow == 0 && lockdown.PreviousDeny == 0 {
+		// there wasn't an overwrite to begin with
+		err = session.ChannelPermissionDelete(channelUid, everyoneUid)
+	} else {
+		err = session.ChannelPermissionSet(channelUid, everyoneUid, "role", lockdown.PreviousAllow, lockdown.PreviousDeny)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, db.ChannelLockdownDelete(lockdown.Id)
+}
+
+/*
+Checks if @everyone can see a channel, going by the role's own permissions and its overwrite in the channel
+*/
+func everyoneCanRead(everyonePermissions int, overwrite *discordgo.PermissionOverwrite) bool {
+	if overwrite.Allow&discordgo.PermissionReadMessages != 0 {
+		return true
+	}
+	return everyonePermissions&discordgo.PermissionReadMessages != 0 && overwrite.Deny&discordgo.PermissionReadMessages == 0
+}
+
+/*
+Takes send messages out of an overwrite's allow and puts it in its deny, leaving everything else alone
+*/
+func lockdownOverwrite(allow int, deny int) (int, int) {
+	return allow &^ discordgo.PermissionSendMessages, deny | discordgo.PermissionSendMessages
+}
+
+/*
+Changes a channel's slowmode, optionally only for a while before it goes back to what it was
+*/
+type SlowmodeCommand struct {
+	ComPrefix string
+	stopCh    chan struct{}
+}
+
+func NewSlowmodeCommand(comPrefix string) *SlowmodeCommand {
+	return &SlowmodeCommand{ComPrefix: comPrefix, stopCh: make(chan struct{})}
+}
+
+func (sc *SlowmodeCommand) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(slowmodeCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sc.expireSlowmodes(session)
+			case <-sc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (sc *SlowmodeCommand) Shutdown(session *discordgo.Session) {
+	close(sc.stopCh)
+}
+
+func (sc *SlowmodeCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-for", "-channel"})
+	seconds, ok := parseSlowmodeSeconds(args[""])
+	if !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of seconds from 0 to "+strconv.Itoa(slowmodeMaxSeconds)+
+			", or off. See `"+sc.ComPrefix+" help` for more info.")
+		return
+	}
+	var length time.Duration
+	lengthText, timed := args["-for"]
+	if timed {
+		var err error
+		if length, err = util.ParseDuration(lengthText); err != nil || length <= 0 {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a length of time like `30m` or `2h` for -for.")
+			return
+		}
+	}
+	channel := pack.channel
+	if channelText, ok := args["-channel"]; ok {
+		channelUid := channelText
+		if strings.HasPrefix(channelText, "<#") {
+			channelUid, _ = util.ExtractChannelIdFromString(channelText)
+		}
+		c, err := moeDiscord.GetChannel(channelUid, pack.session)
+		if err != nil || c.GuildID != pack.guild.ID || c.Type != discordgo.ChannelTypeGuildText {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid text channel in this server for -channel.")
+			return
+		}
+		channel = c
+	}
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	previous, err := moeDiscord.GetChannelSlowmode(pack.session, channel.ID)
+	if err == nil {
+		err = moeDiscord.SetChannelSlowmode(pack.session, channel.ID, seconds)
+	}
+	if err != nil {
+		log.Println("Error changing slowmode for channel "+channel.ID, err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue changing the slowmode. Please make sure moebot can manage "+
+			"that channel.")
+		return
+	}
+
+	message := "Slowmode in <#" + channel.ID + "> is now " + formatSlowmode(seconds)
+	if !timed {
+		// a change with no end replaces any timed one that was still going
+		db.SlowmodeTimerDelete(channel.ID)
+		pack.session.ChannelMessageSend(pack.channel.ID, message+".")
+		return
+	}
+	err = db.SlowmodeTimerUpsert(types.SlowmodeTimer{
+		ServerId:        server.Id,
+		ChannelUid:      channel.ID,
+		PreviousSeconds: previous,
+		ExpiresAt:       time.Now().Add(length),
+	})
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, message+", but there was an issue saving when to change it back. It'll need to be "+
+			"changed back by hand.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message+" for the next "+lengthText+".")
+}
+
+func (sc *SlowmodeCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (sc *SlowmodeCommand) GetCommandKeys() []string {
+	return []string{"SLOWMODE"}
+}
+
+func (sc *SlowmodeCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s slowmode <seconds|off> [-for <duration>] [-channel <channel>]` - Mod. Sets how long everyone has to wait "+
+		"between messages in this or the given channel. With -for, like `30m` or `2h`, it goes back to what it was once the time is up.", commPrefix)
+}
+
+/*
+Puts back the slowmode on every channel whose timed change has run out
+*/
+func (sc *SlowmodeCommand) expireSlowmodes(session *discordgo.Session) {
+	timers, err := db.SlowmodeTimerQueryExpired()
+	if err != nil {
+		return
+	}
+	for _, t := range timers {
+		if err = moeDiscord.SetChannelSlowmode(session, t.ChannelUid, t.PreviousSeconds); err != nil {
+			log.Println("Failed to put back slowmode for channel "+t.ChannelUid, err)
+			if !moeDiscord.IsGoneOrForbidden(err) {
+				// discord is probably having issues, so keep the timer around and try again next time
+				continue
+			}
+			// the channel is gone or moebot can't manage it anymore, either way there's nothing left to put back
+		}
+		db.SlowmodeTimerDelete(t.ChannelUid)
+	}
+}
+
+func parseSlowmodeSeconds(text string) (int, bool) {
+	if strings.EqualFold(text, "off") {
+		return 0, true
+	}
+	seconds, err := strconv.Atoi(text)
+	if err != nil || seconds < 0 || seconds > slowmodeMaxSeconds {
+		return 0, false
+	}
+	return seconds, true
+}
+
+func formatSlowmode(seconds int) string {
+	if seconds == 0 {
+		return "off"
+	} else if seconds == 1 {
+		return "1 second"
+	}
+	return strconv.Itoa(seconds) + " seconds"
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestLockdownCommand_LockdownOverwrite(t *testing.T) {
+	allow, deny := lockdownOverwrite(discordgo.PermissionSendMessages|discordgo.PermissionReadMessages, discordgo.PermissionAddReactions)
+	if allow != discordgo.PermissionReadMessages {
+		t.Errorf("Expected only send messages to be taken out of allow, got %d", allow)
+	}
+	if deny != discordgo.PermissionAddReactions|discordgo.PermissionSendMessages {
+		t.Errorf("Expected send messages to be added to deny, got %d", deny)
+	}
+}
+
+func TestLockdownCommand_LockdownTextChannels(t *testing.T) {
+	channels := []*discordgo.Channel{
+		{ID: "1", Type: discordgo.ChannelTypeGuildCategory},
+		{ID: "2", Type: discordgo.ChannelTypeGuildText, ParentID: "1"},
+		{ID: "3", Type: discordgo.ChannelTypeGuildVoice, ParentID: "1"},
+		{ID: "4", Type: discordgo.ChannelTypeGuildText},
+	}
+	if actual := lockdownTextChannels(channels, "1"); len(actual) != 1 || actual[0] != "2" {
+		t.Errorf("Expected only the text channel in the category, got %v", actual)
+	}
+	if actual := lockdownTextChannels(channels, ""); len(actual) != 2 || actual[0] != "2" || actual[1] != "4" {
+		t.Errorf("Expected every text channel, got %v", actual)
+	}
+}
+
+func TestSlowmodeCommand_ParseSlowmodeSeconds(t *testing.T) {
+	checks := []struct {
+		text     string
+		expected int
+		valid    bool
+	}{
+		{"off", 0, true},
+		{"OFF", 0, true},
+		{"0", 0, true},
+		{"30", 30, true},
+		{"21600", 21600, true},
+		{"21601", 0, false},
+		{"-5", 0, false},
+		{"", 0, false},
+		{"fast", 0, false},
+	}
+	for _, c := range checks {
+		actual, valid := parseSlowmodeSeconds(c.text)
+		if actual != c.expected || valid != c.valid {
+			t.Errorf("parseSlowmodeSeconds(%q) = %d, %v, expected %d, %v", c.text, actual, valid, c.expected, c.valid)
+		}
+	}
+}
+
+func TestSlowmodeCommand_FormatSlowmode(t *testing.T) {
+	if formatSlowmode(0) != "off" || formatSlowmode(1) != "1 second" || formatSlowmode(90) != "90 seconds" {
+		t.Error("Unexpected slowmode formatting")
+	}
+}
+
+func TestLockdownCommand_EveryoneCanRead(t *testing.T) {
+	read := discordgo.PermissionReadMessages
+	checks := []struct {
+		name        string
+		permissions int
+		overwrite   discordgo.PermissionOverwrite
+		expected    bool
+	}{
+		{"no overwrite", read, discordgo.PermissionOverwrite{}, true},
+		{"hidden channel", read, discordgo.PermissionOverwrite{Deny: read}, false},
+		{"role can't read", 0, discordgo.PermissionOverwrite{}, false},
+		{"allowed in channel", 0, discordgo.PermissionOverwrite{Allow: read}, true},
+	}
+	for _, c := range checks {
+		if actual := everyoneCanRead(c.permissions, &c.overwrite); actual != c.expected {
+			t.Errorf("%s: expected %t, got %t", c.name, c.expected, actual)
+		}
+	}
+}
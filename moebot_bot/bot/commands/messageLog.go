@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"container/list"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	// Discord doesn't say what a message used to say, so this is how many recent messages moebot remembers across every server
+	messageLogCacheSize = 10000
+	// keeps both the before and after of an edit under discord's message limit
+	messageLogMaxContent = 800
+)
+
+/*
+Posts edited and deleted messages to a server's message log channel. Only messages moebot has seen since starting up can be shown
+*/
+type MessageLogHandler struct {
+	cache struct {
+		sync.Mutex
+		// message ID -> element holding a cachedMessage, oldest at the front of the order list
+		m     map[string]*list.Element
+		order *list.List
+	}
+}
+
+type cachedMessage struct {
+	id          string
+	channelUid  string
+	authorUid   string
+	authorName  string
+	content     string
+	attachments []string
+}
+
+func NewMessageLogHandler() *MessageLogHandler {
+	mh := &MessageLogHandler{}
+	mh.cache.m = make(map[string]*list.Element)
+	mh.cache.order = list.New()
+	return mh
+}
+
+func (mh *MessageLogHandler) EventHandlers() []interface{} {
+	return []interface{}{mh.messageLogCreate, mh.messageLogUpdate, mh.messageLogDelete, mh.messageLogDeleteBulk}
+}
+
+func (mh *MessageLogHandler) messageLogCreate(session *discordgo.Session, message *discordgo.MessageCreate) {
+	if message.Author == nil || message.Author.Bot {
+		return
+	}
+	if _, ok := mh.getLogChannel(session, message.ChannelID); !ok {
+		return
+	}
+	mh.remember(newCachedMessage(message.Message))
+}
+
+func (mh *MessageLogHandler) messageLogUpdate(session *discordgo.Session, message *discordgo.MessageUpdate) {
+	// embeds loading in also count as an update, but those never come with an author
+	if message.Author == nil || message.Author.Bot {
+		return
+	}
+	logChannel, ok := mh.getLogChannel(session, message.ChannelID)
+	if !ok {
+		return
+	}
+	after := newCachedMessage(message.Message)
+	before, found := mh.forget(message.ID)
+	mh.remember(after)
+	if found && before.content == after.content {
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString(util.MakeStringBold("Message edited") + " by " + util.UserIdToMention(after.authorUid) + " (" + after.authorName + ") in <#" +
+		after.channelUid + ">")
+	if found {
+		text.WriteString("\n" + util.MakeStringBold("Before:") + " " + formatLogContent(before.content))
+	} else {
+		text.WriteString("\n" + util.MakeStringBold("Before:") + " " + util.MakeStringItalic("too old to remember"))
+	}
+	text.WriteString("\n" + util.MakeStringBold("After:") + " " + formatLogContent(after.content))
+	text.WriteString("\nhttps://discordapp.com/channels/" + message.GuildID + "/" + after.channelUid + "/" + after.id)
+	session.ChannelMessageSend(logChannel, text.String())
+}
+
+func (mh *MessageLogHandler) messageLogDelete(session *discordgo.Session, message *discordgo.MessageDelete) {
+	logChannel, ok := mh.getLogChannel(session, message.ChannelID)
+	if !ok {
+		return
+	}
+	deleted, found := mh.forget(message.ID)
+	if !found {
+		// most likely from before moebot started, or from a bot
+		return
+	}
+	session.ChannelMessageSend(logChannel, formatDeletedMessage(deleted))
+}
+
+func (mh *MessageLogHandler) messageLogDeleteBulk(session *discordgo.Session, bulk *discordgo.MessageDeleteBulk) {
+	logChannel, ok := mh.getLogChannel(session, bulk.ChannelID)
+	if !ok {
+		return
+	}
+	var deleted []cachedMessage
+	for _, id := range bulk.Messages {
+		if m, found := mh.forget(id); found {
+			deleted = append(deleted, m)
+		}
+	}
+	summary := util.MakeStringBold(strconv.Itoa(len(bulk.Messages))+" messages bulk deleted") + " in <#" + bulk.ChannelID + ">, " +
+		strconv.Itoa(len(deleted)) + " of them were remembered."
+	if len(deleted) == 0 {
+		session.ChannelMessageSend(logChannel, summary)
+		return
+	}
+	// one message with a file instead of one per deleted message, a big purge would otherwise flood the log
+	_, err := session.ChannelMessageSendComplex(logChannel, &discordgo.MessageSend{
+		Content: summary,
+		File: &discordgo.File{
+			Name:        "bulk-delete-" + bulk.ChannelID + ".txt",
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(formatBulkDeleteTranscript(deleted)),
+		},
+	})
+	if err != nil {
+		log.Println("Error posting bulk delete log", err)
+	}
+}
+
+/*
+Gets where to log messages from the given channel. Returns false if the server doesn't log messages or the channel is excluded
+*/
+func (mh *MessageLogHandler) getLogChannel(session *discordgo.Session, channelUid string) (string, bool) {
+	channel, err := moeDiscord.GetChannel(channelUid, session)
+	if err != nil || channel.GuildID == "" {
+		return "", false
+	}
+	server, err := db.ServerQueryOrInsert(channel.GuildID)
+	if err != nil || !server.Enabled || !server.MessageLogChannel.Valid {
+		return "", false
+	}
+	// posting about the log channel in the log channel would never end
+	if channelUid == server.MessageLogChannel.String || util.StrContains(server.MessageLogExcludedChannels, channelUid, util.CaseSensitive) {
+		return "", false
+	}
+	return server.MessageLogChannel.String, true
+}
+
+func (mh *MessageLogHandler) remember(m cachedMessage) {
+	mh.cache.Lock()
+	defer mh.cache.Unlock()
+	if e, ok := mh.cache.m[m.id]; ok {
+		mh.cache.order.Remove(e)
+	}
+	mh.cache.m[m.id] = mh.cache.order.PushBack(m)
+	for mh.cache.order.Len() > messageLogCacheSize {
+		oldest := mh.cache.order.Front()
+		mh.cache.order.Remove(oldest)
+		delete(mh.cache.m, oldest.Value.(cachedMessage).id)
+	}
+}
+
+func (mh *MessageLogHandler) forget(messageUid string) (m cachedMessage, found bool) {
+	mh.cache.Lock()
+	defer mh.cache.Unlock()
+	e, ok := mh.cache.m[messageUid]
+	if !ok {
+		return m, false
+	}
+	mh.cache.order.Remove(e)
+	delete(mh.cache.m, messageUid)
+	return e.Value.(cachedMessage), true
+}
+
+func newCachedMessage(message *discordgo.Message) cachedMessage {
+	m := cachedMessage{
+		id:         message.ID,
+		channelUid: message.ChannelID,
+		content:    message.Content,
+	}
+	if message.Author != nil {
+		m.authorUid = message.Author.ID
+		m.authorName = message.Author.String()
+	}
+	for _, a := range message.Attachments {
+		m.attachments = append(m.attachments, a.URL)
+	}
+	return m
+}
+
+func formatDeletedMessage(m cachedMessage) string {
+	var text strings.Builder
+	text.WriteString(util.MakeStringBold("Message deleted") + " from " + util.UserIdToMention(m.authorUid) + " (" + m.authorName + ") in <#" +
+		m.channelUid + ">")
+	text.WriteString("\n" + formatLogContent(m.content))
+	if len(m.attachments) > 0 {
+		text.WriteString("\n" + util.MakeStringBold("Attachments:") + " " + strings.Join(m.attachments, " "))
+	}
+	return text.String()
+}
+
+/*
+Writes out remembered messages oldest first, one per line, for attaching to the log
+*/
+func formatBulkDeleteTranscript(deleted []cachedMessage) string {
+	sorted := make([]cachedMessage, len(deleted))
+	copy(sorted, deleted)
+	sort.Slice(sorted, func(i, j int) bool {
+		return util.SnowflakeLess(sorted[i].id, sorted[j].id)
+	})
+	var transcript strings.Builder
+	for _, m := range sorted {
+		created, _ := discordgo.SnowflakeTimestamp(m.id)
+		transcript.WriteString("[" + created.UTC().Format("2006-01-02 15:04:05") + "] " + m.authorName + " (" + m.authorUid + "): " + m.content + "\n")
+		for _, a := range m.attachments {
+			transcript.WriteString("    attachment: " + a + "\n")
+		}
+	}
+	return transcript.String()
+}
+
+/*
+Cuts content down to size and puts it in a quote so it can't ping anyone or be confused with moebot's own text
+*/
+func formatLogContent(content string) string {
+	if content == "" {
+		return util.MakeStringItalic("no text")
+	}
+	runes := []rune(content)
+	if len(runes) > messageLogMaxContent {
+		content = string(runes[:messageLogMaxContent]) + "..."
+	}
+	// mentions in a code block don't ping, so this is safe to repost
+	return "```" + strings.Replace(content, "```", "'''", -1) + "```"
+}
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMessageLogHandler_Cache(t *testing.T) {
+	mh := NewMessageLogHandler()
+	for i := 0; i < messageLogCacheSize+10; i++ {
+		mh.remember(cachedMessage{id: strconv.Itoa(i), content: "message " + strconv.Itoa(i)})
+	}
+	if _, found := mh.forget("0"); found {
+		t.Error("Oldest message should have been dropped from the cache")
+	}
+	m, found := mh.forget(strconv.Itoa(messageLogCacheSize + 9))
+	if !found || m.content != "message "+strconv.Itoa(messageLogCacheSize+9) {
+		t.Error("Newest message should still be in the cache")
+	}
+	if _, found := mh.forget(strconv.Itoa(messageLogCacheSize + 9)); found {
+		t.Error("Forgotten message should be gone from the cache")
+	}
+	if len(mh.cache.m) != mh.cache.order.Len() {
+		t.Errorf("Cache map and order out of sync: %d != %d", len(mh.cache.m), mh.cache.order.Len())
+	}
+}
+
+func TestMessageLogHandler_FormatLogContent(t *testing.T) {
+	if !strings.Contains(formatLogContent(""), "no text") {
+		t.Error("Empty content should say there was no text")
+	}
+	formatted := formatLogContent("hi ```@everyone```")
+	if strings.Count(formatted, "```") != 2 {
+		t.Errorf("Code blocks inside content should be escaped, got %q", formatted)
+	}
+	long := formatLogContent(strings.Repeat("あ", messageLogMaxContent+50))
+	if len([]rune(long)) != messageLogMaxContent+len("``````...") {
+		t.Errorf("Long content should be cut to %d characters, got %d", messageLogMaxContent, len([]rune(long)))
+	}
+}
+
+func TestMessageLogHandler_FormatBulkDeleteTranscript(t *testing.T) {
+	transcript := formatBulkDeleteTranscript([]cachedMessage{
+		{id: "1000", authorName: "b#0002", authorUid: "2", content: "second"},
+		{id: "999", authorName: "a#0001", authorUid: "1", content: "first", attachments: []string{"https://cdn/a.png"}},
+	})
+	if !(strings.Index(transcript, "first") < strings.Index(transcript, "second")) {
+		t.Errorf("Transcript should be oldest first, got %q", transcript)
+	}
+	if !strings.Contains(transcript, "attachment: https://cdn/a.png") {
+		t.Errorf("Transcript should list attachments, got %q", transcript)
+	}
+}
@@ -0,0 +1,350 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/bot/permissions"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const modExpireInterval = time.Minute
+
+/*
+A single moderation action (warn, mute, kick, and so on). Every action gets recorded as a numbered case and posted to the server's mod log
+*/
+type ModerationCommand struct {
+	ComPrefix string
+	Action    string
+	Checker   permissions.PermissionChecker
+}
+
+func (mc *ModerationCommand) Execute(pack *CommPackage) {
+	if len(pack.params) < 1 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a user. See `"+mc.ComPrefix+" help` for more info.")
+		return
+	}
+	userUid, ok := util.ExtractUserIdFromString(pack.params[0])
+	if !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid user mention or ID.")
+		return
+	}
+	if userUid == pack.message.Author.ID || userUid == pack.session.State.User.ID || userUid == pack.guild.OwnerID {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, you can't "+mc.Action+" that user.")
+		return
+	}
+	// members that have left can still be banned or unbanned by ID, so only check their permissions if they're still here
+	member, err := pack.session.State.Member(pack.guild.ID, userUid)
+	if err != nil {
+		member, _ = pack.session.GuildMember(pack.guild.ID, userUid)
+	}
+	if member != nil && mc.Checker.HasModPerm(userUid, member.Roles, pack.guild) {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, you can't "+mc.Action+" another mod.")
+		return
+	}
+	if member == nil && mc.Action != types.ModActionBan && mc.Action != types.ModActionTempBan && mc.Action != types.ModActionUnban {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, that user isn't in this server.")
+		return
+	}
+
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	user, err := db.UserQueryOrInsert(userUid)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching that user. This is an issue with moebot and not Discord.")
+		return
+	}
+	modCase := types.ModCase{
+		ServerId:     server.Id,
+		GuildUid:     pack.guild.ID,
+		Action:       mc.Action,
+		UserId:       user.Id,
+		UserUid:      userUid,
+		ModeratorId:  pack.user.Id,
+		ModeratorUid: pack.message.Author.ID,
+	}
+
+	reasonParams := pack.params[1:]
+	if mc.Action == types.ModActionMute || mc.Action == types.ModActionTempBan {
+		var duration time.Duration
+		if len(reasonParams) > 0 {
+			duration, err = util.ParseDuration(reasonParams[0])
+		}
+		if len(reasonParams) > 0 && err == nil && duration > 0 {
+			modCase.DurationMinutes.Scan(int64(duration / time.Minute))
+			modCase.ExpiresAt = time.Now().Add(duration)
+			reasonParams = reasonParams[1:]
+		} else if mc.Action == types.ModActionTempBan {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide how long the ban lasts, for example `1D12h` for a day and a half.")
+			return
+		}
+	}
+	if len(reasonParams) > 0 {
+		reason := strings.Join(reasonParams, " ")
+		if len(reason) > db.ModCaseMaxReasonLength {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, the reason has a max length of "+db.ModCaseMaxReasonLengthString)
+			return
+		}
+		modCase.Reason.Scan(reason)
+	}
+
+	if !mc.applyAction(pack, server, modCase) {
+		return
+	}
+	modCase, err = db.ModCaseInsert(modCase)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, the "+mc.Action+" went through but there was an issue saving the case. "+
+			"This is an issue with moebot and not Discord.")
+		return
+	}
+	postModCase(pack.session, server, modCase, mc.ComPrefix)
+	pack.session.ChannelMessageSend(pack.channel.ID, "Case #"+strconv.Itoa(modCase.CaseNumber)+": "+describeModAction(modCase)+".")
+}
+
+/*
+Does whatever the action is on discord's side. Anyone being removed from the server gets told why first, since they can't be messaged afterwards
+*/
+func (mc *ModerationCommand) applyAction(pack *CommPackage, server types.Server, modCase types.ModCase) bool {
+	var err error
+	// discord's audit log takes an empty reason as no reason
+	reason := modCase.Reason.String
+	switch mc.Action {
+	case types.ModActionWarn:
+		if !notifyModAction(pack.session, pack.guild, modCase) {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Couldn't message that user about their warning, they may have DMs turned off.")
+		}
+		return true
+	case types.ModActionMute, types.ModActionUnmute:
+		if !server.MuteRole.Valid || moeDiscord.FindRoleById(pack.guild.Roles, server.MuteRole.String) == nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, this server doesn't have a mute role. Set one with `"+mc.ComPrefix+
+				" server MuteRole <role name>`.")
+			return false
+		}
+		if mc.Action == types.ModActionMute {
+			err = pack.session.GuildMemberRoleAdd(pack.guild.ID, modCase.UserUid, server.MuteRole.String)
+		} else {
+			err = pack.session.GuildMemberRoleRemove(pack.guild.ID, modCase.UserUid, server.MuteRole.String)
+		}
+		if err == nil {
+			notifyModAction(pack.session, pack.guild, modCase)
+		}
+	case types.ModActionKick:
+		notifyModAction(pack.session, pack.guild, modCase)
+		err = pack.session.GuildMemberDeleteWithReason(pack.guild.ID, modCase.UserUid, reason)
+	case types.ModActionBan, types.ModActionTempBan, types.ModActionUnban:
+		if mc.Action == types.ModActionUnban {
+			err = pack.session.GuildBanDelete(pack.guild.ID, modCase.UserUid)
+		} else {
+			notifyModAction(pack.session, pack.guild, modCase)
+			err = pack.session.GuildBanCreateWithReason(pack.guild.ID, modCase.UserUid, reason, 0)
+		}
+	}
+	if err != nil {
+		log.Println("Error applying "+mc.Action+" to User UID: "+modCase.UserUid, err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue applying the "+mc.Action+". Please make sure moebot has "+
+			"permission to do that and that her role is above theirs.")
+		return false
+	}
+	// the newest mute or ban is the one that counts, so anything timed from before shouldn't be lifted later on
+	switch mc.Action {
+	case types.ModActionMute, types.ModActionUnmute:
+		db.ModCaseResolveUser(server.Id, modCase.UserId, []string{types.ModActionMute})
+	case types.ModActionBan, types.ModActionTempBan, types.ModActionUnban:
+		db.ModCaseResolveUser(server.Id, modCase.UserId, []string{types.ModActionTempBan})
+	}
+	return true
+}
+
+func (mc *ModerationCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (mc *ModerationCommand) GetCommandKeys() []string {
+	return []string{strings.ToUpper(mc.Action)}
+}
+
+func (mc *ModerationCommand) GetCommandHelp(commPrefix string) string {
+	switch mc.Action {
+	case types.ModActionWarn:
+		return fmt.Sprintf("`%[1]s warn <user> [reason]` - Mod. Warns a user, letting them know by DM.", commPrefix)
+	case types.ModActionMute:
+		return fmt.Sprintf("`%[1]s mute <user> [duration] [reason]` - Mod. Gives a user the server's mute role, optionally for a length "+
+			"of time like `1D12h` or `30m`.", commPrefix)
+	case types.ModActionUnmute:
+		return fmt.Sprintf("`%[1]s unmute <user> [reason]` - Mod. Takes away a user's mute role.", commPrefix)
+	case types.ModActionKick:
+		return fmt.Sprintf("`%[1]s kick <user> [reason]` - Mod. Kicks a user from the server.", commPrefix)
+	case types.ModActionBan:
+		return fmt.Sprintf("`%[1]s ban <user> [reason]` - Mod. Bans a user from the server.", commPrefix)
+	case types.ModActionTempBan:
+		return fmt.Sprintf("`%[1]s tempban <user> <duration> [reason]` - Mod. Bans a user for a length of time like `1W` or `3D`.", commPrefix)
+	case types.ModActionUnban:
+		return fmt.Sprintf("`%[1]s unban <user ID> [reason]` - Mod. Unbans a user.", commPrefix)
+	}
+	return ""
+}
+
+/*
+Lifts timed mutes and tempbans once they run out
+*/
+type ModerationHandler struct {
+	stopCh chan struct{}
+}
+
+func NewModerationHandler() *ModerationHandler {
+	return &ModerationHandler{stopCh: make(chan struct{})}
+}
+
+func (mh *ModerationHandler) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(modExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mh.expireCases(session)
+			case <-mh.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (mh *ModerationHandler) Shutdown(session *discordgo.Session) {
+	close(mh.stopCh)
+}
+
+func (mh *ModerationHandler) expireCases(session *discordgo.Session) {
+	cases, err := db.ModCaseQueryExpired()
+	if err != nil {
+		return
+	}
+	for _, c := range cases {
+		server, err := db.ServerQueryOrInsert(c.GuildUid)
+		if err != nil {
+			continue
+		}
+		if c.Action == types.ModActionTempBan {
+			err = session.GuildBanDelete(c.GuildUid, c.UserUid)
+		} else if server.MuteRole.Valid {
+			err = session.GuildMemberRoleRemove(c.GuildUid, c.UserUid, server.MuteRole.String)
+		}
+		if err != nil {
+			// most likely they left, were unbanned by hand, or the role is gone. Either way there's nothing left to lift
+			log.Println("Failed to lift case "+strconv.Itoa(c.Id)+" for User UID: "+c.UserUid, err)
+		}
+		if db.ModCaseResolve(c.Id) != nil {
+			continue
+		}
+		if server.ModLogChannel.Valid {
+			session.ChannelMessageSend(server.ModLogChannel.String, util.MakeStringBold("Case #"+strconv.Itoa(c.CaseNumber))+" ended: the "+c.Action+
+				" on "+util.UserIdToMention(c.UserUid)+" has run out.")
+		}
+	}
+}
+
+/*
+Posts a case to the server's mod log, remembering the message so it can be updated if the reason changes
+*/
+func postModCase(session *discordgo.Session, server types.Server, modCase types.ModCase, comPrefix string) {
+	if !server.ModLogChannel.Valid {
+		return
+	}
+	message, err := session.ChannelMessageSend(server.ModLogChannel.String, formatModCase(modCase, comPrefix))
+	if err != nil {
+		log.Println("Error posting case to mod log", err)
+		return
+	}
+	db.ModCaseSetLogMessage(modCase.Id, message.ChannelID, message.ID)
+}
+
+/*
+Lets the user know what happened to them. Returns false if they couldn't be messaged
+*/
+func notifyModAction(session *discordgo.Session, guild *discordgo.Guild, modCase types.ModCase) bool {
+	dmChannel, err := session.UserChannelCreate(modCase.UserUid)
+	if err != nil {
+		return false
+	}
+	var message string
+	switch modCase.Action {
+	case types.ModActionWarn:
+		message = "You've been warned in " + guild.Name
+	case types.ModActionMute:
+		message = "You've been muted in " + guild.Name
+	case types.ModActionUnmute:
+		message = "You've been unmuted in " + guild.Name
+	case types.ModActionKick:
+		message = "You've been kicked from " + guild.Name
+	case types.ModActionBan, types.ModActionTempBan:
+		message = "You've been banned from " + guild.Name
+	default:
+		return false
+	}
+	if modCase.DurationMinutes.Valid {
+		message += " for " + formatModDuration(modCase.DurationMinutes)
+	}
+	if modCase.Reason.Valid {
+		message += ". Reason: " + modCase.Reason.String
+	}
+	_, err = session.ChannelMessageSend(dmChannel.ID, message)
+	return err == nil
+}
+
+func formatModCase(c types.ModCase, comPrefix string) string {
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Case #"+strconv.Itoa(c.CaseNumber)) + " | " + util.ForceTitleCase(c.Action))
+	message.WriteString("\nUser: " + util.UserIdToMention(c.UserUid) + " (" + c.UserUid + ")")
+	message.WriteString("\nModerator: " + util.UserIdToMention(c.ModeratorUid))
+	if c.DurationMinutes.Valid {
+		message.WriteString("\nDuration: " + formatModDuration(c.DurationMinutes))
+		if c.Resolved {
+			message.WriteString(" (lifted)")
+		}
+	}
+	if c.Reason.Valid {
+		message.WriteString("\nReason: " + c.Reason.String)
+	} else {
+		message.WriteString("\nReason: " + util.MakeStringItalic("none given, use `"+comPrefix+" case "+strconv.Itoa(c.CaseNumber)+
+			" -reason <reason>` to add one"))
+	}
+	return message.String()
+}
+
+func describeModAction(c types.ModCase) string {
+	mention := util.UserIdToMention(c.UserUid)
+	var text string
+	switch c.Action {
+	case types.ModActionWarn:
+		text = "warned " + mention
+	case types.ModActionMute:
+		text = "muted " + mention
+	case types.ModActionUnmute:
+		text = "unmuted " + mention
+	case types.ModActionKick:
+		text = "kicked " + mention
+	case types.ModActionBan, types.ModActionTempBan:
+		text = "banned " + mention
+	case types.ModActionUnban:
+		text = "unbanned " + mention
+	}
+	if c.DurationMinutes.Valid {
+		text += " for " + formatModDuration(c.DurationMinutes)
+	}
+	return text
+}
+
+func formatModDuration(minutes sql.NullInt64) string {
+	return (time.Duration(minutes.Int64) * time.Minute).String()
+}
@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+type PointDecayScheduler struct {
+	schedulerType types.SchedulerType
+	session       *discordgo.Session
+}
+
+func NewPointDecayScheduler(schedulerType types.SchedulerType, session *discordgo.Session) *PointDecayScheduler {
+	return &PointDecayScheduler{schedulerType, session}
+}
+
+func (s *PointDecayScheduler) Execute(operationID int64) {
+	decay, err := db.PointDecayQuery(operationID)
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to retrieve operation informations for Operation ID: %v (operation is possibly being created). ", operationID), err)
+		return
+	}
+	inactiveSince := time.Now().AddDate(0, 0, -decay.InactiveDays)
+	decayed, err := db.UserServerRankDecay(decay.ServerID, inactiveSince, decay.Amount, decay.IsPercent)
+	if err != nil {
+		return
+	}
+	if decay.RemoveTierRoles && len(decayed) > 0 {
+		s.removeTierRoles(decay.ServerID, decayed)
+	}
+	_, err = db.ScheduledOperationUpdateTime(operationID)
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to update operation time in Operation ID: %v. ", operationID), err)
+	}
+}
+
+/*
+Takes away the role of any tier a user dropped below
+*/
+func (s *PointDecayScheduler) removeTierRoles(serverID int, decayed []types.UserServerRankDecay) {
+	server, err := db.ServerQueryById(serverID)
+	if err != nil {
+		return
+	}
+	tiers, err := db.RankTierQueryServer(serverID)
+	if err != nil {
+		return
+	}
+	for _, d := range decayed {
+		_, dropped := crossedTiers(tiers, d.OldRank, d.NewRank)
+		removeTierRoles(s.session, server.GuildUid, d.UserUid, dropped)
+	}
+}
+
+func (s *PointDecayScheduler) Keyword() string {
+	return "PointDecay"
+}
+
+func (s *PointDecayScheduler) Help() string {
+	return "`" + s.Keyword() + " -amount <points or percent%> -inactive <days> -interval <interval> [-removeroles]`: Takes away points from anyone " +
+		"that hasn't earned any in the given number of days, once every interval. With -removeroles members also lose the role of any tier they drop below."
+}
+
+func (s *PointDecayScheduler) AddScheduledOperation(comm *CommPackage) error {
+	params := ParseCommand(comm.params, []string{"-amount", "-inactive", "-interval", "-removeroles"})
+	if params["-amount"] == "" {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, you need to specify an amount of points or a percent to take away.")
+		return fmt.Errorf("-amount parameter empty")
+	}
+	if params["-interval"] == "" {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, you need to specify a time interval.")
+		return fmt.Errorf("-interval parameter empty")
+	}
+
+	decay := types.PointDecay{}
+	amountText := params["-amount"]
+	if strings.HasSuffix(amountText, "%") {
+		decay.IsPercent = true
+		amountText = strings.TrimSuffix(amountText, "%")
+	}
+	amount, err := strconv.Atoi(amountText)
+	if err != nil || amount <= 0 || (decay.IsPercent && amount > 100) {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, the amount needs to be a number of points greater than 0, or a percent from 1% to 100%.")
+		return fmt.Errorf("invalid -amount parameter")
+	}
+	decay.Amount = amount
+	inactiveDays, err := strconv.Atoi(params["-inactive"])
+	if err != nil || inactiveDays <= 0 {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, you need to specify how many days someone has to be inactive for with -inactive.")
+		return fmt.Errorf("invalid -inactive parameter")
+	}
+	decay.InactiveDays = inactiveDays
+	_, decay.RemoveTierRoles = params["-removeroles"]
+
+	intervalString, err := util.ParseIntervalToISO(params["-interval"])
+	if err != nil {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, the interval you specified is invalid. You need to specify the interval in the format `XWXDXh`, for example `5W6D4h` for 5 weeks, 6 days and 4 hours.")
+		return err
+	}
+
+	server, err := db.ServerQueryOrInsert(comm.guild.ID)
+	if err != nil {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, there was a problem retrieving the current server informations. Please try again.")
+		return err
+	}
+	operations, err := db.ScheduledOperationQueryServer(server.Id)
+	if err != nil {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, there was a problem retrieving the current operations list. Please try again.")
+		return err
+	}
+	for _, o := range operations {
+		if o.Type == db.SchedulerPointDecay {
+			// two decays would just stack on top of each other
+			comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, this server already has a point decay. Remove operation `"+
+				strconv.FormatInt(o.ID, 10)+"` first if you want to change it.")
+			return fmt.Errorf("Server already has a point decay")
+		}
+	}
+
+	err = db.PointDecayAdd(server.Id, decay, intervalString)
+	if err != nil {
+		comm.session.ChannelMessageSend(comm.channel.ID, "Sorry, there was a problem adding the point decay to the server.")
+		return err
+	}
+	comm.session.ChannelMessageSend(comm.channel.ID, "Point decay successfully added")
+	return nil
+}
+
+func (s *PointDecayScheduler) OperationDescription(operationID int64) string {
+	decay, err := db.PointDecayQuery(operationID)
+	if err != nil {
+		return "Failed to retrieve point decay"
+	}
+	amount := strconv.Itoa(decay.Amount) + " points"
+	if decay.IsPercent {
+		amount = strconv.Itoa(decay.Amount) + "% of points"
+	}
+	description := "Taking away " + amount + " from members inactive for " + strconv.Itoa(decay.InactiveDays) + " days"
+	if decay.RemoveTierRoles {
+		description += ", removing tier roles"
+	}
+	return description
+}
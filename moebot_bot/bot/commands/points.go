@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	pointHistoryPageSize       = 10
+	pointHistoryDateFormat     = "2006-01-02"
+	pointHistoryTimeFormat     = "2006-01-02 15:04"
+	pointReasonMaxLength       = 500
+	pointReasonMaxLengthString = "500"
+)
+
+/*
+Lets mods fix up someone's points by hand, and look through how someone got their points
+*/
+type PointsCommand struct {
+	ComPrefix string
+}
+
+func (pc *PointsCommand) Execute(pack *CommPackage) {
+	if len(pack.params) < 2 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide an action and a user. See `"+pc.ComPrefix+" help` for more info.")
+		return
+	}
+	userUid, ok := util.ExtractUserIdFromString(pack.params[1])
+	if !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid user mention or ID.")
+		return
+	}
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	user, err := db.UserQueryOrInsert(userUid)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching that user. This is an issue with moebot and not Discord.")
+		return
+	}
+
+	action := strings.ToUpper(pack.params[0])
+	if action == "HISTORY" {
+		pc.showHistory(pack, server, user)
+		return
+	}
+	if action != "ADD" && action != "REMOVE" && action != "SET" {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, `"+pack.params[0]+"` isn't a valid action. Please use add, remove, set, or history.")
+		return
+	}
+	if len(pack.params) < 3 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of points.")
+		return
+	}
+	points, err := strconv.Atoi(pack.params[2])
+	if err != nil || points < 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of points that's 0 or more.")
+		return
+	}
+	if action == "REMOVE" {
+		points = -points
+	}
+	var reason sql.NullString
+	if len(pack.params) > 3 {
+		reasonText := strings.Join(pack.params[3:], " ")
+		if len(reasonText) > pointReasonMaxLength {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, the reason has a max length of "+pointReasonMaxLengthString)
+			return
+		}
+		reason.Scan(reasonText)
+	}
+
+	oldRank, newRank, err := db.UserServerRankAdjust(user.Id, server.Id, points, action == "SET", pack.user.Id, reason)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue updating their points. This is an issue with moebot and not Discord.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Changed "+util.UserIdToMention(userUid)+"'s points from "+strconv.Itoa(oldRank)+" to "+
+		strconv.Itoa(newRank)+".")
+
+	// tiers go by points, so a change by hand moves people between them just like earning or decay does
+	tiers, err := db.RankTierQueryServer(server.Id)
+	if err != nil {
+		return
+	}
+	reached, dropped := crossedTiers(tiers, oldRank, newRank)
+	var promotions []tierPromotion
+	for _, t := range reached {
+		promotions = append(promotions, tierPromotion{userUid: userUid, server: server, tier: t})
+	}
+	announceTierPromotions(pack.session, pc.ComPrefix, "", promotions)
+	removeTierRoles(pack.session, server.GuildUid, userUid, dropped)
+}
+
+func (pc *PointsCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (pc *PointsCommand) GetCommandKeys() []string {
+	return []string{"POINTS"}
+}
+
+func (pc *PointsCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s points add|remove|set <user> <points> [reason]` - Mod. Changes a user's points, keeping track of who changed "+
+		"them and why. `%[1]s points history <user> [page]` shows where a user's points came from.", commPrefix)
+}
+
+func (pc *PointsCommand) showHistory(pack *CommPackage, server types.Server, user types.UserProfile) {
+	page := 1
+	if len(pack.params) > 2 {
+		var err error
+		page, err = strconv.Atoi(pack.params[2])
+		if err != nil || page < 1 {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a page number greater than 0.")
+			return
+		}
+	}
+	events, total, err := db.PointEventQueryHistory(user.Id, server.Id, pointHistoryPageSize, (page-1)*pointHistoryPageSize)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching their history. This is an issue with moebot and not Discord.")
+		return
+	}
+	if total == 0 && page == 1 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That user doesn't have any point history in this server.")
+		return
+	}
+	pageCount := (total + pointHistoryPageSize - 1) / pointHistoryPageSize
+	if len(events) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There are only "+strconv.Itoa(pageCount)+" pages of history for that user.")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Point history for " + util.UserIdToMention(user.UserUid)))
+	message.WriteString(" (page " + strconv.Itoa(page) + "/" + strconv.Itoa(pageCount) + ")")
+	for _, e := range events {
+		message.WriteString("\n")
+		message.WriteString(formatPointEvent(e))
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func formatPointEvent(e types.PointEvent) string {
+	if e.Source == types.PointSourceActivity {
+		return util.MakeStringCode(e.CreatedAt.Format(pointHistoryDateFormat)) + " " + formatPointChange(e.Points) + " from activity"
+	} else if e.Source == types.PointSourceDecay {
+		return util.MakeStringCode(e.CreatedAt.Format(pointHistoryTimeFormat)) + " " + formatPointChange(e.Points) + " from inactivity"
+	}
+	line := util.MakeStringCode(e.CreatedAt.Format(pointHistoryTimeFormat)) + " " + formatPointChange(e.Points) + " by "
+	if e.ModeratorUid.Valid {
+		line += util.UserIdToMention(e.ModeratorUid.String)
+	} else {
+		line += util.MakeStringItalic("unknown")
+	}
+	if e.Reason.Valid {
+		line += ": " + e.Reason.String
+	}
+	return line
+}
+
+func formatPointChange(points int) string {
+	if points >= 0 {
+		return "+" + strconv.Itoa(points)
+	}
+	return strconv.Itoa(points)
+}
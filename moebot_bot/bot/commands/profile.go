@@ -1,24 +1,52 @@
 package commands
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bwmarrin/discordgo"
+
 	"github.com/camd67/moebot/moebot_bot/bot/permissions"
 	"github.com/camd67/moebot/moebot_bot/util"
 	"github.com/camd67/moebot/moebot_bot/util/db"
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
 
+const (
+	// Cards are small, but don't hold onto them forever on a big server
+	profileCardCacheMax = 500
+	profileJoinFormat   = "Jan 2, 2006"
+)
+
 type ProfileCommand struct {
 	MasterId string
+	// guild ID + user ID -> last card drawn for them, only redrawn when something on it changes
+	cardCache struct {
+		sync.Mutex
+		m map[string]profileCardCacheEntry
+	}
+}
+
+type profileCardCacheEntry struct {
+	fingerprint string
+	card        []byte
+}
+
+func NewProfileCommand(masterId string) *ProfileCommand {
+	result := &ProfileCommand{MasterId: masterId}
+	result.cardCache.m = make(map[string]profileCardCacheEntry)
+	return result
 }
 
 func (pc *ProfileCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-text"})
+	_, textOnly := args["-text"]
 	// special stuff for master rank
 	if pc.MasterId == pack.message.Author.ID && len(pack.params) == 0 {
 		pack.session.ChannelMessageSend(pack.message.ChannelID, pack.message.Author.Mention()+"'s profile:\nMy favorite user! ❤️")
@@ -47,6 +75,18 @@ func (pc *ProfileCommand) Execute(pack *CommPackage) {
 			usr = nil
 		}
 	}
+	tiers, _ := db.RankTierQueryServer(server.Id)
+	permissionLevel := pc.getPermissionLevel(pack)
+	joinDate, err := pack.member.JoinedAt.Parse()
+	if err != nil {
+		log.Println("Problem converting server join date to time. User ID {"+pack.message.Author.ID+"}, Joined at time: {"+
+			string(pack.member.JoinedAt)+"} error: ", err)
+	}
+
+	if !textOnly && pc.sendProfileCard(pack, server, usr, tiers, permissionLevel, joinDate) {
+		return
+	}
+
 	var message strings.Builder
 	message.WriteString(pack.message.Author.Mention())
 	message.WriteString("'s profile:")
@@ -56,20 +96,90 @@ func (pc *ProfileCommand) Execute(pack *CommPackage) {
 	} else {
 		message.WriteString("Unranked")
 	}
+	if len(tiers) > 0 {
+		rank := 0
+		if usr != nil {
+			rank = usr.Rank
+		}
+		message.WriteString("\nTier: ")
+		message.WriteString(convertTierToString(rank, tiers))
+	}
 	message.WriteString("\nPermission Level: ")
-	message.WriteString(util.MakeStringCode(pc.getPermissionLevel(pack)))
+	message.WriteString(util.MakeStringCode(permissionLevel))
 	message.WriteString("\nServer join date: ")
-	t, err := pack.member.JoinedAt.Parse()
-	if err != nil {
+	if joinDate.IsZero() {
 		message.WriteString(util.MakeStringCode("Unknown"))
-		log.Println("Problem converting server join date to time. User ID {"+pack.message.Author.ID+"}, Joined at time: {"+
-			string(pack.member.JoinedAt)+"} error: ", err)
 	} else {
-		message.WriteString(util.MakeStringCode(t.Format(time.ANSIC)))
+		message.WriteString(util.MakeStringCode(joinDate.Format(time.ANSIC)))
 	}
 	pack.session.ChannelMessageSend(pack.message.ChannelID, message.String())
 }
 
+/*
+Draws (or reuses) a profile card and sends it. Returns false if the card couldn't be sent so the text profile can be used instead
+*/
+func (pc *ProfileCommand) sendProfileCard(pack *CommPackage, server types.Server, usr *types.UserServerRank, tiers []types.RankTier,
+	permissionLevel string, joinDate time.Time) bool {
+
+	rank := 0
+	if usr != nil {
+		rank = usr.Rank
+	}
+	card := util.ProfileCard{
+		DisplayName:     pack.message.Author.Username,
+		PermissionLevel: permissionLevel,
+		JoinDate:        "Unknown",
+		Accent:          util.DefaultCardAccent,
+	}
+	if pack.member.Nick != "" {
+		card.DisplayName = pack.member.Nick
+	}
+	if !joinDate.IsZero() {
+		card.JoinDate = joinDate.Format(profileJoinFormat)
+	}
+	if accent, ok := util.ParseHexColor(server.ProfileAccentColor.String); server.ProfileAccentColor.Valid && ok {
+		card.Accent = accent
+	}
+	card.Tier, card.Progress, card.ProgressText = getRankProgress(rank, tiers, server.VeteranRank)
+
+	key := pack.guild.ID + ":" + pack.message.Author.ID
+	fingerprint := fmt.Sprint(pack.message.Author.Avatar, card.DisplayName, card.Tier, card.Progress, card.ProgressText, card.PermissionLevel,
+		card.JoinDate, card.Accent)
+	pc.cardCache.Lock()
+	cached, ok := pc.cardCache.m[key]
+	pc.cardCache.Unlock()
+	if !ok || cached.fingerprint != fingerprint {
+		avatar, err := pack.session.UserAvatarDecode(pack.message.Author)
+		if err != nil {
+			// still worth sending a card without the avatar
+			log.Println("Error fetching avatar for profile card", err)
+		} else {
+			card.Avatar = avatar
+		}
+		b, err := util.MakeProfileCard(card)
+		if err != nil {
+			log.Println("Error drawing profile card", err)
+			return false
+		}
+		cached = profileCardCacheEntry{fingerprint: fingerprint, card: b}
+		pc.cardCache.Lock()
+		if len(pc.cardCache.m) >= profileCardCacheMax {
+			pc.cardCache.m = make(map[string]profileCardCacheEntry)
+		}
+		pc.cardCache.m[key] = cached
+		pc.cardCache.Unlock()
+	}
+	_, err := pack.session.ChannelMessageSendComplex(pack.channel.ID, &discordgo.MessageSend{
+		Content: pack.message.Author.Mention() + "'s profile:",
+		File: &discordgo.File{
+			Name:        "profile.png",
+			ContentType: "image/png",
+			Reader:      bytes.NewReader(cached.card),
+		},
+	})
+	return err == nil
+}
+
 func (pc *ProfileCommand) getPermissionLevel(pack *CommPackage) string {
 	// special checks for certain roles that aren't in the database
 	if pack.message.Author.ID == pc.MasterId {
@@ -124,6 +234,48 @@ func convertRankToString(rank int, serverMax sql.NullInt64) (rankString string)
 	return convertToEmphasizedRankString(rankPrefixes, rankPrefixIndex, rankSeparator)
 }
 
+/*
+Gets the highest tier reached along with how far away the next tier is. Tiers must be sorted by threshold
+*/
+func convertTierToString(rank int, tiers []types.RankTier) string {
+	current := "None yet"
+	for _, t := range tiers {
+		if rank < t.Threshold {
+			return current + " (" + strconv.Itoa(t.Threshold-rank) + " points to " + t.Name + ")"
+		}
+		current = util.MakeStringBold(t.Name)
+	}
+	return current + " (highest tier)"
+}
+
+/*
+Gets the name of the rank a user is at, how far they are to the next one from 0 to 1, and a description of that progress.
+Uses the server's tiers if it has any, otherwise the veteran rank
+*/
+func getRankProgress(rank int, tiers []types.RankTier, veteranRank sql.NullInt64) (name string, progress float64, progressText string) {
+	if len(tiers) > 0 {
+		name = "No tier yet"
+		previous := 0
+		for _, t := range tiers {
+			if rank < t.Threshold {
+				progress = float64(rank-previous) / float64(t.Threshold-previous)
+				return name, progress, strconv.Itoa(rank) + " points, " + strconv.Itoa(t.Threshold-rank) + " to " + t.Name
+			}
+			name = t.Name
+			previous = t.Threshold
+		}
+		return name, 1, strconv.Itoa(rank) + " points, highest tier reached"
+	}
+	if veteranRank.Valid && veteranRank.Int64 > 0 {
+		if int64(rank) >= veteranRank.Int64 {
+			return "Veteran", 1, strconv.Itoa(rank) + " points"
+		}
+		progress = float64(rank) / float64(veteranRank.Int64)
+		return "Member", progress, strconv.Itoa(rank) + " points, " + strconv.Itoa(int(veteranRank.Int64)-rank) + " to veteran"
+	}
+	return "Member", 0, strconv.Itoa(rank) + " points"
+}
+
 /*
 Converts an array of strings to an emphasized string, currently used only for ranks. Looks like:
 ~element1~,**element2**, element3, element4
@@ -152,5 +304,5 @@ func (pc *ProfileCommand) GetCommandKeys() []string {
 }
 
 func (pc *ProfileCommand) GetCommandHelp(commPrefix string) string {
-	return fmt.Sprintf("`%[1]s profile` - Displays your server profile", commPrefix)
+	return fmt.Sprintf("`%[1]s profile [-text]` - Displays your server profile card, or just the text with -text", commPrefix)
 }
@@ -3,6 +3,8 @@ package commands
 import (
 	"database/sql"
 	"testing"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
 
 func TestProfileCommand_ConvertRankToString(t *testing.T) {
@@ -47,3 +49,52 @@ func TestProfileCommand_ConvertRankToString(t *testing.T) {
 		}
 	}
 }
+
+func TestProfileCommand_ConvertTierToString(t *testing.T) {
+	tiers := []types.RankTier{{Name: "Bronze", Threshold: 10}, {Name: "Silver", Threshold: 50}, {Name: "Gold", Threshold: 200}}
+	checks := []struct {
+		rank int
+		out  string
+	}{
+		{0, "None yet (10 points to Bronze)"},
+		{9, "None yet (1 points to Bronze)"},
+		{10, "**Bronze** (40 points to Silver)"},
+		{120, "**Silver** (80 points to Gold)"},
+		{200, "**Gold** (highest tier)"},
+		{5000, "**Gold** (highest tier)"},
+	}
+	for _, check := range checks {
+		message := convertTierToString(check.rank, tiers)
+		if message != check.out {
+			t.Errorf("Tier to string was incorrect, got: %s, want: %s.", message, check.out)
+		}
+	}
+}
+
+func TestProfileCommand_GetRankProgress(t *testing.T) {
+	tiers := []types.RankTier{{Name: "Bronze", Threshold: 10}, {Name: "Silver", Threshold: 50}}
+	checks := []struct {
+		rank        int
+		tiers       []types.RankTier
+		veteranRank sql.NullInt64
+		name        string
+		progress    float64
+		text        string
+	}{
+		{0, tiers, sql.NullInt64{}, "No tier yet", 0, "0 points, 10 to Bronze"},
+		{5, tiers, sql.NullInt64{}, "No tier yet", 0.5, "5 points, 5 to Bronze"},
+		{30, tiers, sql.NullInt64{}, "Bronze", 0.5, "30 points, 20 to Silver"},
+		{60, tiers, sql.NullInt64{}, "Silver", 1, "60 points, highest tier reached"},
+		// tiers win over the veteran rank
+		{60, tiers, sql.NullInt64{Int64: 100, Valid: true}, "Silver", 1, "60 points, highest tier reached"},
+		{25, nil, sql.NullInt64{Int64: 100, Valid: true}, "Member", 0.25, "25 points, 75 to veteran"},
+		{150, nil, sql.NullInt64{Int64: 100, Valid: true}, "Veteran", 1, "150 points"},
+		{42, nil, sql.NullInt64{}, "Member", 0, "42 points"},
+	}
+	for _, check := range checks {
+		name, progress, text := getRankProgress(check.rank, check.tiers, check.veteranRank)
+		if name != check.name || progress != check.progress || text != check.text {
+			t.Errorf("Rank progress was incorrect, got: %s, %v, %s, want: %s, %v, %s.", name, progress, text, check.name, check.progress, check.text)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	purgeMaxCount = 500
+	// how far back to look for matching messages, so a filter that barely matches anything doesn't page through the whole channel
+	purgeMaxScan  = 2000
+	purgePageSize = 100
+	// discord won't bulk delete anything older than 2 weeks, an hour is taken off so nothing ages out while we're deleting
+	purgeBulkMaxAge = 14*24*time.Hour - time.Hour
+	// pause between single deletes so we don't hit the rate limit
+	purgeSingleDelay = 300 * time.Millisecond
+)
+
+/*
+Deletes a number of recent messages from a channel, optionally only ones matching some filters
+*/
+type PurgeCommand struct {
+	ComPrefix string
+}
+
+type purgeFilter struct {
+	authorUid   string
+	contains    string
+	botsOnly    bool
+	attachments bool
+	// message IDs, anything not strictly between them is left alone. Zero means no limit
+	before uint64
+	after  uint64
+}
+
+func (pc *PurgeCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-user", "-contains", "-bots", "-attachments", "-before", "-after", "-log"})
+	count, err := strconv.Atoi(args[""])
+	if err != nil || count <= 0 || count > purgeMaxCount {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of messages from 1 to "+strconv.Itoa(purgeMaxCount)+
+			". See `"+pc.ComPrefix+" help` for more info.")
+		return
+	}
+	filter := purgeFilter{contains: strings.ToLower(args["-contains"])}
+	if userText, ok := args["-user"]; ok {
+		if filter.authorUid, ok = util.ExtractUserIdFromString(userText); !ok {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid user mention or ID for -user.")
+			return
+		}
+	}
+	_, filter.botsOnly = args["-bots"]
+	_, filter.attachments = args["-attachments"]
+	for flag, id := range map[string]*uint64{"-before": &filter.before, "-after": &filter.after} {
+		if idText, ok := args[flag]; ok {
+			if *id, err = strconv.ParseUint(idText, 10, 64); err != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a message ID for "+flag+".")
+				return
+			}
+		}
+	}
+	_, shouldLog := args["-log"]
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	if shouldLog && !server.ModLogChannel.Valid {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please set a ModLogChannel with `"+pc.ComPrefix+" server` to log purges.")
+		return
+	}
+
+	toDelete, err := pc.findMessages(pack, filter, count)
+	if err != nil {
+		log.Println("Error fetching messages to purge", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching messages. Please make sure moebot can read the "+
+			"message history in this channel.")
+		return
+	}
+	if len(toDelete) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "No messages matched, so there's nothing to delete!")
+		return
+	}
+
+	recent, old := splitPurgeByAge(toDelete, time.Now())
+	deleted := make([]*discordgo.Message, 0, len(toDelete))
+	stoppedEarly := false
+	for start := 0; start < len(recent); start += purgePageSize {
+		end := start + purgePageSize
+		if end > len(recent) {
+			end = len(recent)
+		}
+		batch := recent[start:end]
+		if len(batch) == 1 {
+			// bulk delete needs at least 2 messages
+			old = append(old, batch[0])
+			continue
+		}
+		ids := make([]string, len(batch))
+		for i, m := range batch {
+			ids[i] = m.ID
+		}
+		if err = pack.session.ChannelMessagesBulkDelete(pack.channel.ID, ids); err != nil {
+			log.Println("Error bulk deleting messages", err)
+			stoppedEarly = true
+			break
+		}
+		deleted = append(deleted, batch...)
+	}
+	for _, m := range old {
+		if stoppedEarly {
+			// whatever stopped the bulk delete would most likely stop these too
+			break
+		}
+		if err = pack.session.ChannelMessageDelete(pack.channel.ID, m.ID); err != nil {
+			log.Println("Error deleting message for purge", err)
+			continue
+		}
+		deleted = append(deleted, m)
+		time.Sleep(purgeSingleDelay)
+	}
+
+	if len(deleted) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue deleting messages. Please make sure moebot has "+
+			"permission to manage messages in this channel.")
+		return
+	}
+	summary := formatPurgeSummary(deleted)
+	if stoppedEarly {
+		summary += ". Stopped early after an issue deleting messages, please make sure moebot has permission to manage messages in this channel"
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, summary)
+	if shouldLog {
+		pc.logPurge(pack, server, deleted, summary)
+	}
+}
+
+func (pc *PurgeCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (pc *PurgeCommand) GetCommandKeys() []string {
+	return []string{"PURGE"}
+}
+
+func (pc *PurgeCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s purge <count> [-user <user>] [-contains <text>] [-bots] [-attachments] [-before <message ID>] "+
+		"[-after <message ID>] [-log]` - Mod. Deletes up to %[2]d of the latest messages in this channel that match every filter given. "+
+		"Pinned messages are never deleted. -log posts what was deleted to the mod log.", commPrefix, purgeMaxCount)
+}
+
+/*
+Pages back through the channel until enough matching messages are found, or we've looked far enough
+*/
+func (pc *PurgeCommand) findMessages(pack *CommPackage, filter purgeFilter, count int) ([]*discordgo.Message, error) {
+	// the purge command itself is left alone, it's replied to with the summary
+	before := pack.message.ID
+	if filter.before != 0 {
+		before = strconv.FormatUint(filter.before, 10)
+	}
+	var found []*discordgo.Message
+	for scanned := 0; scanned < purgeMaxScan && len(found) < count; {
+		page, err := pack.session.ChannelMessages(pack.channel.ID, purgePageSize, before, "", "")
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			if !filter.inRange(m.ID) {
+				// newest first, so everything after this is before the -after message too
+				return found, nil
+			}
+			if filter.matches(m) {
+				found = append(found, m)
+				if len(found) == count {
+					return found, nil
+				}
+			}
+		}
+		if len(page) < purgePageSize {
+			break
+		}
+		scanned += len(page)
+		before = page[len(page)-1].ID
+	}
+	return found, nil
+}
+
+func (f purgeFilter) inRange(messageUid string) bool {
+	id, err := strconv.ParseUint(messageUid, 10, 64)
+	if err != nil {
+		return false
+	}
+	return (f.before == 0 || id < f.before) && (f.after == 0 || id > f.after)
+}
+
+func (f purgeFilter) matches(m *discordgo.Message) bool {
+	if m.Pinned || m.Author == nil {
+		return false
+	}
+	if f.authorUid != "" && m.Author.ID != f.authorUid {
+		return false
+	}
+	if f.botsOnly && !m.Author.Bot {
+		return false
+	}
+	if f.attachments && len(m.Attachments) == 0 {
+		return false
+	}
+	return f.contains == "" || strings.Contains(strings.ToLower(m.Content), f.contains)
+}
+
+/*
+Splits messages into those young enough to be bulk deleted and those that have to go one at a time
+*/
+func splitPurgeByAge(messages []*discordgo.Message, now time.Time) (recent []*discordgo.Message, old []*discordgo.Message) {
+	for _, m := range messages {
+		created, err := discordgo.SnowflakeTimestamp(m.ID)
+		if err == nil && now.Sub(created) < purgeBulkMaxAge {
+			recent = append(recent, m)
+		} else {
+			old = append(old, m)
+		}
+	}
+	return
+}
+
+/*
+Counts up who had messages deleted. Authors are shown by name so the summary doesn't ping everyone that was purged
+*/
+func formatPurgeSummary(deleted []*discordgo.Message) string {
+	counts := make(map[string]int)
+	names := make(map[string]string)
+	for _, m := range deleted {
+		counts[m.Author.ID]++
+		names[m.Author.ID] = m.Author.String()
+	}
+	authors := make([]string, 0, len(counts))
+	for a := range counts {
+		authors = append(authors, a)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		return counts[authors[i]] > counts[authors[j]]
+	})
+	var parts []string
+	for _, a := range authors {
+		parts = append(parts, strconv.Itoa(counts[a])+" from "+names[a])
+	}
+	return "Deleted " + strconv.Itoa(len(deleted)) + " messages: " + strings.Join(parts, ", ")
+}
+
+/*
+Posts the summary to the mod log along with a text file of everything that was deleted, oldest first
+*/
+func (pc *PurgeCommand) logPurge(pack *CommPackage, server types.Server, deleted []*discordgo.Message, summary string) {
+	// single deletes happen after the bulk ones, so the order they were deleted in isn't the order they were sent in
+	sorted := make([]*discordgo.Message, len(deleted))
+	copy(sorted, deleted)
+	sort.Slice(sorted, func(i, j int) bool {
+		return util.SnowflakeLess(sorted[i].ID, sorted[j].ID)
+	})
+	var transcript bytes.Buffer
+	for _, m := range sorted {
+		created, _ := discordgo.SnowflakeTimestamp(m.ID)
+		transcript.WriteString("[" + created.UTC().Format("2006-01-02 15:04:05") + "] " + m.Author.String() + " (" + m.Author.ID + "): " +
+			m.Content + "\n")
+		for _, a := range m.Attachments {
+			transcript.WriteString("    attachment: " + a.URL + "\n")
+		}
+	}
+	_, err := pack.session.ChannelMessageSendComplex(server.ModLogChannel.String, &discordgo.MessageSend{
+		Content: util.MakeStringBold("Purge") + " in <#" + pack.channel.ID + "> by " + pack.message.Author.Mention() + ". " + summary,
+		File: &discordgo.File{
+			Name:        "purge-" + pack.channel.ID + ".txt",
+			ContentType: "text/plain",
+			Reader:      &transcript,
+		},
+	})
+	if err != nil {
+		log.Println("Error posting purge log", err)
+	}
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPurgeCommand_FilterMatches(t *testing.T) {
+	user := &discordgo.User{ID: "1"}
+	bot := &discordgo.User{ID: "2", Bot: true}
+	withFile := []*discordgo.MessageAttachment{{URL: "https://example.com/a.png"}}
+	checks := []struct {
+		filter  purgeFilter
+		message *discordgo.Message
+		match   bool
+	}{
+		{purgeFilter{}, &discordgo.Message{Author: user, Content: "hi"}, true},
+		{purgeFilter{}, &discordgo.Message{Author: user, Content: "hi", Pinned: true}, false},
+		{purgeFilter{authorUid: "1"}, &discordgo.Message{Author: user}, true},
+		{purgeFilter{authorUid: "1"}, &discordgo.Message{Author: bot}, false},
+		{purgeFilter{botsOnly: true}, &discordgo.Message{Author: bot}, true},
+		{purgeFilter{botsOnly: true}, &discordgo.Message{Author: user}, false},
+		{purgeFilter{attachments: true}, &discordgo.Message{Author: user, Attachments: withFile}, true},
+		{purgeFilter{attachments: true}, &discordgo.Message{Author: user}, false},
+		{purgeFilter{contains: "spam"}, &discordgo.Message{Author: user, Content: "buy SPAM now"}, true},
+		{purgeFilter{contains: "spam"}, &discordgo.Message{Author: user, Content: "hello"}, false},
+	}
+	for i, c := range checks {
+		if c.filter.matches(c.message) != c.match {
+			t.Errorf("Check %d: expected match %v", i, c.match)
+		}
+	}
+}
+
+func TestPurgeCommand_FilterInRange(t *testing.T) {
+	filter := purgeFilter{before: 100, after: 50}
+	checks := map[string]bool{"75": true, "100": false, "50": false, "101": false, "49": false, "abc": false}
+	for id, expected := range checks {
+		if filter.inRange(id) != expected {
+			t.Errorf("inRange(%s), expected %v", id, expected)
+		}
+	}
+}
+
+func TestPurgeCommand_SplitByAge(t *testing.T) {
+	now := time.Now()
+	idAt := func(at time.Time) string {
+		return strconv.FormatInt((at.UnixNano()/int64(time.Millisecond)-1420070400000)<<22, 10)
+	}
+	messages := []*discordgo.Message{
+		{ID: idAt(now.Add(-time.Hour))},
+		{ID: idAt(now.AddDate(0, 0, -13))},
+		{ID: idAt(now.AddDate(0, 0, -15))},
+	}
+	recent, old := splitPurgeByAge(messages, now)
+	if len(recent) != 2 || len(old) != 1 || old[0] != messages[2] {
+		t.Errorf("Expected 2 recent and 1 old message, got %d and %d", len(recent), len(old))
+	}
+}
+
+func TestPurgeCommand_FormatPurgeSummary(t *testing.T) {
+	user := &discordgo.User{ID: "1", Username: "moe", Discriminator: "0001"}
+	other := &discordgo.User{ID: "2", Username: "bot", Discriminator: "0002"}
+	summary := formatPurgeSummary([]*discordgo.Message{{Author: user}, {Author: other}, {Author: user}})
+	if summary != "Deleted 3 messages: 2 from moe#0001, 1 from bot#0002" {
+		t.Errorf("Unexpected summary, got %q", summary)
+	}
+}
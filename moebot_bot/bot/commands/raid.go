@@ -0,0 +1,360 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	raidCheckInterval     = 30 * time.Second
+	raidDefaultSeconds    = 10
+	raidDefaultAccountAge = 7
+	raidDefaultMinutes    = 15
+	// most joiners listed when raid mode starts or ends, the rest are just counted
+	raidListLimit = 20
+)
+
+/*
+Watches how fast people join each server and puts the server into raid mode when too many join at once. While in raid mode new members are
+held in the starter role, welcome messages aren't sent, and mods get told about it in the bot channel.
+Raid mode only lives in memory, so it's over if moebot restarts
+*/
+type RaidCommand struct {
+	ComPrefix string
+	guilds    struct {
+		sync.Mutex
+		m map[string]*raidState
+	}
+	stopCh chan struct{}
+}
+
+type raidState struct {
+	// joins within the server's join window, oldest first
+	joins []raidJoin
+	// zero when the server isn't in raid mode
+	until time.Time
+	// names of everyone that joined since raid mode started
+	held []string
+}
+
+type raidJoin struct {
+	userUid string
+	// shown to mods instead of a mention, so a raid alert doesn't ping every raider
+	name       string
+	joinedAt   time.Time
+	suspicious bool
+}
+
+func NewRaidCommand(comPrefix string) *RaidCommand {
+	rc := &RaidCommand{ComPrefix: comPrefix, stopCh: make(chan struct{})}
+	rc.guilds.m = make(map[string]*raidState)
+	return rc
+}
+
+/*
+Counts a new member towards their server's join rate, starting raid mode if there's been too many. Returns true if the server is in raid mode,
+in which case the member shouldn't be welcomed
+*/
+func (rc *RaidCommand) RecordJoin(session *discordgo.Session, server types.Server, member *discordgo.Member) bool {
+	now := time.Now()
+	accountAge := raidDefaultAccountAge
+	if server.RaidAccountAgeDays.Valid {
+		accountAge = int(server.RaidAccountAgeDays.Int64)
+	}
+	join := raidJoin{userUid: member.User.ID, name: raidMemberName(member.User), joinedAt: now,
+		suspicious: isSuspiciousAccount(member.User, now, accountAge)}
+
+	rc.guilds.Lock()
+	state := rc.getState(member.GuildID)
+	if !state.until.IsZero() {
+		// keep holding off until the joins stop
+		state.until = now.Add(raidModeLength(server))
+		state.held = append(state.held, join.name)
+		rc.guilds.Unlock()
+		return true
+	}
+	if !server.RaidJoinCount.Valid || server.RaidJoinCount.Int64 <= 0 {
+		rc.guilds.Unlock()
+		return false
+	}
+	window := time.Duration(raidDefaultSeconds) * time.Second
+	if server.RaidJoinSeconds.Valid && server.RaidJoinSeconds.Int64 > 0 {
+		window = time.Duration(server.RaidJoinSeconds.Int64) * time.Second
+	}
+	state.joins = append(pruneRaidJoins(state.joins, now.Add(-window)), join)
+	if raidJoinWeight(state.joins) < int(server.RaidJoinCount.Int64) {
+		rc.guilds.Unlock()
+		return false
+	}
+	state.until = now.Add(raidModeLength(server))
+	for _, j := range state.joins {
+		state.held = append(state.held, j.name)
+	}
+	joins := state.joins
+	state.joins = nil
+	rc.guilds.Unlock()
+
+	log.Println("Starting raid mode for guild", member.GuildID)
+	rc.alertRaidStart(session, server, joins, window)
+	return true
+}
+
+/*
+Whether new members in the given server should be kept in the starter role for now
+*/
+func (rc *RaidCommand) IsRaidMode(guildUid string) bool {
+	rc.guilds.Lock()
+	defer rc.guilds.Unlock()
+	state, ok := rc.guilds.m[guildUid]
+	return ok && !state.until.IsZero()
+}
+
+func (rc *RaidCommand) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(raidCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rc.endExpiredRaids(session)
+			case <-rc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (rc *RaidCommand) Shutdown(session *discordgo.Session) {
+	close(rc.stopCh)
+}
+
+func (rc *RaidCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	option := ""
+	if len(pack.params) > 0 {
+		option = strings.ToUpper(pack.params[0])
+	}
+	switch option {
+	case "ON":
+		length := raidModeLength(server)
+		if len(pack.params) > 1 {
+			minutes, err := strconv.Atoi(pack.params[1])
+			if err != nil || minutes <= 0 {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a positive number of minutes.")
+				return
+			}
+			length = time.Duration(minutes) * time.Minute
+		}
+		rc.guilds.Lock()
+		state := rc.getState(pack.guild.ID)
+		state.until = time.Now().Add(length)
+		state.joins = nil
+		rc.guilds.Unlock()
+		message := "Raid mode is on. Welcome messages are off and new members will stay in the starter role until it ends."
+		if !server.StarterRole.Valid {
+			message += " This server doesn't have a starter role though, so new members can still talk."
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, message+" It'll end after "+strconv.Itoa(int(length.Minutes()))+
+			" minutes without anyone joining, or use `"+rc.ComPrefix+" raid off`.")
+	case "OFF":
+		held, wasOn := rc.endRaid(pack.guild.ID)
+		if !wasOn {
+			pack.session.ChannelMessageSend(pack.channel.ID, "This server isn't in raid mode.")
+			return
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, "Raid mode is off. "+formatRaidHeld(held))
+	case "":
+		rc.guilds.Lock()
+		state, ok := rc.guilds.m[pack.guild.ID]
+		var until time.Time
+		var held []string
+		if ok {
+			until = state.until
+			held = append(held, state.held...)
+		}
+		rc.guilds.Unlock()
+		if until.IsZero() {
+			if !server.RaidJoinCount.Valid || server.RaidJoinCount.Int64 <= 0 {
+				pack.session.ChannelMessageSend(pack.channel.ID, "This server isn't in raid mode and raid detection is off. Set RaidJoinCount with `"+
+					rc.ComPrefix+" server` to turn it on.")
+			} else {
+				pack.session.ChannelMessageSend(pack.channel.ID, "This server isn't in raid mode.")
+			}
+			return
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, "This server is in raid mode for at least another "+
+			strconv.Itoa(int(time.Until(until).Minutes())+1)+" minutes. "+formatRaidHeld(held))
+	default:
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide on or off, or nothing to see if raid mode is on. See `"+rc.ComPrefix+
+			" help` for more info.")
+	}
+}
+
+func (rc *RaidCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (rc *RaidCommand) GetCommandKeys() []string {
+	return []string{"RAID"}
+}
+
+func (rc *RaidCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s raid [on [minutes]|off]` - Mod. Shows if the server is in raid mode, or turns it on or off. While on, new members "+
+		"aren't welcomed and stay in the starter role. Raid mode turns on by itself after RaidJoinCount joins in RaidJoinSeconds (accounts "+
+		"younger than RaidAccountAgeDays or without an avatar count twice) and ends after RaidModeMinutes without anyone joining.", commPrefix)
+}
+
+/*
+Gets the raid state for a guild, making it if needed. Must be called with the guilds lock held
+*/
+func (rc *RaidCommand) getState(guildUid string) *raidState {
+	state, ok := rc.guilds.m[guildUid]
+	if !ok {
+		state = &raidState{}
+		rc.guilds.m[guildUid] = state
+	}
+	return state
+}
+
+/*
+Takes a server out of raid mode, returning everyone that joined during it and whether it was in raid mode at all
+*/
+func (rc *RaidCommand) endRaid(guildUid string) (held []string, wasOn bool) {
+	rc.guilds.Lock()
+	defer rc.guilds.Unlock()
+	state, ok := rc.guilds.m[guildUid]
+	if !ok || state.until.IsZero() {
+		return nil, false
+	}
+	delete(rc.guilds.m, guildUid)
+	return state.held, true
+}
+
+func (rc *RaidCommand) endExpiredRaids(session *discordgo.Session) {
+	now := time.Now()
+	var expired []string
+	rc.guilds.Lock()
+	for guildUid, state := range rc.guilds.m {
+		if !state.until.IsZero() && now.After(state.until) {
+			expired = append(expired, guildUid)
+		}
+	}
+	rc.guilds.Unlock()
+	for _, guildUid := range expired {
+		held, wasOn := rc.endRaid(guildUid)
+		if !wasOn {
+			// ended by a mod in the meantime
+			continue
+		}
+		log.Println("Raid mode ended for guild", guildUid)
+		server, err := db.ServerQueryOrInsert(guildUid)
+		if err != nil || !server.BotChannel.Valid {
+			continue
+		}
+		session.ChannelMessageSend(server.BotChannel.String, "Raid mode has ended since nobody has joined in a while. "+formatRaidHeld(held))
+	}
+}
+
+func (rc *RaidCommand) alertRaidStart(session *discordgo.Session, server types.Server, joins []raidJoin, window time.Duration) {
+	if !server.BotChannel.Valid {
+		return
+	}
+	suspicious := 0
+	var names []string
+	for _, j := range joins {
+		if j.suspicious {
+			suspicious++
+		}
+		if len(names) < raidListLimit {
+			names = append(names, j.name)
+		}
+	}
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Possible raid!") + " " + strconv.Itoa(len(joins)) + " members joined in the last " +
+		strconv.Itoa(int(window.Seconds())) + " seconds, " + strconv.Itoa(suspicious) + " of them look like new accounts: " + strings.Join(names, ", "))
+	message.WriteString("\nRaid mode is on. Welcome messages are off and new members will stay in the starter role until it ends")
+	if !server.StarterRole.Valid {
+		message.WriteString(" (this server doesn't have a starter role, so they can still talk)")
+	}
+	message.WriteString(". It'll end after " + strconv.Itoa(int(raidModeLength(server).Minutes())) + " minutes without anyone joining, or use `" +
+		rc.ComPrefix + " raid off`.")
+	session.ChannelMessageSend(server.BotChannel.String, message.String())
+}
+
+/*
+Names a member along with their ID, which is what mods need to ban someone that's already left
+*/
+func raidMemberName(user *discordgo.User) string {
+	return user.String() + " (" + user.ID + ")"
+}
+
+func raidModeLength(server types.Server) time.Duration {
+	if server.RaidModeMinutes.Valid && server.RaidModeMinutes.Int64 > 0 {
+		return time.Duration(server.RaidModeMinutes.Int64) * time.Minute
+	}
+	return raidDefaultMinutes * time.Minute
+}
+
+/*
+Accounts that were only just made, or never bothered to set an avatar, are what raids are usually made of
+*/
+func isSuspiciousAccount(user *discordgo.User, now time.Time, accountAgeDays int) bool {
+	if user.Avatar == "" {
+		return true
+	}
+	created, err := discordgo.SnowflakeTimestamp(user.ID)
+	if err != nil {
+		return true
+	}
+	return now.Sub(created) < time.Duration(accountAgeDays)*24*time.Hour
+}
+
+/*
+Drops any joins from before the given time. Joins are always in order so only the front needs checking
+*/
+func pruneRaidJoins(joins []raidJoin, since time.Time) []raidJoin {
+	i := 0
+	for i < len(joins) && joins[i].joinedAt.Before(since) {
+		i++
+	}
+	return joins[i:]
+}
+
+func raidJoinWeight(joins []raidJoin) (weight int) {
+	for _, j := range joins {
+		if j.suspicious {
+			weight += 2
+		} else {
+			weight++
+		}
+	}
+	return
+}
+
+func formatRaidHeld(held []string) string {
+	if len(held) == 0 {
+		return "Nobody joined during it."
+	}
+	shown := held
+	if len(shown) > raidListLimit {
+		shown = shown[:raidListLimit]
+	}
+	message := strconv.Itoa(len(held)) + " members joined during it: " + strings.Join(shown, ", ")
+	if len(held) > raidListLimit {
+		message += " and " + strconv.Itoa(len(held)-raidListLimit) + " more"
+	}
+	return message
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRaidCommand_IsSuspiciousAccount(t *testing.T) {
+	// made 2018-01-01
+	created := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := (created.UnixNano()/int64(time.Millisecond) - 1420070400000) << 22
+	user := &discordgo.User{ID: strconv.FormatInt(id, 10), Avatar: "abc"}
+	if isSuspiciousAccount(user, created.AddDate(0, 0, 30), 7) {
+		t.Error("A month old account with an avatar shouldn't be suspicious")
+	}
+	if !isSuspiciousAccount(user, created.AddDate(0, 0, 2), 7) {
+		t.Error("A two day old account should be suspicious")
+	}
+	user.Avatar = ""
+	if !isSuspiciousAccount(user, created.AddDate(0, 0, 30), 7) {
+		t.Error("An account without an avatar should be suspicious")
+	}
+}
+
+func TestRaidCommand_JoinWeight(t *testing.T) {
+	now := time.Now()
+	joins := []raidJoin{
+		{userUid: "1", joinedAt: now.Add(-time.Minute)},
+		{userUid: "2", joinedAt: now.Add(-5 * time.Second), suspicious: true},
+		{userUid: "3", joinedAt: now},
+	}
+	joins = pruneRaidJoins(joins, now.Add(-10*time.Second))
+	if len(joins) != 2 || joins[0].userUid != "2" {
+		t.Errorf("Joins outside the window should be dropped, got %v", joins)
+	}
+	if w := raidJoinWeight(joins); w != 3 {
+		t.Errorf("Suspicious joins should count twice, expected 3 got %d", w)
+	}
+}
+
+func TestRaidCommand_FormatRaidHeld(t *testing.T) {
+	user := &discordgo.User{ID: "123", Username: "moe", Discriminator: "0001"}
+	if held := formatRaidHeld([]string{raidMemberName(user)}); held != "1 members joined during it: moe#0001 (123)" {
+		t.Errorf("Held members should be listed by name without pinging them, got %q", held)
+	}
+}
@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
@@ -14,6 +16,12 @@ import (
 	"github.com/camd67/moebot/moebot_bot/util/rolerules"
 )
 
+const (
+	roleMaxSuggestions = 3
+	// Minimum length before we'll suggest roles that contain what was typed
+	roleSuggestionMinContains = 3
+)
+
 type RoleCommand struct {
 	ComPrefix   string
 	PermChecker permissions.PermissionChecker
@@ -44,9 +52,25 @@ func (rc *RoleCommand) Execute(pack *CommPackage) {
 		}
 		roleNameString := strings.TrimSpace(roleNameBuf.String())
 		dbRole, err = db.RoleQueryTrigger(roleNameString, server.Id)
-		// an invalid trigger should pretty much never happen, but checking for it anyways
-		// however an error may indicate that there were simply no roles in the result set
-		if err != nil || !dbRole.Trigger.Valid {
+		if err == sql.ErrNoRows {
+			// no exact match, see if they just made a typo or used the role's name instead
+			serverRoles, err := db.RoleQueryServer(server)
+			if err != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the roles for this server. This is an issue with moebot!")
+				return
+			}
+			match, suggestions := findRoleSuggestions(roleNameString, serverRoles, pack.guild.Roles)
+			if match == nil {
+				message := "Sorry, the role you provided doesn't exist."
+				if len(suggestions) > 0 {
+					message += " Did you mean `" + strings.Join(suggestions, "`, `") + "`?"
+				}
+				pack.session.ChannelMessageSend(pack.channel.ID, message+" Use `"+rc.ComPrefix+" role` to list all roles for this server.")
+				return
+			}
+			dbRole = *match
+		} else if err != nil || !dbRole.Trigger.Valid {
+			// an invalid trigger should pretty much never happen, but checking for it anyways
 			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the role, or the role you provided doesn't exist. "+
 				"Please provide a valid role. `"+rc.ComPrefix+" role` to list all roles for this server.")
 			return
@@ -100,6 +124,62 @@ func (rc *RoleCommand) Execute(pack *CommPackage) {
 	}
 }
 
+/*
+Finds the role a user most likely meant when what they typed didn't match any trigger. Typing a role's actual name (ignoring case) counts
+as a match, otherwise the closest triggers are returned as suggestions, best first.
+*/
+func findRoleSuggestions(input string, roles []types.Role, guildRoles []*discordgo.Role) (match *types.Role, suggestions []string) {
+	type suggestion struct {
+		trigger  string
+		distance int
+	}
+	var found []suggestion
+	for i, r := range roles {
+		if !r.Trigger.Valid {
+			// can't suggest roles nobody is allowed to pick
+			continue
+		}
+		candidates := append([]string{r.Trigger.String}, r.Aliases...)
+		if guildRole := moeDiscord.FindRoleById(guildRoles, r.RoleUid); guildRole != nil {
+			if strings.EqualFold(guildRole.Name, input) {
+				return &roles[i], nil
+			}
+			candidates = append(candidates, guildRole.Name)
+		}
+		best := -1
+		for _, c := range candidates {
+			distance := util.EditDistance(input, c)
+			if len(input) >= roleSuggestionMinContains && strings.Contains(strings.ToUpper(c), strings.ToUpper(input)) {
+				// typing part of a longer name is still a good guess
+				distance = 1
+			}
+			if best < 0 || distance < best {
+				best = distance
+			}
+		}
+		if best <= roleSuggestionMaxDistance(input) {
+			found = append(found, suggestion{trigger: r.Trigger.String, distance: best})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].distance < found[j].distance
+	})
+	for i := 0; i < len(found) && i < roleMaxSuggestions; i++ {
+		suggestions = append(suggestions, found[i].trigger)
+	}
+	return nil, suggestions
+}
+
+/*
+Longer inputs get more room for typos, so that short triggers don't end up suggesting everything
+*/
+func roleSuggestionMaxDistance(input string) int {
+	if len(input) < 6 {
+		return 1
+	}
+	return len(input) / 3
+}
+
 func checkRules(rules []rolerules.RoleRule, action *rolerules.RoleAction, pack *CommPackage) (bool, string) {
 	var builder strings.Builder
 	for _, rule := range rules {
@@ -19,7 +19,7 @@ type RoleSetCommand struct {
 }
 
 func (rc *RoleSetCommand) Execute(pack *CommPackage) {
-	args := ParseCommand(pack.params, []string{"-delete", "-role", "-trigger", "-confirm", "-security", "-group"})
+	args := ParseCommand(pack.params, []string{"-delete", "-role", "-trigger", "-confirm", "-security", "-group", "-alias"})
 
 	server, err := db.ServerQueryOrInsert(pack.guild.ID)
 	if err != nil {
@@ -32,8 +32,9 @@ func (rc *RoleSetCommand) Execute(pack *CommPackage) {
 	confirmText, hasConfirm := args["-confirm"]
 	securityText, hasSecurity := args["-security"]
 	groupText, hasGroup := args["-group"]
+	aliasText, hasAlias := args["-alias"]
 
-	if !hasDelete && !hasRole && !hasTrigger && !hasConfirm && !hasSecurity && !hasGroup {
+	if !hasDelete && !hasRole && !hasTrigger && !hasConfirm && !hasSecurity && !hasGroup && !hasAlias {
 		// empty command (or just really bad one)
 		var vetRole *discordgo.Role
 		if server.VeteranRole.Valid {
@@ -50,8 +51,8 @@ func (rc *RoleSetCommand) Execute(pack *CommPackage) {
 			pack.session.ChannelMessageSend(pack.channel.ID, "This command requires a role (supplied with -role)")
 			return
 		}
-		if !hasTrigger && !hasConfirm && !hasSecurity && !hasGroup {
-			pack.session.ChannelMessageSend(pack.channel.ID, "You must provide at least one of: trigger, confirm, group, or security")
+		if !hasTrigger && !hasConfirm && !hasSecurity && !hasGroup && !hasAlias {
+			pack.session.ChannelMessageSend(pack.channel.ID, "You must provide at least one of: trigger, confirm, group, alias, or security")
 			return
 		}
 
@@ -90,7 +91,40 @@ func (rc *RoleSetCommand) Execute(pack *CommPackage) {
 					db.RoleMaxTriggerLengthString+". The role was not updated.")
 				return
 			}
-			oldRole.Trigger.Scan(strings.TrimSpace(triggerName))
+			triggerName = strings.TrimSpace(triggerName)
+			if existing, err := db.RoleQueryTrigger(triggerName, server.Id); err == nil && existing.RoleUid != oldRole.RoleUid {
+				pack.session.ChannelMessageSend(pack.channel.ID, "The trigger `"+triggerName+"` is already used by another role. The role was not updated.")
+				return
+			}
+			oldRole.Trigger.Scan(triggerName)
+		}
+		if hasAlias {
+			aliasText = strings.TrimSpace(aliasText)
+			if len(aliasText) <= 0 || len(aliasText) > db.RoleMaxTriggerLength {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide an alias greater than 0 characters and less than "+
+					db.RoleMaxTriggerLengthString+". The role was not updated.")
+				return
+			}
+			if existing, err := db.RoleQueryTrigger(aliasText, server.Id); err == nil && existing.RoleUid != oldRole.RoleUid {
+				pack.session.ChannelMessageSend(pack.channel.ID, "The alias `"+aliasText+"` is already used by another role. The role was not updated.")
+				return
+			}
+			// same as groups, giving an alias the role already has removes it
+			aliasIndex := -1
+			for i, a := range oldRole.Aliases {
+				if strings.EqualFold(a, aliasText) {
+					aliasIndex = i
+				}
+			}
+			if aliasIndex >= 0 {
+				oldRole.Aliases = append(oldRole.Aliases[:aliasIndex], oldRole.Aliases[aliasIndex+1:]...)
+			} else {
+				oldRole.Aliases = append(oldRole.Aliases, aliasText)
+			}
+			if oldRole.Aliases == nil {
+				// nil aliases are treated as "leave them alone" when saving
+				oldRole.Aliases = []string{}
+			}
 		}
 		if hasConfirm {
 			if len(confirmText) < 0 || len(confirmText) > db.MaxMessageLength {
@@ -126,19 +160,21 @@ func (rc *RoleSetCommand) Execute(pack *CommPackage) {
 			oldRole.ConfirmationSecurityAnswer.Scan(securityText)
 		}
 
-		group, err := db.RoleGroupQueryName(groupText, server.Id)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				pack.session.ChannelMessageSend(pack.channel.ID, "You must provide a group that exists. You can create this with the groupset command.")
-			} else {
-				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue querying for the provided group. This is an issue with moebot "+
-					"and not discord.")
+		if hasGroup {
+			group, err := db.RoleGroupQueryName(groupText, server.Id)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					pack.session.ChannelMessageSend(pack.channel.ID, "You must provide a group that exists. You can create this with the groupset command.")
+				} else {
+					pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue querying for the provided group. This is an issue with moebot "+
+						"and not discord.")
+				}
+				return
+			}
+			if updateRoleGroups(server, &oldRole, group) != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "There was an error updating role groups. This is an issue with moebot and not discord")
+				return
 			}
-			return
-		}
-		if updateRoleGroups(server, &oldRole, group) != nil {
-			pack.session.ChannelMessageSend(pack.channel.ID, "There was an error updating role groups. This is an issue with moebot and not discord")
-			return
 		}
 
 		oldRole.ServerId = server.Id
@@ -179,7 +215,7 @@ func (rc *RoleSetCommand) GetCommandKeys() []string {
 
 func (rc *RoleSetCommand) GetCommandHelp(commPrefix string) string {
 	return fmt.Sprintf("`%[1]s roleset -role <role name> [-trigger <trigger> -confirm <confirmation message> -security <security code> "+
-		"-group <group name>]` - Master/Mod. Provide roleName plus at least one other option. Security code must be prefixed with `-` in your "+
+		"-group <group name> -alias <alias>]` - Master/Mod. Provide roleName plus at least one other option. Giving an existing group or alias removes it. Security code must be prefixed with `-` in your "+
 		"confirmation message if you want to include it.", commPrefix)
 }
 
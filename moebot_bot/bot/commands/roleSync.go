@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+/*
+Keeps moebot's role configuration in sync with the roles that actually exist in each guild.
+Roles deleted in discord are removed from the database, and the server's bot channel is told about anything that got cleaned up.
+*/
+type RoleSyncHandler struct {
+	// guild ID -> role ID -> role name. Discord doesn't tell us what a role used to be called, so we need to remember it ourselves
+	roleNames struct {
+		sync.RWMutex
+		m map[string]map[string]string
+	}
+}
+
+func NewRoleSyncHandler() *RoleSyncHandler {
+	result := &RoleSyncHandler{}
+	result.roleNames.m = make(map[string]map[string]string)
+	return result
+}
+
+func (rs *RoleSyncHandler) EventHandlers() []interface{} {
+	return []interface{}{rs.roleSyncGuildCreate, rs.roleSyncGuildRoleDelete, rs.roleSyncGuildRoleUpdate}
+}
+
+/*
+Called when moebot starts up (or joins a guild). Any roles that were deleted while moebot was offline get cleaned up here.
+*/
+func (rs *RoleSyncHandler) roleSyncGuildCreate(session *discordgo.Session, guildCreate *discordgo.GuildCreate) {
+	if guildCreate.Guild == nil || guildCreate.Unavailable {
+		return
+	}
+	names := make(map[string]string)
+	for _, r := range guildCreate.Roles {
+		names[r.ID] = r.Name
+	}
+	rs.roleNames.Lock()
+	rs.roleNames.m[guildCreate.ID] = names
+	rs.roleNames.Unlock()
+
+	server, err := db.ServerQueryOrInsert(guildCreate.ID)
+	if err != nil {
+		log.Println("Error fetching server during role sync", err)
+		return
+	}
+	rs.cleanupRoles(session, server, func(roleUid string) bool {
+		return moeDiscord.FindRoleById(guildCreate.Roles, roleUid) == nil
+	})
+}
+
+func (rs *RoleSyncHandler) roleSyncGuildRoleDelete(session *discordgo.Session, roleDelete *discordgo.GuildRoleDelete) {
+	rs.roleNames.Lock()
+	if names, ok := rs.roleNames.m[roleDelete.GuildID]; ok {
+		delete(names, roleDelete.RoleID)
+	}
+	rs.roleNames.Unlock()
+
+	server, err := db.ServerQueryOrInsert(roleDelete.GuildID)
+	if err != nil {
+		log.Println("Error fetching server during role delete", err)
+		return
+	}
+	rs.cleanupRoles(session, server, func(roleUid string) bool {
+		return roleUid == roleDelete.RoleID
+	})
+}
+
+/*
+Renaming a role doesn't break anything in the database since everything is stored by ID, but triggers are usually named after the role
+so let the mods know in case they want to update it.
+*/
+func (rs *RoleSyncHandler) roleSyncGuildRoleUpdate(session *discordgo.Session, roleUpdate *discordgo.GuildRoleUpdate) {
+	if roleUpdate.GuildRole == nil || roleUpdate.Role == nil {
+		return
+	}
+	rs.roleNames.Lock()
+	names, ok := rs.roleNames.m[roleUpdate.GuildID]
+	if !ok {
+		names = make(map[string]string)
+		rs.roleNames.m[roleUpdate.GuildID] = names
+	}
+	oldName, hadName := names[roleUpdate.Role.ID]
+	names[roleUpdate.Role.ID] = roleUpdate.Role.Name
+	rs.roleNames.Unlock()
+
+	if !hadName || oldName == roleUpdate.Role.Name {
+		return
+	}
+	server, err := db.ServerQueryOrInsert(roleUpdate.GuildID)
+	if err != nil {
+		log.Println("Error fetching server during role update", err)
+		return
+	}
+	dbRole, err := db.RoleQueryRoleUid(roleUpdate.Role.ID, server.Id)
+	if err != nil || !dbRole.Trigger.Valid {
+		// not a role moebot knows about (or we couldn't load it), nothing to tell anyone
+		return
+	}
+	sendToBotChannel(session, server, "The role `"+oldName+"` was renamed to `"+roleUpdate.Role.Name+"`. It can still be assigned with the trigger `"+
+		dbRole.Trigger.String+"`, use roleset if you'd like to change that.")
+}
+
+/*
+Removes any role configuration for roles that isMissing reports as no longer existing in the guild, and lets the bot channel know what was removed
+*/
+func (rs *RoleSyncHandler) cleanupRoles(session *discordgo.Session, server types.Server, isMissing func(roleUid string) bool) {
+	var cleaned []string
+	roles, err := db.RoleQueryServer(server)
+	if err != nil {
+		log.Println("Error fetching roles during role sync", err)
+		return
+	}
+	for _, r := range roles {
+		if !isMissing(strings.TrimSpace(r.RoleUid)) {
+			continue
+		}
+		if db.RoleDelete(r.RoleUid, server.GuildUid) != nil {
+			continue
+		}
+		if r.Trigger.Valid {
+			cleaned = append(cleaned, "Removed the role with trigger `"+r.Trigger.String+"` along with its group memberships")
+		} else {
+			cleaned = append(cleaned, "Removed the role permission settings for role ID `"+r.RoleUid+"`")
+		}
+	}
+
+	serverChanged := false
+	clearServerRole := func(toClear *sql.NullString, name string) {
+		if toClear.Valid && isMissing(toClear.String) {
+			toClear.Scan(nil)
+			serverChanged = true
+			cleaned = append(cleaned, "Cleared the server's "+name+" setting")
+		}
+	}
+	clearServerRole(&server.StarterRole, "StarterRole")
+	clearServerRole(&server.BaseRole, "BaseRole")
+	clearServerRole(&server.VeteranRole, "VeteranRole")
+	if serverChanged && db.ServerFullUpdate(server) != nil {
+		cleaned = append(cleaned, "!!! Failed to save the server changes, please check the server configuration !!!")
+	}
+
+	if len(cleaned) > 0 {
+		log.Println("Cleaned up deleted roles for guild " + server.GuildUid + ": " + strings.Join(cleaned, ", "))
+		sendToBotChannel(session, server, "Some roles moebot was using were deleted from this server:\n"+strings.Join(cleaned, "\n"))
+	}
+}
+
+func sendToBotChannel(session *discordgo.Session, server types.Server, message string) {
+	if server.BotChannel.Valid {
+		session.ChannelMessageSend(server.BotChannel.String, message)
+	}
+}
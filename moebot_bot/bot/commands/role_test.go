@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+func TestRoleCommand_FindRoleSuggestions(t *testing.T) {
+	roles := []types.Role{
+		{RoleUid: "1", Trigger: sql.NullString{String: "artist", Valid: true}},
+		{RoleUid: "2", Trigger: sql.NullString{String: "gamer", Valid: true}, Aliases: []string{"games"}},
+		{RoleUid: "3", Trigger: sql.NullString{String: "announcements", Valid: true}},
+		{RoleUid: "4"},
+	}
+	guildRoles := []*discordgo.Role{{ID: "1", Name: "Artists"}, {ID: "3", Name: "Ping Me"}, {ID: "4", Name: "Staff"}}
+	checks := []struct {
+		input       string
+		match       string
+		suggestions []string
+	}{
+		{"ping me", "3", nil},
+		{"artst", "", []string{"artist"}},
+		{"game", "", []string{"gamer"}},
+		{"announce", "", []string{"announcements"}},
+		{"staff", "", nil},
+		{"zzzzzz", "", nil},
+	}
+	for _, c := range checks {
+		match, suggestions := findRoleSuggestions(c.input, roles, guildRoles)
+		matchUid := ""
+		if match != nil {
+			matchUid = match.RoleUid
+		}
+		if matchUid != c.match || fmt.Sprint(suggestions) != fmt.Sprint(c.suggestions) {
+			t.Errorf("%q: expected match %q suggestions %v, got %q %v", c.input, c.match, c.suggestions, matchUid, suggestions)
+		}
+	}
+}
@@ -18,6 +18,7 @@ func NewScheduleCommand(factory *SchedulerFactory) *ScheduleCommand {
 	return &ScheduleCommand{
 		schedulers: map[types.SchedulerType]Scheduler{
 			db.SchedulerChannelRotation: factory.CreateScheduler(db.SchedulerChannelRotation),
+			db.SchedulerPointDecay:      factory.CreateScheduler(db.SchedulerPointDecay),
 		},
 	}
 }
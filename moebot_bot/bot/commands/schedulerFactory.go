@@ -2,6 +2,7 @@ package commands
 
 import (
 	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db"
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
 
@@ -14,5 +15,8 @@ func NewSchedulerFactory(session *discordgo.Session) *SchedulerFactory {
 }
 
 func (f *SchedulerFactory) CreateScheduler(t types.SchedulerType) Scheduler {
+	if t == db.SchedulerPointDecay {
+		return NewPointDecayScheduler(t, f.session)
+	}
 	return NewChannelRotationScheduler(t, f.session)
 }
@@ -5,17 +5,33 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/camd67/moebot/moebot_bot/util"
 	"github.com/camd67/moebot/moebot_bot/util/db"
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+	"github.com/camd67/moebot/moebot_bot/util/rolerules"
 )
 
 const serverPossibleCommands = "Possible configs: {WelcomeMessage -> string; max length " + db.MaxMessageLengthString + "} " +
 	"{WelcomeChannel -> ChannelId} {VeteranRank -> number} {VeteranRole -> full role name} {BotChannel -> channel ID} {RuleAgreement -> string; max length " +
-	db.MaxMessageLengthString + "} {StarterRole -> full role name} {BaseRole -> full role name} {Enabled -> true/false}"
+	db.MaxMessageLengthString + "} {StarterRole -> full role name} {BaseRole -> full role name} {Enabled -> true/false} {RoleCodeExpiry -> minutes} " +
+	"{RoleCodeSecret -> clear only, invalidates all confirmation codes} {VeteranMessagePoints -> number} {VeteranReactionPoints -> number} " +
+	"{VeteranMessageCooldown -> seconds} {VeteranReactionCooldown -> seconds} {VeteranIgnoredPrefixes -> space separated prefixes, or none} " +
+	"{VeteranExcludedChannels -> channel ID, toggles} {VeteranExcludedRoles -> full role name, toggles} " +
+	"{VeteranVoicePoints -> number per minute} {VeteranVoiceDailyCap -> number} " +
+	"{ProfileAccentColor -> hex colour like #7289da} {StatsRetentionDays -> number} " +
+	"{MuteRole -> full role name} {ModLogChannel -> channel ID} " +
+	"{MessageLogChannel -> channel ID} {MessageLogExcludedChannels -> channel ID, toggles} " +
+	"{RaidJoinCount -> number} {RaidJoinSeconds -> seconds} {RaidAccountAgeDays -> days} {RaidModeMinutes -> minutes} " +
+	"{UnverifiedRemindHours -> hours} {UnverifiedKickHours -> hours} " +
+	"{CaptchaEnabled -> true/false} {CaptchaMinutes -> minutes} {CaptchaAttempts -> number} " +
+	"{StarboardChannel -> channel ID} {StarboardEmoji -> emoji} {StarboardThreshold -> number} {StarboardSelfStar -> true/false} " +
+	"{StarboardAllowNsfw -> true/false} {WelcomeEmbed -> true/false} {GoodbyeMessage -> string; max length " + db.MaxMessageLengthString + "} " +
+	"{GoodbyeChannel -> channel ID} {RulesChannel -> channel ID}. Welcome and goodbye messages can use " + welcomePlaceholders + ", " +
+	"use `server testwelcome` to preview them"
 
 type ServerCommand struct {
 	ComPrefix string
@@ -43,6 +59,10 @@ func (sc *ServerCommand) Execute(pack *CommPackage) {
 		configKeyIndex = 1
 	}
 	configKey := strings.ToUpper(pack.params[configKeyIndex])
+	if configKey == "TESTWELCOME" {
+		sc.testWelcome(pack, s)
+		return
+	}
 	var configValue string
 	if len(pack.params)-configKeyIndex == 1 {
 		// they didn't  provide any arguments, so it's a help command instead
@@ -139,6 +159,52 @@ func (sc *ServerCommand) processServerConfigKey(configKey string, configValue st
 			}
 			s.WelcomeChannel.Scan(c.ID)
 		}
+	} else if configKey == "WELCOMEEMBED" {
+		if !sc.defaultServerBoolSet(pack, configValue, &s.WelcomeEmbed, isHelp, "WelcomeEmbed", shouldClear) {
+			return
+		}
+	} else if configKey == "GOODBYEMESSAGE" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "GoodbyeMessage:"+util.GetStringOrDefault(s.GoodbyeMessage))
+		} else if shouldClear {
+			s.GoodbyeMessage.Scan(nil)
+		} else {
+			if len(configValue) > db.MaxMessageLength {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, this property has a max length of: "+db.MaxMessageLengthString)
+				return false
+			}
+			if strings.HasPrefix(configValue, sc.ComPrefix) {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, you can't use moebot's prefix in your goodbye message.")
+				return false
+			}
+			s.GoodbyeMessage.Scan(configValue)
+		}
+	} else if configKey == "GOODBYECHANNEL" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "GoodbyeChannel: "+util.GetStringOrDefault(s.GoodbyeChannel))
+		} else if shouldClear {
+			s.GoodbyeChannel.Scan(nil)
+		} else {
+			c, err := moeDiscord.GetChannel(configValue, pack.session)
+			if err != nil || c.Type != discordgo.ChannelTypeGuildText || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid text channel ID")
+				return false
+			}
+			s.GoodbyeChannel.Scan(c.ID)
+		}
+	} else if configKey == "RULESCHANNEL" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "RulesChannel: "+util.GetStringOrDefault(s.RulesChannel))
+		} else if shouldClear {
+			s.RulesChannel.Scan(nil)
+		} else {
+			c, err := moeDiscord.GetChannel(configValue, pack.session)
+			if err != nil || c.Type != discordgo.ChannelTypeGuildText || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid text channel ID")
+				return false
+			}
+			s.RulesChannel.Scan(c.ID)
+		}
 	} else if configKey == "RULEAGREEMENT" {
 		if isHelp {
 			pack.session.ChannelMessageSend(pack.channel.ID, "RuleAgreement: "+util.GetStringOrDefault(s.RuleAgreement))
@@ -176,6 +242,271 @@ func (sc *ServerCommand) processServerConfigKey(configKey string, configValue st
 			}
 			s.Enabled = newBool
 		}
+	} else if configKey == "ROLECODEEXPIRY" {
+		if isHelp {
+			expiry := serverIntDefault(rolerules.DefaultRoleCodeExpiry)
+			if s.RoleCodeExpiry.Valid {
+				expiry = strconv.FormatInt(s.RoleCodeExpiry.Int64, 10)
+			}
+			pack.session.ChannelMessageSend(pack.channel.ID, "RoleCodeExpiry: "+expiry)
+		} else if shouldClear {
+			s.RoleCodeExpiry.Scan(nil)
+		} else {
+			expiry, err := strconv.Atoi(configValue)
+			if err != nil || expiry <= 0 {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a positive number of minutes for the role code expiry")
+				return false
+			}
+			s.RoleCodeExpiry.Scan(int64(expiry))
+		}
+	} else if configKey == "ROLECODESECRET" {
+		// the secret itself is never shown, it can only be thrown away so that a new one gets generated
+		if !shouldClear {
+			pack.session.ChannelMessageSend(pack.channel.ID, "The role code secret can't be viewed or set. Use `"+sc.ComPrefix+
+				" server -clear RoleCodeSecret` to invalidate every confirmation code that has been handed out.")
+			return false
+		}
+		if db.ServerClearRoleCodeSecret(s.Id) != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error clearing the role code secret. Your change was probably not applied.")
+			return false
+		}
+	} else if configKey == "VETERANMESSAGEPOINTS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranMessagePoints, isHelp, "VeteranMessagePoints",
+			serverIntDefault(messagePoints), shouldClear) {
+			return
+		}
+	} else if configKey == "VETERANREACTIONPOINTS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranReactionPoints, isHelp, "VeteranReactionPoints",
+			serverIntDefault(reactionPoints), shouldClear) {
+			return
+		}
+	} else if configKey == "VETERANMESSAGECOOLDOWN" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranMessageCooldown, isHelp, "VeteranMessageCooldown",
+			serverIntDefault(int(messageCooldown/time.Second)), shouldClear) {
+			return
+		}
+	} else if configKey == "VETERANREACTIONCOOLDOWN" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranReactionCooldown, isHelp, "VeteranReactionCooldown",
+			serverIntDefault(int(reactionCooldown/time.Second)), shouldClear) {
+			return
+		}
+	} else if configKey == "VETERANVOICEPOINTS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranVoicePoints, isHelp, "VeteranVoicePoints", serverIntOff, shouldClear) {
+			return
+		}
+	} else if configKey == "VETERANVOICEDAILYCAP" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.VeteranVoiceDailyCap, isHelp, "VeteranVoiceDailyCap",
+			serverIntDefault(voiceDailyCap), shouldClear) {
+			return
+		}
+	} else if configKey == "STATSRETENTIONDAYS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.StatsRetentionDays, isHelp, "StatsRetentionDays",
+			serverIntDefault(db.ChannelActivityDefaultRetention), shouldClear) {
+			return
+		}
+	} else if configKey == "RAIDJOINCOUNT" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.RaidJoinCount, isHelp, "RaidJoinCount", serverIntOff, shouldClear) {
+			return
+		}
+	} else if configKey == "RAIDJOINSECONDS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.RaidJoinSeconds, isHelp, "RaidJoinSeconds",
+			serverIntDefault(raidDefaultSeconds), shouldClear) {
+			return
+		}
+	} else if configKey == "RAIDACCOUNTAGEDAYS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.RaidAccountAgeDays, isHelp, "RaidAccountAgeDays",
+			serverIntDefault(raidDefaultAccountAge), shouldClear) {
+			return
+		}
+	} else if configKey == "RAIDMODEMINUTES" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.RaidModeMinutes, isHelp, "RaidModeMinutes",
+			serverIntDefault(raidDefaultMinutes), shouldClear) {
+			return
+		}
+	} else if configKey == "UNVERIFIEDREMINDHOURS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.UnverifiedRemindHours, isHelp, "UnverifiedRemindHours", serverIntOff, shouldClear) {
+			return
+		}
+		if !sc.validUnverifiedHours(pack, s) {
+			return false
+		}
+	} else if configKey == "UNVERIFIEDKICKHOURS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.UnverifiedKickHours, isHelp, "UnverifiedKickHours", serverIntOff, shouldClear) {
+			return
+		}
+		if !sc.validUnverifiedHours(pack, s) {
+			return false
+		}
+	} else if configKey == "CAPTCHAENABLED" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "CaptchaEnabled: "+strconv.FormatBool(s.CaptchaEnabled))
+		} else if shouldClear {
+			s.CaptchaEnabled = false
+		} else {
+			newBool, err := strconv.ParseBool(configValue)
+			if err != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, I don't recognize that as a boolean. Please provide either true/false.")
+				return
+			}
+			if newBool && (!s.StarterRole.Valid || !s.BaseRole.Valid) {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please set a StarterRole and BaseRole before turning on the captcha.")
+				return
+			}
+			s.CaptchaEnabled = newBool
+		}
+	} else if configKey == "CAPTCHAMINUTES" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.CaptchaMinutes, isHelp, "CaptchaMinutes",
+			serverIntDefault(captchaDefaultMinutes), shouldClear) {
+			return
+		}
+	} else if configKey == "CAPTCHAATTEMPTS" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.CaptchaAttempts, isHelp, "CaptchaAttempts",
+			serverIntDefault(captchaDefaultTries), shouldClear) {
+			return
+		}
+	} else if configKey == "STARBOARDCHANNEL" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "StarboardChannel: "+util.GetStringOrDefault(s.StarboardChannel))
+		} else if shouldClear {
+			s.StarboardChannel.Scan(nil)
+		} else {
+			c, err := moeDiscord.GetChannel(configValue, pack.session)
+			if err != nil || c.Type != discordgo.ChannelTypeGuildText || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid text channel ID")
+				return false
+			}
+			s.StarboardChannel.Scan(c.ID)
+		}
+	} else if configKey == "STARBOARDEMOJI" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "StarboardEmoji: "+util.GetStringOrDefault(s.StarboardEmoji))
+		} else if shouldClear {
+			s.StarboardEmoji.Scan(nil)
+		} else {
+			emoji, ok := parseStarboardEmoji(configValue)
+			if !ok {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a single emoji")
+				return false
+			}
+			s.StarboardEmoji.Scan(emoji)
+		}
+	} else if configKey == "STARBOARDTHRESHOLD" {
+		if !sc.defaultServerIntSet(pack, configValue, &s.StarboardThreshold, isHelp, "StarboardThreshold",
+			serverIntDefault(starboardDefaultThreshold), shouldClear) {
+			return
+		}
+	} else if configKey == "STARBOARDSELFSTAR" {
+		if !sc.defaultServerBoolSet(pack, configValue, &s.StarboardSelfStar, isHelp, "StarboardSelfStar", shouldClear) {
+			return
+		}
+	} else if configKey == "STARBOARDALLOWNSFW" {
+		if !sc.defaultServerBoolSet(pack, configValue, &s.StarboardAllowNsfw, isHelp, "StarboardAllowNsfw", shouldClear) {
+			return
+		}
+	} else if configKey == "MUTEROLE" {
+		if !sc.defaultServerRoleSet(pack, configValue, &s.MuteRole, isHelp, "MuteRole", shouldClear) {
+			return
+		}
+	} else if configKey == "MODLOGCHANNEL" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "ModLogChannel: "+util.GetStringOrDefault(s.ModLogChannel))
+		} else if shouldClear {
+			s.ModLogChannel.Scan(nil)
+		} else {
+			c, err := moeDiscord.GetChannel(configValue, pack.session)
+			if err != nil || c.Type != discordgo.ChannelTypeGuildText || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid text channel ID")
+				return false
+			}
+			s.ModLogChannel.Scan(c.ID)
+		}
+	} else if configKey == "MESSAGELOGCHANNEL" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "MessageLogChannel: "+util.GetStringOrDefault(s.MessageLogChannel))
+		} else if shouldClear {
+			s.MessageLogChannel.Scan(nil)
+		} else {
+			c, err := moeDiscord.GetChannel(configValue, pack.session)
+			if err != nil || c.Type != discordgo.ChannelTypeGuildText || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid text channel ID")
+				return false
+			}
+			s.MessageLogChannel.Scan(c.ID)
+		}
+	} else if configKey == "MESSAGELOGEXCLUDEDCHANNELS" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "MessageLogExcludedChannels: "+strings.Join(s.MessageLogExcludedChannels, ", "))
+		} else if shouldClear {
+			s.MessageLogExcludedChannels = nil
+		} else {
+			channelId := configValue
+			if id, ok := util.ExtractChannelIdFromString(configValue); ok {
+				channelId = id
+			}
+			c, err := moeDiscord.GetChannel(channelId, pack.session)
+			if err != nil || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid channel ID")
+				return false
+			}
+			s.MessageLogExcludedChannels = toggleString(s.MessageLogExcludedChannels, c.ID)
+		}
+	} else if configKey == "PROFILEACCENTCOLOR" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "ProfileAccentColor: "+util.GetStringOrDefault(s.ProfileAccentColor))
+		} else if shouldClear {
+			s.ProfileAccentColor.Scan(nil)
+		} else {
+			if _, ok := util.ParseHexColor(configValue); !ok {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a hex colour like #7289da")
+				return false
+			}
+			s.ProfileAccentColor.Scan("#" + strings.ToLower(strings.TrimPrefix(configValue, "#")))
+		}
+	} else if configKey == "VETERANIGNOREDPREFIXES" {
+		if isHelp {
+			if s.VeteranIgnoredPrefixes == nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "VeteranIgnoredPrefixes: default")
+			} else {
+				pack.session.ChannelMessageSend(pack.channel.ID, "VeteranIgnoredPrefixes: "+strings.Join(s.VeteranIgnoredPrefixes, " "))
+			}
+		} else if shouldClear {
+			s.VeteranIgnoredPrefixes = nil
+		} else if strings.EqualFold(configValue, "none") {
+			// not the same as clearing, which goes back to the default prefixes
+			s.VeteranIgnoredPrefixes = []string{}
+		} else {
+			s.VeteranIgnoredPrefixes = strings.Fields(configValue)
+		}
+	} else if configKey == "VETERANEXCLUDEDCHANNELS" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "VeteranExcludedChannels: "+strings.Join(s.VeteranExcludedChannels, ", "))
+		} else if shouldClear {
+			s.VeteranExcludedChannels = nil
+		} else {
+			channelId := configValue
+			if id, ok := util.ExtractChannelIdFromString(configValue); ok {
+				channelId = id
+			}
+			c, err := moeDiscord.GetChannel(channelId, pack.session)
+			if err != nil || c.GuildID != pack.guild.ID {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid channel ID")
+				return false
+			}
+			s.VeteranExcludedChannels = toggleString(s.VeteranExcludedChannels, c.ID)
+		}
+	} else if configKey == "VETERANEXCLUDEDROLES" {
+		if isHelp {
+			pack.session.ChannelMessageSend(pack.channel.ID, "VeteranExcludedRoles: "+strings.Join(s.VeteranExcludedRoles, ", "))
+		} else if shouldClear {
+			s.VeteranExcludedRoles = nil
+		} else {
+			role := moeDiscord.FindRoleByName(pack.guild.Roles, configValue)
+			if role == nil {
+				pack.session.ChannelMessageSend(pack.message.ChannelID, "Please provide a valid role and make sure it's the full role name")
+				return false
+			}
+			s.VeteranExcludedRoles = toggleString(s.VeteranExcludedRoles, role.ID)
+		}
 	} else {
 		pack.session.ChannelMessageSend(pack.message.ChannelID, serverPossibleCommands)
 		return false
@@ -203,6 +534,108 @@ func (sc *ServerCommand) defaultServerRoleSet(pack *CommPackage, configValue str
 	return true
 }
 
+/*
+Sets a number in the server config. unset is what help shows when the server hasn't picked a number, so it shows what's actually in use
+*/
+func (sc *ServerCommand) defaultServerIntSet(pack *CommPackage, configValue string, toSet *sql.NullInt64, isHelp bool, name string, unset string,
+	shouldClear bool) (shouldReturn bool) {
+
+	if isHelp {
+		value := unset
+		if toSet.Valid {
+			value = strconv.FormatInt(toSet.Int64, 10)
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, name+": "+value)
+		return false
+	} else if shouldClear {
+		toSet.Scan(nil)
+	} else {
+		value, err := strconv.Atoi(configValue)
+		if err != nil || value < 0 {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a positive number for "+name)
+			return false
+		}
+		toSet.Scan(int64(value))
+	}
+	return true
+}
+
+// What help shows for a number that's off until the server sets it
+const serverIntOff = "off (not set)"
+
+func serverIntDefault(value int) string {
+	return strconv.Itoa(value) + " (default)"
+}
+
+/*
+Unverified members shouldn't be kicked the moment they join, or before they've had their reminder
+*/
+func (sc *ServerCommand) validUnverifiedHours(pack *CommPackage, s *types.Server) bool {
+	if (s.UnverifiedRemindHours.Valid && s.UnverifiedRemindHours.Int64 < 1) || (s.UnverifiedKickHours.Valid && s.UnverifiedKickHours.Int64 < 1) {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide at least 1 hour.")
+		return false
+	}
+	if s.UnverifiedRemindHours.Valid && s.UnverifiedKickHours.Valid && s.UnverifiedKickHours.Int64 <= s.UnverifiedRemindHours.Int64 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "UnverifiedKickHours has to be more than UnverifiedRemindHours, so members get their "+
+			"reminder before they're kicked.")
+		return false
+	}
+	return true
+}
+
+func (sc *ServerCommand) defaultServerBoolSet(pack *CommPackage, configValue string, toSet *bool, isHelp bool, name string,
+	shouldClear bool) (shouldReturn bool) {
+
+	if isHelp {
+		pack.session.ChannelMessageSend(pack.channel.ID, name+": "+strconv.FormatBool(*toSet))
+		return false
+	} else if shouldClear {
+		*toSet = false
+	} else {
+		value, err := strconv.ParseBool(configValue)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, I don't recognize that as a boolean. Please provide either true/false.")
+			return false
+		}
+		*toSet = value
+	}
+	return true
+}
+
+/*
+Shows the caller what the welcome and goodbye messages would look like for them, here instead of wherever they'd normally go
+*/
+func (sc *ServerCommand) testWelcome(pack *CommPackage, s types.Server) {
+	if !s.WelcomeMessage.Valid && !s.GoodbyeMessage.Valid {
+		pack.session.ChannelMessageSend(pack.channel.ID, "This server doesn't have a WelcomeMessage or GoodbyeMessage to preview.")
+		return
+	}
+	if s.WelcomeMessage.Valid {
+		pack.session.ChannelMessageSend(pack.channel.ID, util.MakeStringBold("Welcome message:"))
+		pack.session.ChannelMessageSendComplex(pack.channel.ID, makeWelcomeMessage(s.WelcomeMessage.String, pack.guild, s, pack.message.Author))
+	}
+	if s.GoodbyeMessage.Valid {
+		pack.session.ChannelMessageSend(pack.channel.ID, util.MakeStringBold("Goodbye message:"))
+		pack.session.ChannelMessageSendComplex(pack.channel.ID, makeWelcomeMessage(s.GoodbyeMessage.String, pack.guild, s, pack.message.Author))
+	}
+}
+
+/*
+Adds the value to the list if it isn't already there, otherwise removes it. Always returns a new list since the old one may still be cached
+*/
+func toggleString(list []string, value string) []string {
+	var result []string
+	for _, s := range list {
+		if s != value {
+			result = append(result, s)
+		}
+	}
+	if len(result) == len(list) {
+		result = append(result, value)
+	}
+	return result
+}
+
 func (sc *ServerCommand) GetPermLevel() types.Permission {
 	return types.PermMod
 }
@@ -212,5 +645,6 @@ func (sc *ServerCommand) GetCommandKeys() []string {
 }
 
 func (sc *ServerCommand) GetCommandHelp(commPrefix string) string {
-	return fmt.Sprintf("`%[1]s server <config setting> <value>` - Master/Mod Changes a config setting on the server to a given value. `%[1]s server` to list configs.", commPrefix)
+	return fmt.Sprintf("`%[1]s server <config setting> <value>` - Master/Mod Changes a config setting on the server to a given value. `%[1]s server` to list configs. "+
+		"`%[1]s server testwelcome` to preview the welcome and goodbye messages.", commPrefix)
 }
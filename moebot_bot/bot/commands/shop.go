@@ -0,0 +1,402 @@
+package commands
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	shopExpireInterval  = time.Minute
+	shopHistoryLimit    = 10
+	shopHistoryFormat   = "2006-01-02 15:04"
+	shopColorRoleSuffix = "'s colour"
+)
+
+/*
+Lets members spend the points they've earned on whatever the mods have put up for sale
+*/
+type ShopCommand struct {
+	ComPrefix string
+	stopCh    chan struct{}
+}
+
+func NewShopCommand(comPrefix string) *ShopCommand {
+	return &ShopCommand{
+		ComPrefix: comPrefix,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (sc *ShopCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(pack.params) == 0 {
+		sc.listItems(pack, server)
+		return
+	}
+	switch strings.ToUpper(pack.params[0]) {
+	case "BUY":
+		sc.buyItem(pack, server)
+	case "BALANCE":
+		balance, err := db.UserServerRankQueryBalance(pack.user.Id, server.Id)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching your balance. This is an issue with moebot and not Discord.")
+			return
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, pack.message.Author.Mention()+", you have "+strconv.Itoa(balance)+" points to spend.")
+	case "HISTORY":
+		showShopHistory(pack, server, pack.user.Id, "Your purchases:")
+	default:
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, `"+pack.params[0]+"` isn't a valid action. See `"+sc.ComPrefix+" help` for more info.")
+	}
+}
+
+func (sc *ShopCommand) GetPermLevel() types.Permission {
+	return types.PermAll
+}
+
+func (sc *ShopCommand) GetCommandKeys() []string {
+	return []string{"SHOP"}
+}
+
+func (sc *ShopCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s shop` - Lists what's for sale. `%[1]s shop buy <item> [#colour]` buys an item with the points you've earned, "+
+		"colour items need a colour like #7289da. `%[1]s shop balance` shows how many points you can spend and `%[1]s shop history` "+
+		"shows what you've bought.", commPrefix)
+}
+
+func (sc *ShopCommand) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(shopExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				expireShopPurchases(session)
+			case <-sc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (sc *ShopCommand) Shutdown(session *discordgo.Session) {
+	close(sc.stopCh)
+}
+
+func (sc *ShopCommand) listItems(pack *CommPackage, server types.Server) {
+	items, err := db.ShopItemQueryServer(server.Id)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the shop. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(items) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There isn't anything for sale on this server yet.")
+		return
+	}
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("For sale:"))
+	for _, i := range items {
+		message.WriteString("\n" + util.MakeStringCode(i.Name) + " - " + strconv.Itoa(i.Price) + " points, " + describeShopItem(i, pack.guild))
+	}
+	message.WriteString("\nBuy something with `" + sc.ComPrefix + " shop buy <item>`.")
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func (sc *ShopCommand) buyItem(pack *CommPackage, server types.Server) {
+	if len(pack.params) < 2 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide the item you'd like to buy.")
+		return
+	}
+	nameParams := pack.params[1:]
+	var colorText string
+	if len(nameParams) > 1 && strings.HasPrefix(nameParams[len(nameParams)-1], "#") {
+		colorText = nameParams[len(nameParams)-1]
+		nameParams = nameParams[:len(nameParams)-1]
+	}
+	name := strings.Join(nameParams, " ")
+	item, err := db.ShopItemQueryName(server.Id, name)
+	if err == sql.ErrNoRows {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't an item called `"+name+"`. Use `"+sc.ComPrefix+" shop` to see what's for sale.")
+		return
+	} else if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the item. This is an issue with moebot and not Discord.")
+		return
+	}
+
+	var roleUid sql.NullString
+	var expiresAt time.Time
+	var color int
+	var hadRole bool
+	switch item.Type {
+	case types.ShopItemRole, types.ShopItemTempRole:
+		if !item.RoleUid.Valid || moeDiscord.FindRoleById(pack.guild.Roles, item.RoleUid.String) == nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, the role for that item doesn't exist anymore. Please let a mod know.")
+			return
+		}
+		roleUid = item.RoleUid
+		if util.StrContains(pack.member.Roles, roleUid.String, util.CaseSensitive) {
+			// if the shop is what gave them the role, it still needs taking away once every purchase is over
+			count, activeHadRole, err := db.ShopPurchaseCountActive(pack.user.Id, server.Id, roleUid.String)
+			if err != nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue checking your roles. This is an issue with moebot and not Discord.")
+				return
+			}
+			hadRole = count == 0 || activeHadRole
+		}
+		if item.Type == types.ShopItemTempRole {
+			expiresAt = time.Now().Add(time.Duration(item.DurationMinutes.Int64) * time.Minute)
+		}
+	case types.ShopItemColor:
+		c, ok := util.ParseHexColor(colorText)
+		if !ok {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide the colour you'd like after the item name, for example `"+sc.ComPrefix+
+				" shop buy "+item.Name+" #7289da`.")
+			return
+		}
+		color = int(c.R)<<16 | int(c.G)<<8 | int(c.B)
+	}
+
+	purchase, balance, err := db.ShopPurchaseBuy(pack.user.Id, server.Id, item, roleUid, expiresAt, hadRole)
+	if err == db.ErrNotEnoughBalance {
+		current, _ := db.UserServerRankQueryBalance(pack.user.Id, server.Id)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, "+item.Name+" costs "+strconv.Itoa(item.Price)+" points and you only have "+
+			strconv.Itoa(current)+", so you need "+strconv.Itoa(shopShortfall(item.Price, current))+" more.")
+		return
+	} else if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue buying that item. This is an issue with moebot and not Discord.")
+		return
+	}
+	purchase.GuildUid = pack.guild.ID
+	purchase.UserUid = pack.message.Author.ID
+
+	err = applyShopPurchase(pack.session, pack.guild, pack.member, &purchase, color)
+	if err != nil {
+		log.Println("Failed to apply shop purchase "+strconv.Itoa(purchase.Id)+", refunding it. ", err)
+		if _, err = db.ShopPurchaseRefund(purchase.Id, server.Id, pack.user.Id); err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue giving you "+item.Name+" and your points couldn't be refunded. "+
+				"Please let a mod know, your purchase ID is "+strconv.Itoa(purchase.Id)+".")
+			return
+		}
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue giving you "+item.Name+", so your points have been refunded. "+
+			"Please let a mod know.")
+		return
+	}
+
+	message := pack.message.Author.Mention() + ", you bought " + item.Name + " for " + strconv.Itoa(item.Price) + " points!"
+	if item.Type == types.ShopItemTempRole {
+		message += " It lasts until " + expiresAt.UTC().Format(shopHistoryFormat) + " UTC."
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message+" You have "+strconv.Itoa(balance)+" points left.")
+}
+
+/*
+Gives the member whatever they bought. Colour purchases get their role filled in here since it may not exist until now
+*/
+func applyShopPurchase(session *discordgo.Session, guild *discordgo.Guild, member *discordgo.Member, purchase *types.ShopPurchase, color int) error {
+	switch purchase.ItemType {
+	case types.ShopItemRole, types.ShopItemTempRole:
+		return session.GuildMemberRoleAdd(guild.ID, purchase.UserUid, purchase.RoleUid.String)
+	case types.ShopItemColor:
+		return applyColorPurchase(session, guild, member, purchase, color)
+	case types.ShopItemRaffle:
+		return changeRaffleTickets(guild.ID, purchase.UserUid, 1)
+	}
+	return errors.New("unknown shop item type " + purchase.ItemType)
+}
+
+/*
+Recolours the member's colour role, or makes them a new one just below moebot's highest role so the colour shows up
+*/
+func applyColorPurchase(session *discordgo.Session, guild *discordgo.Guild, member *discordgo.Member, purchase *types.ShopPurchase, color int) error {
+	roleName := member.User.Username + shopColorRoleSuffix
+	roleUid, err := db.ShopPurchaseQueryColorRole(purchase.UserId, purchase.ServerId)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	role := moeDiscord.FindRoleById(guild.Roles, roleUid)
+	if role == nil {
+		role, err = session.GuildRoleCreate(guild.ID)
+		if err != nil {
+			return err
+		}
+		botMember, err := moeDiscord.GetMember(session.State.User.ID, guild.ID, session)
+		if err == nil {
+			position := 0
+			for _, id := range botMember.Roles {
+				if r := moeDiscord.FindRoleById(guild.Roles, id); r != nil && r.Position > position {
+					position = r.Position
+				}
+			}
+			if position > 1 {
+				role.Position = position - 1
+				if _, err = session.GuildRoleReorder(guild.ID, []*discordgo.Role{role}); err != nil {
+					// the colour still works, it just might be hidden by a higher role's colour
+					log.Println("Failed to move colour role "+role.ID+" for User UID: "+purchase.UserUid, err)
+				}
+			}
+		}
+	}
+	_, err = session.GuildRoleEdit(guild.ID, role.ID, roleName, color, false, 0, false)
+	if err != nil {
+		return err
+	}
+	if err = db.ShopPurchaseSetRole(purchase.Id, role.ID); err != nil {
+		return err
+	}
+	purchase.RoleUid.Scan(role.ID)
+	return session.GuildMemberRoleAdd(guild.ID, purchase.UserUid, role.ID)
+}
+
+/*
+Takes back whatever a refunded purchase gave the member. Roles are only taken away once no other purchase is still giving them out,
+and never if the member had them before buying
+*/
+func undoShopPurchase(session *discordgo.Session, purchase types.ShopPurchase) error {
+	switch purchase.ItemType {
+	case types.ShopItemRaffle:
+		return changeRaffleTickets(purchase.GuildUid, purchase.UserUid, -1)
+	case types.ShopItemRole, types.ShopItemTempRole, types.ShopItemColor:
+		if !purchase.RoleUid.Valid || purchase.Expired {
+			return nil
+		}
+		count, _, err := db.ShopPurchaseCountActive(purchase.UserId, purchase.ServerId, purchase.RoleUid.String)
+		if err != nil || !shopRefundTakesRole(purchase, count) {
+			return err
+		}
+		if purchase.ItemType == types.ShopItemColor {
+			// nobody else has this role, so clean it up rather than leaving an empty role behind
+			return session.GuildRoleDelete(purchase.GuildUid, purchase.RoleUid.String)
+		}
+		return session.GuildMemberRoleRemove(purchase.GuildUid, purchase.UserUid, purchase.RoleUid.String)
+	}
+	return nil
+}
+
+/*
+Adds (or takes away) raffle tickets, entering the member into the raffle if they haven't joined yet
+*/
+func changeRaffleTickets(guildUid string, userUid string, tickets int) error {
+	raffles, err := db.RaffleEntryQuery(userUid, guildUid)
+	if err != nil {
+		return err
+	}
+	if len(raffles) == 0 {
+		if tickets < 0 {
+			return nil
+		}
+		return db.RaffleEntryAdd(types.RaffleEntry{
+			GuildUid:    guildUid,
+			UserUid:     userUid,
+			RaffleType:  db.RaffleMIA,
+			TicketCount: tickets,
+			RaffleData:  "NONE" + db.RaffleDataSeparator + "NONE",
+		})
+	}
+	return db.RaffleEntryUpdate(raffles[0], clampTicketChange(raffles[0].TicketCount, tickets))
+}
+
+/*
+Makes sure taking tickets away never leaves a member with less than none
+*/
+func clampTicketChange(current int, change int) int {
+	if current+change < 0 {
+		return -current
+	}
+	return change
+}
+
+/*
+How many more points are needed to afford something, never less than 0
+*/
+func shopShortfall(price int, balance int) int {
+	if balance >= price {
+		return 0
+	}
+	return price - balance
+}
+
+/*
+Whether refunding a purchase should take its role away. activePurchases is how many other purchases are still giving out the same role
+*/
+func shopRefundTakesRole(purchase types.ShopPurchase, activePurchases int) bool {
+	return purchase.RoleUid.Valid && !purchase.Expired && !purchase.HadRole && activePurchases == 0
+}
+
+/*
+Takes away temp roles once they've run out, unless the member had the role before buying it
+*/
+func expireShopPurchases(session *discordgo.Session) {
+	purchases, err := db.ShopPurchaseQueryExpired()
+	if err != nil {
+		return
+	}
+	for _, p := range purchases {
+		if p.HadRole {
+			db.ShopPurchaseSetExpired(p.Id)
+			continue
+		}
+		err = session.GuildMemberRoleRemove(p.GuildUid, p.UserUid, p.RoleUid.String)
+		if err != nil {
+			// most likely they already left the server or the role was deleted, either way there's nothing left to take away
+			log.Println("Failed to remove expired shop role "+p.RoleUid.String+" from User UID: "+p.UserUid, err)
+		}
+		db.ShopPurchaseSetExpired(p.Id)
+	}
+}
+
+func describeShopItem(i types.ShopItem, guild *discordgo.Guild) string {
+	roleName := i.RoleUid.String
+	if role := moeDiscord.FindRoleById(guild.Roles, i.RoleUid.String); role != nil {
+		roleName = role.Name
+	}
+	switch i.Type {
+	case types.ShopItemRole:
+		return "gives " + roleName
+	case types.ShopItemTempRole:
+		return "gives " + roleName + " for " + (time.Duration(i.DurationMinutes.Int64) * time.Minute).String()
+	case types.ShopItemColor:
+		return "a custom colour for your name"
+	case types.ShopItemRaffle:
+		return "a raffle ticket"
+	}
+	return i.Type
+}
+
+func showShopHistory(pack *CommPackage, server types.Server, userId int, title string) {
+	purchases, err := db.ShopPurchaseQueryUser(userId, server.Id, shopHistoryLimit)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the purchases. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(purchases) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There aren't any purchases yet.")
+		return
+	}
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold(title))
+	for _, p := range purchases {
+		message.WriteString("\n" + util.MakeStringCode("#"+strconv.Itoa(p.Id)) + " " + p.CreatedAt.Format(shopHistoryFormat) + " " + p.ItemName +
+			" for " + strconv.Itoa(p.Price) + " points")
+		if p.Refunded {
+			message.WriteString(" " + util.MakeStringItalic("(refunded)"))
+		} else if p.Expired {
+			message.WriteString(" " + util.MakeStringItalic("(expired)"))
+		}
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+/*
+Lets mods stock the shop, and refund purchases
+*/
+type ShopSetCommand struct {
+	ComPrefix string
+}
+
+func (sc *ShopSetCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	args := ParseCommand(pack.params, []string{"-name", "-type", "-price", "-role", "-duration", "-delete", "-refund", "-history"})
+	if name, ok := args["-delete"]; ok {
+		deleted, err := db.ShopItemDelete(server.Id, name)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue deleting the item. This is an issue with moebot and not Discord.")
+		} else if !deleted {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't an item called `"+name+"`.")
+		} else {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Deleted the item `"+name+"`. Anything already bought is kept.")
+		}
+		return
+	}
+	if idText, ok := args["-refund"]; ok {
+		sc.refund(pack, server, idText)
+		return
+	}
+	if userText, ok := args["-history"]; ok {
+		userUid, ok := util.ExtractUserIdFromString(userText)
+		if !ok {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid user mention or ID.")
+			return
+		}
+		user, err := db.UserQueryOrInsert(userUid)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching that user. This is an issue with moebot and not Discord.")
+			return
+		}
+		showShopHistory(pack, server, user.Id, "Purchases by "+util.UserIdToMention(userUid)+":")
+		return
+	}
+
+	name, ok := args["-name"]
+	if !ok || name == "" {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide an item name with -name. See `"+sc.ComPrefix+" help` for more info.")
+		return
+	}
+	if len(name) > db.ShopItemMaxNameLength {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, item names have a max length of "+db.ShopItemMaxNameLengthString)
+		return
+	}
+	item := types.ShopItem{ServerId: server.Id, Name: name, Type: strings.ToLower(args["-type"])}
+	if item.Type != types.ShopItemRole && item.Type != types.ShopItemTempRole && item.Type != types.ShopItemColor && item.Type != types.ShopItemRaffle {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a -type of role, temprole, color, or raffle.")
+		return
+	}
+	if item.Price, ok = parseShopPrice(args["-price"]); !ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a -price greater than 0.")
+		return
+	}
+	if item.Type == types.ShopItemRole || item.Type == types.ShopItemTempRole {
+		role := moeDiscord.FindRoleByName(pack.guild.Roles, args["-role"])
+		if role == nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid role with -role and make sure it's the full role name")
+			return
+		}
+		// anyone can buy from the shop, so it can't become a way around the role hierarchy
+		if ok, err := moeDiscord.CanManageRole(pack.session, pack.guild, pack.member, role); err != nil || !ok {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, that role is at or above your highest role or moebot's, so it can't be sold.")
+			return
+		}
+		item.RoleUid.Scan(role.ID)
+	}
+	if item.Type == types.ShopItemTempRole {
+		minutes, ok := parseShopDuration(args["-duration"])
+		if !ok {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide how many minutes the role lasts with -duration.")
+			return
+		}
+		item.DurationMinutes.Scan(minutes)
+	}
+	if db.ShopItemInsertOrUpdate(item) != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue saving the item. This is an issue with moebot and not Discord.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Saved the item `"+item.Name+"` for "+strconv.Itoa(item.Price)+" points, "+
+		describeShopItem(item, pack.guild)+".")
+}
+
+func (sc *ShopSetCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (sc *ShopSetCommand) GetCommandKeys() []string {
+	return []string{"SHOPSET"}
+}
+
+func (sc *ShopSetCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s shopset -name <name> -type <role|temprole|color|raffle> -price <points> [-role <role name> -duration <minutes>]` - Mod. "+
+		"Creates or updates a shop item. Role items need -role, and temprole items also need -duration. `%[1]s shopset -delete <name>` "+
+		"deletes an item, `%[1]s shopset -refund <purchase id>` refunds a purchase and takes back what it gave, and "+
+		"`%[1]s shopset -history <user>` shows what a user has bought.", commPrefix)
+}
+
+func (sc *ShopSetCommand) refund(pack *CommPackage, server types.Server, idText string) {
+	purchaseId, err := strconv.Atoi(strings.TrimPrefix(idText, "#"))
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid purchase ID.")
+		return
+	}
+	purchase, err := db.ShopPurchaseQuery(purchaseId, server.Id)
+	if err == sql.ErrNoRows {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't a purchase with that ID on this server.")
+		return
+	} else if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the purchase. This is an issue with moebot and not Discord.")
+		return
+	}
+	refunded, err := db.ShopPurchaseRefund(purchase.Id, server.Id, pack.user.Id)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue refunding the purchase. This is an issue with moebot and not Discord.")
+		return
+	} else if !refunded {
+		pack.session.ChannelMessageSend(pack.channel.ID, "That purchase has already been refunded.")
+		return
+	}
+	message := "Refunded " + strconv.Itoa(purchase.Price) + " points to " + util.UserIdToMention(purchase.UserUid) + " for " + purchase.ItemName + "."
+	if err = undoShopPurchase(pack.session, purchase); err != nil {
+		log.Println("Failed to undo shop purchase "+strconv.Itoa(purchase.Id), err)
+		message += " There was an issue taking back what they bought, so you may need to do that by hand."
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message)
+}
+
+/*
+Parses an item's price, which has to be a whole number of points above 0
+*/
+func parseShopPrice(text string) (int, bool) {
+	price, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || price <= 0 {
+		return 0, false
+	}
+	return price, true
+}
+
+/*
+Parses how many minutes a temp role lasts. Anything that would overflow once turned into a time.Duration is refused
+*/
+func parseShopDuration(text string) (int64, bool) {
+	minutes, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil || minutes <= 0 || minutes > int64(math.MaxInt64/time.Minute) {
+		return 0, false
+	}
+	return minutes, true
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+func TestShopSetCommand_ParseShopPrice(t *testing.T) {
+	checks := []struct {
+		text     string
+		expected int
+		valid    bool
+	}{
+		{"100", 100, true},
+		{" 5 ", 5, true},
+		{"0", 0, false},
+		{"-10", 0, false},
+		{"1.5", 0, false},
+		{"", 0, false},
+		{"lots", 0, false},
+	}
+	for _, c := range checks {
+		actual, ok := parseShopPrice(c.text)
+		if actual != c.expected || ok != c.valid {
+			t.Errorf("Parsing %q: expected %d %t, got %d %t", c.text, c.expected, c.valid, actual, ok)
+		}
+	}
+}
+
+func TestShopSetCommand_ParseShopDuration(t *testing.T) {
+	checks := []struct {
+		text     string
+		expected int64
+		valid    bool
+	}{
+		{"60", 60, true},
+		{"1", 1, true},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"1h", 0, false},
+		{"", 0, false},
+		{"9223372036854775807", 0, false},
+	}
+	for _, c := range checks {
+		actual, ok := parseShopDuration(c.text)
+		if actual != c.expected || ok != c.valid {
+			t.Errorf("Parsing %q: expected %d %t, got %d %t", c.text, c.expected, c.valid, actual, ok)
+		}
+	}
+}
+
+func TestShopCommand_ShopShortfall(t *testing.T) {
+	checks := []struct {
+		price, balance, expected int
+	}{
+		{100, 40, 60},
+		{100, 100, 0},
+		{100, 250, 0},
+		{100, 0, 100},
+	}
+	for _, c := range checks {
+		if actual := shopShortfall(c.price, c.balance); actual != c.expected {
+			t.Errorf("Price %d with balance %d: expected %d short, got %d", c.price, c.balance, c.expected, actual)
+		}
+	}
+}
+
+func TestShopCommand_ClampTicketChange(t *testing.T) {
+	checks := []struct {
+		current, change, expected int
+	}{
+		{3, -1, -1},
+		{1, -1, -1},
+		{0, -1, 0},
+		{0, 1, 1},
+		{2, -5, -2},
+	}
+	for _, c := range checks {
+		if actual := clampTicketChange(c.current, c.change); actual != c.expected {
+			t.Errorf("Changing %d tickets by %d: expected %d, got %d", c.current, c.change, c.expected, actual)
+		}
+	}
+}
+
+func TestShopCommand_ShopRefundTakesRole(t *testing.T) {
+	role := sql.NullString{String: "1", Valid: true}
+	checks := []struct {
+		name     string
+		purchase types.ShopPurchase
+		active   int
+		expected bool
+	}{
+		{"last purchase", types.ShopPurchase{RoleUid: role}, 0, true},
+		{"still bought", types.ShopPurchase{RoleUid: role}, 1, false},
+		{"had it before", types.ShopPurchase{RoleUid: role, HadRole: true}, 0, false},
+		{"already expired", types.ShopPurchase{RoleUid: role, Expired: true}, 0, false},
+		{"no role", types.ShopPurchase{}, 0, false},
+	}
+	for _, c := range checks {
+		if actual := shopRefundTakesRole(c.purchase, c.active); actual != c.expected {
+			t.Errorf("%s: expected %t, got %t", c.name, c.expected, actual)
+		}
+	}
+}
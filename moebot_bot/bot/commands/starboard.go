@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"database/sql"
+	"log"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	starboardDefaultEmoji     = "⭐"
+	starboardDefaultThreshold = 3
+	starboardColor            = 0xffac33
+	// discord only gives back this many reactions at a time, anything past it isn't counted
+	starboardMaxReactions = 100
+	// embeds cut off descriptions past this
+	starboardMaxContent = 2000
+)
+
+var starboardCustomEmoji = regexp.MustCompile(`^<a?:(\w+:\d+)>$`)
+
+/*
+Reposts messages that get enough of a server's star emoji to its star channel, and keeps the star count on the repost up to date
+*/
+type StarboardHandler struct {
+	// reactions tend to come in bursts, this stops two of them reposting the same message at once. Message ID -> lock for that message,
+	// so other messages and servers aren't held up
+	locks struct {
+		sync.Mutex
+		m map[string]*starboardLock
+	}
+}
+
+type starboardLock struct {
+	sync.Mutex
+	// how many reactions are holding or waiting on the lock, it's thrown away once this is back to 0
+	users int
+}
+
+func (sh *StarboardHandler) EventHandlers() []interface{} {
+	return []interface{}{sh.starboardReactionAdd, sh.starboardReactionRemove, sh.starboardReactionRemoveAll}
+}
+
+func (sh *StarboardHandler) starboardReactionAdd(session *discordgo.Session, reaction *discordgo.MessageReactionAdd) {
+	sh.updateStars(session, reaction.ChannelID, reaction.MessageID, reaction.Emoji.APIName())
+}
+
+func (sh *StarboardHandler) starboardReactionRemove(session *discordgo.Session, reaction *discordgo.MessageReactionRemove) {
+	sh.updateStars(session, reaction.ChannelID, reaction.MessageID, reaction.Emoji.APIName())
+}
+
+func (sh *StarboardHandler) starboardReactionRemoveAll(session *discordgo.Session, reaction *discordgo.MessageReactionRemoveAll) {
+	sh.updateStars(session, reaction.ChannelID, reaction.MessageID, "")
+}
+
+/*
+Recounts the stars on a message and reposts or updates it as needed. An empty emoji means every reaction was removed at once
+*/
+func (sh *StarboardHandler) updateStars(session *discordgo.Session, channelUid string, messageUid string, emoji string) {
+	channel, err := moeDiscord.GetChannel(channelUid, session)
+	if err != nil || channel.GuildID == "" {
+		return
+	}
+	server, err := db.ServerQueryOrInsert(channel.GuildID)
+	if err != nil || !server.Enabled || !server.StarboardChannel.Valid || channelUid == server.StarboardChannel.String {
+		return
+	}
+	starEmoji := starboardDefaultEmoji
+	if server.StarboardEmoji.Valid {
+		starEmoji = server.StarboardEmoji.String
+	}
+	if (emoji != "" && emoji != starEmoji) || (channel.NSFW && !server.StarboardAllowNsfw) {
+		return
+	}
+	threshold := starboardDefaultThreshold
+	if server.StarboardThreshold.Valid && server.StarboardThreshold.Int64 > 0 {
+		threshold = int(server.StarboardThreshold.Int64)
+	}
+
+	defer sh.lockMessage(messageUid)()
+	message, err := session.ChannelMessage(channelUid, messageUid)
+	if err != nil || message.Author == nil {
+		return
+	}
+	count := 0
+	if emoji != "" {
+		users, err := session.MessageReactions(channelUid, messageUid, starEmoji, starboardMaxReactions)
+		if err != nil {
+			log.Println("Error fetching starboard reactions", err)
+			return
+		}
+		count = countStars(users, message.Author.ID, server.StarboardSelfStar)
+	}
+
+	entry, err := db.StarboardEntryQueryMessage(messageUid)
+	if err == sql.ErrNoRows {
+		if count < threshold {
+			return
+		}
+		entry = types.StarboardEntry{
+			ServerId:   server.Id,
+			ChannelUid: channelUid,
+			MessageUid: messageUid,
+			AuthorUid:  message.Author.ID,
+			StarCount:  count,
+		}
+		posted, err := session.ChannelMessageSendComplex(server.StarboardChannel.String, &discordgo.MessageSend{
+			Content: formatStarboardCount(starEmoji, isAnimatedEmoji(session, channel.GuildID, starEmoji), count, channelUid),
+			Embed:   makeStarboardEmbed(message, channel.GuildID),
+		})
+		if err != nil {
+			log.Println("Error posting to starboard", err)
+			return
+		}
+		entry.StarboardMessageUid.Scan(posted.ID)
+		db.StarboardEntryInsert(entry)
+		return
+	} else if err != nil {
+		log.Println("Error fetching starboard entry", err)
+		return
+	}
+	if count == entry.StarCount || !entry.StarboardMessageUid.Valid {
+		return
+	}
+	entry.StarCount = count
+	// the repost stays up even if it drops below the threshold, it just shows the lower count
+	edit := discordgo.NewMessageEdit(server.StarboardChannel.String, entry.StarboardMessageUid.String).
+		SetContent(formatStarboardCount(starEmoji, isAnimatedEmoji(session, channel.GuildID, starEmoji), count, channelUid)).
+		SetEmbed(makeStarboardEmbed(message, channel.GuildID))
+	if _, err = session.ChannelMessageEditComplex(edit); err != nil {
+		log.Println("Error updating starboard message", err)
+		return
+	}
+	db.StarboardEntryUpdate(entry)
+}
+
+/*
+Counts everyone that starred the message, ignoring bots and optionally the author
+*/
+func countStars(users []*discordgo.User, authorUid string, selfStar bool) (count int) {
+	for _, u := range users {
+		if u.Bot || (!selfStar && u.ID == authorUid) {
+			continue
+		}
+		count++
+	}
+	return
+}
+
+func formatStarboardCount(emoji string, animated bool, count int, channelUid string) string {
+	if starboardCustomEmoji.MatchString("<:" + emoji + ">") {
+		if animated {
+			emoji = "<a:" + emoji + ">"
+		} else {
+			emoji = "<:" + emoji + ">"
+		}
+	}
+	return emoji + " **" + strconv.Itoa(count) + "** <#" + channelUid + ">"
+}
+
+/*
+Checks if a custom emoji stored as name:id is animated, which changes how it has to be written in a message. Unicode emoji never are
+*/
+func isAnimatedEmoji(session *discordgo.Session, guildUid string, emoji string) bool {
+	colon := strings.LastIndex(emoji, ":")
+	if colon < 0 {
+		return false
+	}
+	guild, err := moeDiscord.GetGuild(guildUid, session)
+	if err != nil {
+		return false
+	}
+	for _, e := range guild.Emojis {
+		if e.ID == emoji[colon+1:] {
+			return e.Animated
+		}
+	}
+	return false
+}
+
+/*
+Waits for any other reaction on the same message to finish, returning a func that lets the next one go
+*/
+func (sh *StarboardHandler) lockMessage(messageUid string) func() {
+	sh.locks.Lock()
+	if sh.locks.m == nil {
+		sh.locks.m = make(map[string]*starboardLock)
+	}
+	l, ok := sh.locks.m[messageUid]
+	if !ok {
+		l = &starboardLock{}
+		sh.locks.m[messageUid] = l
+	}
+	l.users++
+	sh.locks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		sh.locks.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(sh.locks.m, messageUid)
+		}
+		sh.locks.Unlock()
+	}
+}
+
+func makeStarboardEmbed(message *discordgo.Message, guildUid string) *discordgo.MessageEmbed {
+	content := []rune(message.Content)
+	if len(content) > starboardMaxContent {
+		content = append(content[:starboardMaxContent], []rune("...")...)
+	}
+	embed := &discordgo.MessageEmbed{
+		Author:      &discordgo.MessageEmbedAuthor{Name: message.Author.String()},
+		Description: string(content),
+		Color:       starboardColor,
+		Fields: []*discordgo.MessageEmbedField{{
+			Name:  "Source",
+			Value: "[Jump to message](https://discordapp.com/channels/" + guildUid + "/" + message.ChannelID + "/" + message.ID + ")",
+		}},
+	}
+	if message.Author.Avatar != "" {
+		embed.Author.IconURL = discordgo.EndpointUserAvatar(message.Author.ID, message.Author.Avatar)
+	}
+	if created, err := discordgo.SnowflakeTimestamp(message.ID); err == nil {
+		embed.Timestamp = created.UTC().Format(time.RFC3339)
+	}
+	for _, a := range message.Attachments {
+		if strings.HasPrefix(mime.TypeByExtension(filepath.Ext(a.Filename)), "image") {
+			embed.Image = &discordgo.MessageEmbedImage{URL: a.URL}
+			return embed
+		}
+	}
+	// images posted as links
+	for _, e := range message.Embeds {
+		if e.Type == "image" {
+			embed.Image = &discordgo.MessageEmbedImage{URL: e.URL}
+			return embed
+		}
+	}
+	return embed
+}
+
+/*
+Turns what someone typed for the starboard emoji into how discord names it in reactions, either the unicode emoji itself or name:id
+*/
+func parseStarboardEmoji(text string) (string, bool) {
+	if m := starboardCustomEmoji.FindStringSubmatch(text); m != nil {
+		return m[1], true
+	}
+	// anything else has to be a single unicode emoji, which can be a few code points long with skin tones and joiners
+	if text == "" || len(text) > 32 || len([]rune(text)) > 8 {
+		return "", false
+	}
+	for _, r := range text {
+		if r < 0x80 {
+			return "", false
+		}
+	}
+	return text, true
+}
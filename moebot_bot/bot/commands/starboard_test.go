@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestStarboardHandler_ParseStarboardEmoji(t *testing.T) {
+	checks := []struct {
+		text     string
+		expected string
+		valid    bool
+	}{
+		{"⭐", "⭐", true},
+		{"👍🏽", "👍🏽", true},
+		{"<:moe:123456>", "moe:123456", true},
+		{"<a:dance:789>", "dance:789", true},
+		{"star", "", false},
+		{"", "", false},
+		{"<:moe:>", "", false},
+	}
+	for _, c := range checks {
+		actual, valid := parseStarboardEmoji(c.text)
+		if actual != c.expected || valid != c.valid {
+			t.Errorf("parseStarboardEmoji(%q) = %q, %v, expected %q, %v", c.text, actual, valid, c.expected, c.valid)
+		}
+	}
+}
+
+func TestStarboardHandler_CountStars(t *testing.T) {
+	users := []*discordgo.User{{ID: "1"}, {ID: "2"}, {ID: "3", Bot: true}, {ID: "4"}}
+	if count := countStars(users, "1", false); count != 2 {
+		t.Errorf("Expected the author and bots to be ignored, got %d", count)
+	}
+	if count := countStars(users, "1", true); count != 3 {
+		t.Errorf("Expected the author to count with self stars on, got %d", count)
+	}
+}
+
+func TestStarboardHandler_FormatStarboardCount(t *testing.T) {
+	if actual := formatStarboardCount("⭐", false, 5, "42"); actual != "⭐ **5** <#42>" {
+		t.Errorf("Unexpected unicode count: %q", actual)
+	}
+	if actual := formatStarboardCount("moe:123", false, 5, "42"); actual != "<:moe:123> **5** <#42>" {
+		t.Errorf("Unexpected custom emoji count: %q", actual)
+	}
+	if actual := formatStarboardCount("dance:789", true, 5, "42"); actual != "<a:dance:789> **5** <#42>" {
+		t.Errorf("Unexpected animated emoji count: %q", actual)
+	}
+}
+
+func TestStarboardHandler_LockMessage(t *testing.T) {
+	sh := &StarboardHandler{}
+	unlockFirst := sh.lockMessage("1")
+	// a different message shouldn't have to wait
+	unlockOther := sh.lockMessage("2")
+	unlockOther()
+	unlockFirst()
+	if len(sh.locks.m) != 0 {
+		t.Errorf("Expected every lock to be cleaned up, %d left", len(sh.locks.m))
+	}
+}
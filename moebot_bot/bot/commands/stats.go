@@ -0,0 +1,325 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	activityFlushInterval   = 5 * time.Minute
+	activityCleanupInterval = time.Hour
+	statsDefaultDays        = 7
+	statsTopChannels        = 5
+	statsSlowingChannels    = 3
+	statsQuietChannels      = 5
+	// channels need at least this many messages last period before a drop counts as slowing down
+	statsSlowingMinimum = 20
+)
+
+// From quietest to busiest, used for the text version of the heatmap
+var statsHeatLevels = []string{" ", "░", "▒", "▓", "█"}
+
+/*
+Keeps track of how busy each channel is, hour by hour, and shows mods when the server is busiest and which channels are slowing down.
+Nothing about what was said is kept, just how many messages there were and how many people sent them
+*/
+type StatsCommand struct {
+	buffer struct {
+		sync.Mutex
+		m map[activityKey]*activityBucket
+	}
+	stopCh chan struct{}
+}
+
+type activityKey struct {
+	serverId   int
+	channelUid string
+	hour       time.Time
+}
+
+type activityBucket struct {
+	// only messages that haven't been saved yet
+	messages int
+	// everyone seen this hour, kept until the hour is over so they're only counted once
+	users map[string]bool
+}
+
+func NewStatsCommand() *StatsCommand {
+	sc := &StatsCommand{stopCh: make(chan struct{})}
+	sc.buffer.m = make(map[activityKey]*activityBucket)
+	return sc
+}
+
+/*
+Counts a message towards its channel's activity. Called for every message moebot sees in an enabled server
+*/
+func (sc *StatsCommand) RecordMessage(serverId int, channelUid string, userUid string, sentAt time.Time) {
+	key := activityKey{serverId, channelUid, sentAt.UTC().Truncate(time.Hour)}
+	sc.buffer.Lock()
+	defer sc.buffer.Unlock()
+	bucket, ok := sc.buffer.m[key]
+	if !ok {
+		bucket = &activityBucket{users: make(map[string]bool)}
+		sc.buffer.m[key] = bucket
+	}
+	bucket.messages++
+	bucket.users[userUid] = true
+}
+
+func (sc *StatsCommand) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(activityFlushInterval)
+		defer ticker.Stop()
+		cleanupTicker := time.NewTicker(activityCleanupInterval)
+		defer cleanupTicker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sc.flushBuffer()
+			case <-cleanupTicker.C:
+				db.ChannelActivityDeleteOld()
+			case <-sc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (sc *StatsCommand) Shutdown(session *discordgo.Session) {
+	close(sc.stopCh)
+	sc.flushBuffer()
+}
+
+/*
+Saves everything counted since the last flush. Hours that are over get dropped from the buffer once they're saved.
+The buffer is swapped out first so messages can still be counted while the database is being written to
+*/
+func (sc *StatsCommand) flushBuffer() {
+	currentHour := time.Now().UTC().Truncate(time.Hour)
+	sc.buffer.Lock()
+	flushing := sc.buffer.m
+	sc.buffer.m = make(map[activityKey]*activityBucket)
+	sc.buffer.Unlock()
+
+	var activity []types.ChannelActivity
+	for key, bucket := range flushing {
+		if bucket.messages == 0 {
+			continue
+		}
+		activity = append(activity, types.ChannelActivity{
+			ServerId:     key.serverId,
+			ChannelUid:   key.channelUid,
+			Hour:         key.hour,
+			MessageCount: bucket.messages,
+			UserCount:    len(bucket.users),
+		})
+	}
+	// on failure everything is kept for the next flush
+	saved := len(activity) == 0 || db.ChannelActivityAdd(activity) == nil
+
+	sc.buffer.Lock()
+	defer sc.buffer.Unlock()
+	for key, bucket := range flushing {
+		if saved {
+			bucket.messages = 0
+		}
+		if bucket.messages == 0 && key.hour.Before(currentHour) {
+			continue
+		}
+		// put it back, along with anything counted while we were saving
+		current, ok := sc.buffer.m[key]
+		if !ok {
+			sc.buffer.m[key] = bucket
+			continue
+		}
+		current.messages += bucket.messages
+		for u := range bucket.users {
+			current.users[u] = true
+		}
+	}
+}
+
+func (sc *StatsCommand) Execute(pack *CommPackage) {
+	args := ParseCommand(pack.params, []string{"-days", "-text"})
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	retention := db.ChannelActivityDefaultRetention
+	if server.StatsRetentionDays.Valid {
+		// matches the cleanup, which always keeps at least a day
+		retention = int(server.StatsRetentionDays.Int64)
+		if retention < 1 {
+			retention = 1
+		}
+	}
+	days := statsDefaultDays
+	if daysText, ok := args["-days"]; ok {
+		days, err = strconv.Atoi(daysText)
+		if err != nil || days <= 0 || days > retention {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of days from 1 to "+strconv.Itoa(retention)+
+				", this server only keeps "+strconv.Itoa(retention)+" days of activity.")
+			return
+		}
+	}
+	// anything still in the buffer wouldn't show up otherwise
+	sc.flushBuffer()
+
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	heatmap, err := db.ChannelActivityQueryHeatmap(server.Id, since)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the activity. This is an issue with moebot and not Discord.")
+		return
+	}
+	summaries, err := db.ChannelActivityQuerySummary(server.Id, since, since.AddDate(0, 0, -days))
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the activity. This is an issue with moebot and not Discord.")
+		return
+	}
+	if util.HeatmapMax(heatmap) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There hasn't been any activity in the last "+strconv.Itoa(days)+" days yet.")
+		return
+	}
+
+	title := "Activity for the last " + strconv.Itoa(days) + " days"
+	summary := sc.buildSummary(pack.guild, summaries)
+	if _, ok := args["-text"]; ok {
+		pack.session.ChannelMessageSend(pack.channel.ID, util.MakeStringBold(title)+"\n"+formatHeatmapText(heatmap)+summary)
+		return
+	}
+	accent := util.DefaultCardAccent
+	if c, ok := util.ParseHexColor(server.ProfileAccentColor.String); server.ProfileAccentColor.Valid && ok {
+		accent = c
+	}
+	b, err := util.MakeActivityHeatmap(pack.guild.Name+": "+strings.ToLower(title), heatmap, accent)
+	if err != nil {
+		log.Println("Error making activity heatmap", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, util.MakeStringBold(title)+"\n"+formatHeatmapText(heatmap)+summary)
+		return
+	}
+	_, err = pack.session.ChannelMessageSendComplex(pack.channel.ID, &discordgo.MessageSend{
+		Content: summary,
+		File: &discordgo.File{
+			Name:        "stats.png",
+			ContentType: "image/png",
+			Reader:      bytes.NewReader(b),
+		},
+	})
+	if err != nil {
+		log.Println("Error sending activity heatmap", err)
+	}
+}
+
+func (sc *StatsCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (sc *StatsCommand) GetCommandKeys() []string {
+	return []string{"STATS"}
+}
+
+func (sc *StatsCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s stats [-days <days>] [-text]` - Mod. Shows a heatmap of when the server is busiest along with the busiest "+
+		"channels and the ones slowing down. Defaults to the last %[2]d days, -text shows the heatmap as text instead of an image.",
+		commPrefix, statsDefaultDays)
+}
+
+/*
+Lists the busiest channels, the ones that have dropped off the most since the period before, and any text channels nobody has talked in
+*/
+func (sc *StatsCommand) buildSummary(guild *discordgo.Guild, summaries []types.ChannelActivitySummary) string {
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Busiest channels:"))
+	active := make(map[string]bool)
+	shown := 0
+	for _, s := range summaries {
+		if s.MessageCount == 0 {
+			continue
+		}
+		active[s.ChannelUid] = true
+		if shown < statsTopChannels {
+			message.WriteString("\n<#" + s.ChannelUid + "> - " + strconv.Itoa(s.MessageCount) + " messages (" +
+				formatActivityChange(s.MessageCount, s.PreviousMessages) + "), up to " + strconv.Itoa(s.PeakUsers) + " people an hour")
+			shown++
+		}
+	}
+
+	var slowing []types.ChannelActivitySummary
+	for _, s := range summaries {
+		if s.PreviousMessages >= statsSlowingMinimum && s.MessageCount < s.PreviousMessages {
+			slowing = append(slowing, s)
+		}
+	}
+	sort.Slice(slowing, func(i, j int) bool {
+		return slowing[i].MessageCount*slowing[j].PreviousMessages < slowing[j].MessageCount*slowing[i].PreviousMessages
+	})
+	if len(slowing) > 0 {
+		message.WriteString("\n" + util.MakeStringBold("Slowing down:"))
+		for i := 0; i < len(slowing) && i < statsSlowingChannels; i++ {
+			s := slowing[i]
+			message.WriteString("\n<#" + s.ChannelUid + "> - " + strconv.Itoa(s.MessageCount) + " messages (" +
+				formatActivityChange(s.MessageCount, s.PreviousMessages) + ")")
+		}
+	}
+
+	var quiet []string
+	for _, c := range guild.Channels {
+		if c.Type == discordgo.ChannelTypeGuildText && !active[c.ID] {
+			quiet = append(quiet, "<#"+c.ID+">")
+		}
+	}
+	if len(quiet) > 0 {
+		message.WriteString("\n" + util.MakeStringBold("No messages: "))
+		if len(quiet) > statsQuietChannels {
+			message.WriteString(strings.Join(quiet[:statsQuietChannels], ", ") + " and " + strconv.Itoa(len(quiet)-statsQuietChannels) + " more")
+		} else {
+			message.WriteString(strings.Join(quiet, ", "))
+		}
+	}
+	return message.String()
+}
+
+/*
+Draws the heatmap with block characters for servers (or mods) that would rather not have an image
+*/
+func formatHeatmapText(heatmap [7][24]int) string {
+	max := util.HeatmapMax(heatmap)
+	var text strings.Builder
+	text.WriteString("```\n    0  3  6  9  12 15 18 21\n")
+	for day := 0; day < 7; day++ {
+		text.WriteString(util.HeatmapDayNames[day] + " ")
+		for hour := 0; hour < 24; hour++ {
+			text.WriteString(statsHeatLevels[util.HeatLevel(heatmap[day][hour], max, len(statsHeatLevels))])
+		}
+		text.WriteString("\n")
+	}
+	text.WriteString("Hours in UTC. Busiest hour: " + strconv.Itoa(max) + " messages\n```")
+	return text.String()
+}
+
+/*
+Describes how the count has changed from the previous period as a percentage
+*/
+func formatActivityChange(current int, previous int) string {
+	if previous == 0 {
+		return "new"
+	}
+	change := (current - previous) * 100 / previous
+	if change >= 0 {
+		return "+" + strconv.Itoa(change) + "%"
+	}
+	return strconv.Itoa(change) + "%"
+}
@@ -0,0 +1,24 @@
+package commands
+
+import "testing"
+
+func TestStatsCommand_FormatActivityChange(t *testing.T) {
+	checks := []struct {
+		current  int
+		previous int
+		out      string
+	}{
+		{10, 0, "new"},
+		{0, 0, "new"},
+		{10, 10, "+0%"},
+		{15, 10, "+50%"},
+		{5, 10, "-50%"},
+		{0, 20, "-100%"},
+	}
+	for _, c := range checks {
+		actual := formatActivityChange(c.current, c.previous)
+		if actual != c.out {
+			t.Errorf("formatActivityChange(%d, %d) = %q, expected %q", c.current, c.previous, actual, c.out)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+/*
+Sets up the rank ladder for a server. Each tier has a point threshold, and can optionally hand out (or unlock) a role and congratulate whoever reaches it
+*/
+type TierCommand struct {
+	ComPrefix string
+}
+
+func (tc *TierCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	tiers, err := db.RankTierQueryServer(server.Id)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the tiers. This is an issue with moebot and not Discord.")
+		return
+	}
+	if len(pack.params) == 0 {
+		tc.listTiers(pack, tiers)
+		return
+	}
+
+	args := ParseCommand(pack.params, []string{"-name", "-points", "-role", "-auto", "-message", "-delete"})
+	if name, ok := args["-delete"]; ok {
+		deleted, err := db.RankTierDelete(server.Id, name)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue deleting the tier. This is an issue with moebot and not Discord.")
+		} else if !deleted {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there isn't a tier called `"+name+"`.")
+		} else {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Deleted the tier `"+name+"`.")
+		}
+		return
+	}
+
+	name, ok := args["-name"]
+	if !ok || name == "" {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a tier name with -name.")
+		return
+	}
+	if len(name) > db.RankTierMaxNameLength {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, tier names have a max length of "+db.RankTierMaxNameLengthString)
+		return
+	}
+	tier := types.RankTier{ServerId: server.Id, Name: name, Threshold: -1}
+	for _, t := range tiers {
+		if strings.EqualFold(t.Name, name) {
+			// updating an existing tier, so only change what was given
+			tier = t
+			break
+		}
+	}
+	if pointsText, ok := args["-points"]; ok {
+		tier.Threshold, err = strconv.Atoi(pointsText)
+		if err != nil || tier.Threshold <= 0 {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a number of points greater than 0.")
+			return
+		}
+	}
+	if tier.Threshold < 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Please provide the points needed to reach a new tier with -points.")
+		return
+	}
+	if autoText, ok := args["-auto"]; ok {
+		tier.AutoGrant, err = strconv.ParseBool(autoText)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Please provide either true or false for -auto.")
+			return
+		}
+	}
+	if message, ok := args["-message"]; ok {
+		if strings.EqualFold(message, "none") {
+			tier.Message.Scan(nil)
+		} else if len(message) > db.MaxMessageLength {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, tier messages have a max length of "+db.MaxMessageLengthString)
+			return
+		} else {
+			tier.Message.Scan(message)
+		}
+	}
+	if roleName, ok := args["-role"]; ok {
+		if strings.EqualFold(roleName, "none") {
+			tier.RoleUid.Scan(nil)
+		} else {
+			role := moeDiscord.FindRoleByName(pack.guild.Roles, roleName)
+			if role == nil {
+				pack.session.ChannelMessageSend(pack.channel.ID, "Please provide a valid role and make sure it's the full role name")
+				return
+			}
+			tier.RoleUid.Scan(role.ID)
+		}
+	}
+
+	var claimMessage string
+	if tier.RoleUid.Valid && !tier.AutoGrant {
+		// members have to claim the role themselves, so make sure there's a trigger for them to use
+		role := types.Role{
+			ServerId:   server.Id,
+			RoleUid:    tier.RoleUid.String,
+			Permission: -1,
+		}
+		// the tier name is only used as the trigger if no other role already answers to it
+		trigger := strings.ToLower(tier.Name)
+		_, err := db.RoleQueryTrigger(trigger, server.Id)
+		if err == sql.ErrNoRows && len(trigger) <= db.RoleMaxTriggerLength {
+			role.Trigger.Scan(trigger)
+		} else if err != nil && err != sql.ErrNoRows {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue setting up the tier's role. This is an issue with moebot and not Discord.")
+			return
+		}
+		group, err := uncategorizedGroup(server)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue setting up the tier's role. This is an issue with moebot and not Discord.")
+			return
+		}
+		role.Groups = []int{group.Id}
+		r, err := db.RoleQueryOrInsert(role)
+		if err != nil {
+			pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue setting up the tier's role. This is an issue with moebot and not Discord.")
+			return
+		}
+		if r.Trigger.Valid {
+			claimMessage = " Members can claim the role with `" + tc.ComPrefix + " role " + r.Trigger.String + "` once they reach it."
+		} else {
+			claimMessage = " The role doesn't have a trigger yet, so set one with `" + tc.ComPrefix + " roleset` so members can claim it."
+		}
+	}
+	if db.RankTierInsertOrUpdate(tier) != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue saving the tier. This is an issue with moebot and not Discord.")
+		return
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, "Saved the tier `"+tier.Name+"` at "+strconv.Itoa(tier.Threshold)+" points."+claimMessage)
+}
+
+/*
+Gets the server's uncategorized role group, making it if it isn't there yet
+*/
+func uncategorizedGroup(server types.Server) (types.RoleGroup, error) {
+	group, err := db.RoleGroupQueryName(db.UncategorizedGroup, server.Id)
+	if err != sql.ErrNoRows {
+		return group, err
+	}
+	group = types.RoleGroup{ServerId: server.Id, Name: db.UncategorizedGroup, Type: types.GroupTypeAny}
+	group.Id, err = db.RoleGroupInsertOrUpdate(group, server)
+	return group, err
+}
+
+func (tc *TierCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (tc *TierCommand) GetCommandKeys() []string {
+	return []string{"TIER"}
+}
+
+func (tc *TierCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s tier -name <name> -points <points> [-role <role name|none> -auto <true|false> -message <message|none>]` - Mod. "+
+		"Creates or updates a rank tier. With -auto true the role is given out when the tier is reached, otherwise members can claim it. "+
+		"Use %[2]s in the message to mention the member. `%[1]s tier -delete <name>` deletes a tier and `%[1]s tier` lists them.",
+		commPrefix, types.TierUserSearchText)
+}
+
+func (tc *TierCommand) listTiers(pack *CommPackage, tiers []types.RankTier) {
+	if len(tiers) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "There aren't any tiers on this server yet. Add one with `"+tc.ComPrefix+" tier -name <name> -points <points>`.")
+		return
+	}
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold("Rank tiers:"))
+	for _, t := range tiers {
+		message.WriteString("\n" + util.MakeStringCode(t.Name) + " - " + strconv.Itoa(t.Threshold) + " points")
+		if t.RoleUid.Valid {
+			roleName := t.RoleUid.String
+			if role := moeDiscord.FindRoleById(pack.guild.Roles, t.RoleUid.String); role != nil {
+				roleName = role.Name
+			}
+			if t.AutoGrant {
+				message.WriteString(", gives " + roleName)
+			} else {
+				message.WriteString(", unlocks " + roleName)
+			}
+		}
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
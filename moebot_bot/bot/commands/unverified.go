@@ -0,0 +1,316 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/camd67/moebot/moebot_bot/util/moeDiscord"
+)
+
+const (
+	unverifiedCheckInterval = 10 * time.Minute
+	unverifiedListLimit     = 25
+)
+
+// What should happen to an unverified member on a check
+const (
+	unverifiedWait = iota
+	unverifiedRemind
+	unverifiedKick
+)
+
+/*
+Reminds members that never agreed to the rules, and eventually kicks them. Nothing is stored about who was reminded, instead each member is
+reminded on the first check after their reminder comes due. Anyone whose reminder came due before moebot started may have missed it, so they
+get it late and the same amount of time a normal reminder would have given them before they're kicked
+*/
+type UnverifiedCommand struct {
+	ComPrefix string
+	// these are only touched by the background job. Reminders due after remindedSince have been sent by this run of moebot
+	lastCheck     time.Time
+	remindedSince time.Time
+	// guild ID:user ID -> when they were sent a late reminder
+	lateReminders map[string]time.Time
+	stopCh        chan struct{}
+}
+
+type unverifiedMember struct {
+	member *discordgo.Member
+	joined time.Time
+}
+
+func NewUnverifiedCommand(comPrefix string) *UnverifiedCommand {
+	return &UnverifiedCommand{ComPrefix: comPrefix, stopCh: make(chan struct{})}
+}
+
+func (uc *UnverifiedCommand) Setup(session *discordgo.Session) {
+	uc.lastCheck = time.Now().Add(-unverifiedCheckInterval)
+	uc.remindedSince = uc.lastCheck
+	uc.lateReminders = make(map[string]time.Time)
+	go func() {
+		ticker := time.NewTicker(unverifiedCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				uc.checkUnverified(session)
+			case <-uc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (uc *UnverifiedCommand) Shutdown(session *discordgo.Session) {
+	close(uc.stopCh)
+}
+
+func (uc *UnverifiedCommand) Execute(pack *CommPackage) {
+	server, err := db.ServerQueryOrInsert(pack.guild.ID)
+	if err != nil {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an issue fetching the server. This is an issue with moebot and not Discord.")
+		return
+	}
+	if (!server.RuleAgreement.Valid && !server.CaptchaEnabled) || !server.StarterRole.Valid {
+		pack.session.ChannelMessageSend(pack.channel.ID, "This server needs a RuleAgreement or captcha and a StarterRole before anyone can be unverified. "+
+			"See `"+uc.ComPrefix+" server` to set them.")
+		return
+	}
+	pending, err := getUnverifiedMembers(pack.session, server)
+	if err != nil {
+		log.Println("Error fetching guild members for unverified list", err)
+		pack.session.ChannelMessageSend(pack.channel.ID, "Sorry, there was an error fetching the members of this server. Please try again later.")
+		return
+	}
+	if len(pending) == 0 {
+		pack.session.ChannelMessageSend(pack.channel.ID, "Everyone has agreed to the rules!")
+		return
+	}
+
+	now := time.Now()
+	var message strings.Builder
+	message.WriteString(util.MakeStringBold(strconv.Itoa(len(pending)) + " members haven't agreed to the rules:"))
+	for i := 0; i < len(pending) && i < unverifiedListLimit; i++ {
+		p := pending[i]
+		message.WriteString("\n" + util.UserIdToMention(p.member.User.ID) + " joined " + formatUnverifiedHours(now.Sub(p.joined)) + " ago")
+		if server.UnverifiedKickHours.Valid {
+			kickIn := time.Duration(server.UnverifiedKickHours.Int64)*time.Hour - now.Sub(p.joined)
+			if kickIn > 0 {
+				message.WriteString(", kicked in " + formatUnverifiedHours(kickIn))
+			} else {
+				message.WriteString(", kicked on the next check")
+			}
+		}
+	}
+	if len(pending) > unverifiedListLimit {
+		message.WriteString("\nand " + strconv.Itoa(len(pending)-unverifiedListLimit) + " more")
+	}
+	if !server.UnverifiedRemindHours.Valid && !server.UnverifiedKickHours.Valid {
+		message.WriteString("\nNobody gets reminded or kicked until UnverifiedRemindHours or UnverifiedKickHours is set.")
+	}
+	pack.session.ChannelMessageSend(pack.channel.ID, message.String())
+}
+
+func (uc *UnverifiedCommand) GetPermLevel() types.Permission {
+	return types.PermMod
+}
+
+func (uc *UnverifiedCommand) GetCommandKeys() []string {
+	return []string{"UNVERIFIED"}
+}
+
+func (uc *UnverifiedCommand) GetCommandHelp(commPrefix string) string {
+	return fmt.Sprintf("`%[1]s unverified` - Mod. Lists members still in the starter role that haven't agreed to the rules. Members get a "+
+		"reminder after UnverifiedRemindHours and are kicked after UnverifiedKickHours.", commPrefix)
+}
+
+func (uc *UnverifiedCommand) checkUnverified(session *discordgo.Session) {
+	lastCheck := uc.lastCheck
+	now := time.Now()
+	uc.lastCheck = now
+
+	var guildUids []string
+	session.State.RLock()
+	for _, g := range session.State.Guilds {
+		guildUids = append(guildUids, g.ID)
+	}
+	session.State.RUnlock()
+
+	lateReminders := make(map[string]time.Time)
+	for _, guildUid := range guildUids {
+		server, err := db.ServerQueryOrInsert(guildUid)
+		if err != nil || !server.Enabled || (!server.RuleAgreement.Valid && !server.CaptchaEnabled) || !server.StarterRole.Valid ||
+			(!server.UnverifiedRemindHours.Valid && !server.UnverifiedKickHours.Valid) {
+			continue
+		}
+		guild, err := moeDiscord.GetGuild(guildUid, session)
+		if err != nil {
+			continue
+		}
+		pending, err := getUnverifiedMembers(session, server)
+		if err != nil {
+			log.Println("Error fetching guild members for unverified check", err)
+			continue
+		}
+		var kicked []string
+		for _, p := range pending {
+			key := guildUid + ":" + p.member.User.ID
+			remindedAt, remindedLate := uc.lateReminders[key]
+			if !remindedLate && missedUnverifiedReminder(p.joined, uc.remindedSince, server.UnverifiedRemindHours) {
+				kickAt := lateUnverifiedKick(p.joined, now, server.UnverifiedRemindHours, server.UnverifiedKickHours)
+				uc.remindMember(session, guild, server, p.member.User.ID, kickAt.Sub(now))
+				lateReminders[key] = now
+				continue
+			}
+			action := unverifiedAction(p.joined, lastCheck, now, server.UnverifiedRemindHours, server.UnverifiedKickHours)
+			if remindedLate {
+				if now.Before(lateUnverifiedKick(p.joined, remindedAt, server.UnverifiedRemindHours, server.UnverifiedKickHours)) {
+					action = unverifiedWait
+				}
+				lateReminders[key] = remindedAt
+			}
+			switch action {
+			case unverifiedRemind:
+				kickAt := p.joined.Add(time.Duration(server.UnverifiedKickHours.Int64) * time.Hour)
+				uc.remindMember(session, guild, server, p.member.User.ID, kickAt.Sub(now))
+			case unverifiedKick:
+				if uc.kickMember(session, guild, server, p.member.User.ID) {
+					kicked = append(kicked, util.UserIdToMention(p.member.User.ID))
+					delete(lateReminders, key)
+				}
+			}
+		}
+		if len(kicked) > 0 && server.BotChannel.Valid {
+			session.ChannelMessageSend(server.BotChannel.String, "Kicked "+strconv.Itoa(len(kicked))+" members that didn't agree to the rules within "+
+				strconv.FormatInt(server.UnverifiedKickHours.Int64, 10)+" hours: "+strings.Join(kicked, " "))
+		}
+	}
+	// anyone that isn't pending anymore is forgotten about
+	uc.lateReminders = lateReminders
+}
+
+func (uc *UnverifiedCommand) remindMember(session *discordgo.Session, guild *discordgo.Guild, server types.Server, userUid string,
+	kickIn time.Duration) {
+	dmChannel, err := session.UserChannelCreate(userUid)
+	if err != nil {
+		return
+	}
+	message := "Hi! You joined " + guild.Name + " a while ago but haven't agreed to the rules yet. Have a read through the rules and follow " +
+		"what they say to get access to the rest of the server."
+	if server.UnverifiedKickHours.Valid {
+		message += " If you don't agree to them within the next " + formatUnverifiedHours(kickIn) +
+			" you'll be removed from the server, but you're always welcome to join again."
+	}
+	session.ChannelMessageSend(dmChannel.ID, message)
+}
+
+/*
+Kicks an unverified member, recording it as a case by moebot so it shows up in the mod log like any other kick
+*/
+func (uc *UnverifiedCommand) kickMember(session *discordgo.Session, guild *discordgo.Guild, server types.Server, userUid string) bool {
+	user, err := db.UserQueryOrInsert(userUid)
+	if err != nil {
+		return false
+	}
+	moebotUser, err := db.UserQueryOrInsert(session.State.User.ID)
+	if err != nil {
+		return false
+	}
+	modCase := types.ModCase{
+		ServerId:     server.Id,
+		GuildUid:     guild.ID,
+		Action:       types.ModActionKick,
+		UserId:       user.Id,
+		UserUid:      userUid,
+		ModeratorId:  moebotUser.Id,
+		ModeratorUid: session.State.User.ID,
+	}
+	modCase.Reason.Scan("Didn't agree to the rules within " + strconv.FormatInt(server.UnverifiedKickHours.Int64, 10) + " hours")
+	notifyModAction(session, guild, modCase)
+	if err = session.GuildMemberDeleteWithReason(guild.ID, userUid, modCase.Reason.String); err != nil {
+		log.Println("Error kicking unverified User UID: "+userUid, err)
+		return false
+	}
+	modCase, err = db.ModCaseInsert(modCase)
+	if err != nil {
+		return true
+	}
+	postModCase(session, server, modCase, uc.ComPrefix)
+	return true
+}
+
+/*
+Gets every member still in the server's starter role, oldest join first
+*/
+func getUnverifiedMembers(session *discordgo.Session, server types.Server) ([]unverifiedMember, error) {
+	members, err := moeDiscord.GetAllGuildMembers(session, server.GuildUid)
+	if err != nil {
+		return nil, err
+	}
+	var pending []unverifiedMember
+	for _, m := range members {
+		if m.User.Bot || !util.StrContains(m.Roles, server.StarterRole.String, util.CaseSensitive) {
+			continue
+		}
+		joined, err := m.JoinedAt.Parse()
+		if err != nil {
+			continue
+		}
+		pending = append(pending, unverifiedMember{member: m, joined: joined})
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].joined.Before(pending[j].joined)
+	})
+	return pending, nil
+}
+
+/*
+Decides what to do with a member that joined at the given time. Reminders only go out if they came due since the last check, kicks go out
+whenever they're overdue
+*/
+func unverifiedAction(joined time.Time, lastCheck time.Time, now time.Time, remindHours sql.NullInt64, kickHours sql.NullInt64) int {
+	if kickHours.Valid && !now.Before(joined.Add(time.Duration(kickHours.Int64)*time.Hour)) {
+		return unverifiedKick
+	}
+	if remindHours.Valid {
+		remindAt := joined.Add(time.Duration(remindHours.Int64) * time.Hour)
+		if remindAt.After(lastCheck) && !now.Before(remindAt) {
+			return unverifiedRemind
+		}
+	}
+	return unverifiedWait
+}
+
+/*
+Whether a member's reminder came due before this run of moebot started reminding people, so they may never have got it
+*/
+func missedUnverifiedReminder(joined time.Time, remindedSince time.Time, remindHours sql.NullInt64) bool {
+	return remindHours.Valid && !joined.Add(time.Duration(remindHours.Int64)*time.Hour).After(remindedSince)
+}
+
+/*
+When to kick someone that was sent their reminder late, giving them at least as long as an on time reminder would have
+*/
+func lateUnverifiedKick(joined time.Time, remindedAt time.Time, remindHours sql.NullInt64, kickHours sql.NullInt64) time.Time {
+	kickAt := joined.Add(time.Duration(kickHours.Int64) * time.Hour)
+	if grace := remindedAt.Add(time.Duration(kickHours.Int64-remindHours.Int64) * time.Hour); grace.After(kickAt) {
+		return grace
+	}
+	return kickAt
+}
+
+func formatUnverifiedHours(d time.Duration) string {
+	if d < time.Hour {
+		return strconv.Itoa(int(d.Minutes())) + " minutes"
+	}
+	return strconv.Itoa(int(d.Hours())) + " hours"
+}
@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestUnverifiedCommand_UnverifiedAction(t *testing.T) {
+	joined := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	remind := sql.NullInt64{Int64: 24, Valid: true}
+	kick := sql.NullInt64{Int64: 72, Valid: true}
+	checks := []struct {
+		name      string
+		lastCheck time.Time
+		now       time.Time
+		remind    sql.NullInt64
+		kick      sql.NullInt64
+		expected  int
+	}{
+		{"too early", joined.Add(time.Hour), joined.Add(2 * time.Hour), remind, kick, unverifiedWait},
+		{"reminder due", joined.Add(23 * time.Hour), joined.Add(25 * time.Hour), remind, kick, unverifiedRemind},
+		{"already reminded", joined.Add(25 * time.Hour), joined.Add(26 * time.Hour), remind, kick, unverifiedWait},
+		{"kick due", joined.Add(71 * time.Hour), joined.Add(72 * time.Hour), remind, kick, unverifiedKick},
+		{"kick overdue", joined.Add(100 * time.Hour), joined.Add(101 * time.Hour), remind, kick, unverifiedKick},
+		{"no reminders", joined.Add(23 * time.Hour), joined.Add(25 * time.Hour), sql.NullInt64{}, kick, unverifiedWait},
+		{"no kicks", joined.Add(100 * time.Hour), joined.Add(101 * time.Hour), remind, sql.NullInt64{}, unverifiedWait},
+	}
+	for _, c := range checks {
+		if action := unverifiedAction(joined, c.lastCheck, c.now, c.remind, c.kick); action != c.expected {
+			t.Errorf("%s: expected %d got %d", c.name, c.expected, action)
+		}
+	}
+}
+
+func TestUnverifiedCommand_MissedUnverifiedReminder(t *testing.T) {
+	joined := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	remind := sql.NullInt64{Int64: 24, Valid: true}
+	if !missedUnverifiedReminder(joined, joined.Add(30*time.Hour), remind) {
+		t.Error("Expected a reminder due before moebot started to count as missed")
+	}
+	if missedUnverifiedReminder(joined, joined.Add(20*time.Hour), remind) {
+		t.Error("Expected a reminder due after moebot started to be sent on time")
+	}
+	if missedUnverifiedReminder(joined, joined.Add(30*time.Hour), sql.NullInt64{}) {
+		t.Error("Expected no missed reminder without reminders turned on")
+	}
+}
+
+func TestUnverifiedCommand_LateUnverifiedKick(t *testing.T) {
+	joined := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	remind := sql.NullInt64{Int64: 24, Valid: true}
+	kick := sql.NullInt64{Int64: 72, Valid: true}
+	if actual := lateUnverifiedKick(joined, joined.Add(100*time.Hour), remind, kick); !actual.Equal(joined.Add(148 * time.Hour)) {
+		t.Errorf("Expected the same 48 hours an on time reminder gives, got kicked at %v", actual)
+	}
+	if actual := lateUnverifiedKick(joined, joined.Add(10*time.Hour), remind, kick); !actual.Equal(joined.Add(72 * time.Hour)) {
+		t.Errorf("Expected the normal kick time when it's later, got %v", actual)
+	}
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"strings"
@@ -17,26 +18,56 @@ import (
 )
 
 const (
-	messagePoints        = 5
-	reactionPoints       = 1
-	reactionCooldown     = 45 * time.Second
-	messageCooldown      = 30 * time.Second
+	// Defaults for servers that haven't changed their veteran settings
+	messagePoints    = 5
+	reactionPoints   = 1
+	reactionCooldown = 45 * time.Second
+	messageCooldown  = 30 * time.Second
+
 	veteranBufferSizeMax = 30
+	// Buffered points get saved at least this often, so a quiet server doesn't hold onto them forever
+	veteranFlushInterval = 5 * time.Minute
+
+	// Voice points are handed out once per interval to everyone in a voice channel, and are per minute
+	voiceCheckInterval = time.Minute
+	voiceDailyCap      = 60
 )
 
+// Common bot prefixes that don't earn points unless the server has set its own
+var defaultVeteranIgnoredPrefixes = []string{"->", "~"}
+
 type veteranBuffer struct {
 	sync.RWMutex
 	buffCooldown int
 	m            map[string]int
+	// held for the whole flush so the ticker, a full buffer, and shutdown can't save the same points at once
+	flushLock sync.Mutex
+	stopCh    chan struct{}
+}
+
+/*
+Someone in a voice channel. Deafened members can't earn points, but they still count as company for everyone else
+*/
+type voiceMember struct {
+	userUid  string
+	deafened bool
+}
+
+type tierPromotion struct {
+	userUid string
+	server  types.Server
+	tier    types.RankTier
 }
 
 type VeteranHandler struct {
 	reactionCooldownMap util.SyncCooldownMap
 	messageCooldownMap  util.SyncCooldownMap
 	vBuffer             veteranBuffer
-	comPrefix           string
-	debugChannel        string
-	masterId            string
+	// the last day old voice earnings were cleaned up, only touched by the ticker
+	voiceCleanupDay time.Time
+	comPrefix       string
+	debugChannel    string
+	masterId        string
 }
 
 func NewVeteranHandler(comPrefix string, debugChannel string, masterId string) *VeteranHandler {
@@ -50,6 +81,7 @@ func NewVeteranHandler(comPrefix string, debugChannel string, masterId string) *
 	result.vBuffer = veteranBuffer{
 		m:            make(map[string]int),
 		buffCooldown: veteranBufferSizeMax,
+		stopCh:       make(chan struct{}),
 	}
 	result.comPrefix = comPrefix
 	result.debugChannel = debugChannel
@@ -61,6 +93,33 @@ func (vh *VeteranHandler) EventHandlers() []interface{} {
 	return []interface{}{vh.veteranMessageCreate, vh.veteranReactionAdd}
 }
 
+func (vh *VeteranHandler) Setup(session *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(veteranFlushInterval)
+		defer ticker.Stop()
+		voiceTicker := time.NewTicker(voiceCheckInterval)
+		defer voiceTicker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				vh.flushBuffer(session)
+			case <-voiceTicker.C:
+				vh.awardVoicePoints(session)
+			case <-vh.vBuffer.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+/*
+Saves any points still in the buffer so a restart doesn't lose them
+*/
+func (vh *VeteranHandler) Shutdown(session *discordgo.Session) {
+	close(vh.vBuffer.stopCh)
+	vh.flushBuffer(session)
+}
+
 func (vh *VeteranHandler) veteranMessageCreate(session *discordgo.Session, message *discordgo.MessageCreate) {
 	// todo: Another place where we need to update to prevent network failure due to hokago-tea-time. Perhaps we could group these together somehow?
 	// 1 entry point for all handlers perhaps?
@@ -75,36 +134,21 @@ func (vh *VeteranHandler) veteranMessageCreate(session *discordgo.Session, messa
 	if err != nil {
 		return
 	}
-	if !server.VeteranRank.Valid || !server.VeteranRole.Valid {
-		// they didn't set up any veteran config settings, bail out
+	if !vh.pointsEnabled(server) || !vh.canEarnPoints(session, server, channel.ID, message.Author.ID) {
 		return
 	}
 
-	// ignore some common bot prefixes
-	if !(strings.HasPrefix(message.Content, "->") || strings.HasPrefix(message.Content, "~") || strings.HasPrefix(message.Content, vh.comPrefix)) {
-		changedUsers, err := vh.handleVeteranMessage(message.Author.ID, channel.GuildID)
-		if err != nil {
-			session.ChannelMessageSend(vh.debugChannel, fmt.Sprint("An error occurred when trying to update veteran users ", err))
-		} else {
-			for _, user := range changedUsers {
-				// ignore the master from any rank related stuff. Could ignore them earlier, but this is the main "public" facing point
-				if user.UserUid != vh.masterId {
-					session.ChannelMessageSend(user.SendTo, "Congrats "+util.UserIdToMention(user.UserUid)+" you can become a server veteran! Type `"+
-						vh.comPrefix+" role veteran` In this channel.")
-				}
-			}
-		}
+	if !hasIgnoredPrefix(message.Content, server, vh.comPrefix) {
+		vh.handleVeteranMessage(session, message.Author.ID, channel.GuildID, server)
 	}
-	// need to clear the server buffer here, since we don't have full clear functionality yet
-	db.FlushServerCache()
 }
 
-func (vh *VeteranHandler) handleVeteranMessage(userUid string, guildUid string) (users []types.UserServerRankWrapper, err error) {
+func (vh *VeteranHandler) handleVeteranMessage(session *discordgo.Session, userUid string, guildUid string, server types.Server) {
 	key := buildVeteranBufferKey(userUid, guildUid)
-	if isCooldownReached(key, messageCooldown, &vh.messageCooldownMap) {
-		return vh.handleVeteranChange(userUid, guildUid, messagePoints)
+	cooldown := getVeteranSetting(server.VeteranMessageCooldown, int64(messageCooldown/time.Second))
+	if isCooldownReached(key, time.Duration(cooldown)*time.Second, &vh.messageCooldownMap) {
+		vh.handleVeteranChange(session, userUid, guildUid, int(getVeteranSetting(server.VeteranMessagePoints, messagePoints)))
 	}
-	return
 }
 
 func (vh *VeteranHandler) veteranReactionAdd(session *discordgo.Session, reactionAdd *discordgo.MessageReactionAdd) {
@@ -119,34 +163,129 @@ func (vh *VeteranHandler) veteranReactionAdd(session *discordgo.Session, reactio
 	if err != nil {
 		return
 	}
-	if !server.VeteranRank.Valid || !server.VeteranRole.Valid {
-		// they didn't set up any veteran config settings, bail out
+	if !vh.pointsEnabled(server) || !vh.canEarnPoints(session, server, channel.ID, reactionAdd.UserID) {
 		return
 	}
 
-	changedUsers, err := vh.handleVeteranReaction(reactionAdd.UserID, channel.GuildID)
-	if err != nil {
-		session.ChannelMessageSend(vh.debugChannel, fmt.Sprint("An error occurred when trying to update veteran users ", err))
-	} else {
-		for _, user := range changedUsers {
-			if user.UserUid != vh.masterId {
-				session.ChannelMessageSend(user.SendTo, "Congrats "+util.UserIdToMention(user.UserUid)+" you can become a server veteran! Type `"+
-					vh.comPrefix+" role veteran` In this channel.")
+	vh.handleVeteranReaction(session, reactionAdd.UserID, channel.GuildID, server)
+}
+
+func (vh *VeteranHandler) handleVeteranReaction(session *discordgo.Session, userUid string, guildUid string, server types.Server) {
+	key := buildVeteranBufferKey(userUid, guildUid)
+	cooldown := getVeteranSetting(server.VeteranReactionCooldown, int64(reactionCooldown/time.Second))
+	if isCooldownReached(key, time.Duration(cooldown)*time.Second, &vh.reactionCooldownMap) {
+		vh.handleVeteranChange(session, userUid, guildUid, int(getVeteranSetting(server.VeteranReactionPoints, reactionPoints)))
+	}
+}
+
+/*
+Gives points to everyone that's in a voice channel with at least one other person. AFK channels don't count, and deafened members don't earn
+anything themselves
+*/
+func (vh *VeteranHandler) awardVoicePoints(session *discordgo.Session) {
+	if today := time.Now().UTC().Truncate(24 * time.Hour); !vh.voiceCleanupDay.Equal(today) {
+		if db.VoiceEarningDeleteOld() == nil {
+			vh.voiceCleanupDay = today
+		}
+	}
+	// guild ID -> channel ID -> members. Copied out so the state isn't locked while we talk to the database
+	voiceChannels := make(map[string]map[string][]voiceMember)
+	session.State.RLock()
+	for _, g := range session.State.Guilds {
+		for _, vs := range g.VoiceStates {
+			if vs.ChannelID == "" || vs.ChannelID == g.AfkChannelID {
+				continue
+			}
+			if voiceChannels[g.ID] == nil {
+				voiceChannels[g.ID] = make(map[string][]voiceMember)
+			}
+			voiceChannels[g.ID][vs.ChannelID] = append(voiceChannels[g.ID][vs.ChannelID], voiceMember{vs.UserID, vs.Deaf || vs.SelfDeaf})
+		}
+	}
+	session.State.RUnlock()
+
+	for guildUid, channels := range voiceChannels {
+		server, err := db.ServerQueryOrInsert(guildUid)
+		if err != nil || !server.Enabled {
+			continue
+		}
+		points := int(getVeteranSetting(server.VeteranVoicePoints, 0))
+		if points <= 0 || !vh.pointsEnabled(server) {
+			continue
+		}
+		dailyCap := int(getVeteranSetting(server.VeteranVoiceDailyCap, voiceDailyCap))
+		for channelUid, voiceMembers := range channels {
+			var members []voiceMember
+			for _, m := range voiceMembers {
+				// members moebot has already seen come from the state, so this only goes to discord for ones it hasn't
+				member, err := moeDiscord.GetMember(m.userUid, guildUid, session)
+				if err == nil && member.User != nil && !member.User.Bot {
+					members = append(members, m)
+				}
+			}
+			if len(members) < 2 {
+				// talking to yourself (or a music bot) doesn't count
+				continue
+			}
+			for _, m := range members {
+				if m.deafened || !vh.canEarnPoints(session, server, channelUid, m.userUid) {
+					continue
+				}
+				if earned, err := db.VoiceEarningAdd(server.Id, m.userUid, points, dailyCap); err == nil && earned > 0 {
+					vh.handleVeteranChange(session, m.userUid, guildUid, earned)
+				}
 			}
 		}
 	}
-	db.FlushServerCache()
 }
 
-func (vh *VeteranHandler) handleVeteranReaction(userUid string, guildUid string) (users []types.UserServerRankWrapper, err error) {
-	key := buildVeteranBufferKey(userUid, guildUid)
-	if isCooldownReached(key, reactionCooldown, &vh.reactionCooldownMap) {
-		return vh.handleVeteranChange(userUid, guildUid, reactionPoints)
+/*
+Checks the server's excluded channels and roles to see if the given user can earn points in the given channel
+*/
+func (vh *VeteranHandler) canEarnPoints(session *discordgo.Session, server types.Server, channelUid string, userUid string) bool {
+	if util.StrContains(server.VeteranExcludedChannels, channelUid, util.CaseSensitive) {
+		return false
 	}
-	return
+	if len(server.VeteranExcludedRoles) > 0 {
+		member, err := moeDiscord.GetMember(userUid, server.GuildUid, session)
+		if err != nil {
+			// can't tell what roles they have, so play it safe
+			return false
+		}
+		for _, r := range member.Roles {
+			if util.StrContains(server.VeteranExcludedRoles, r, util.CaseSensitive) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-func (vh *VeteranHandler) handleVeteranChange(userUid string, guildUid string, points int) (users []types.UserServerRankWrapper, err error) {
+func hasIgnoredPrefix(content string, server types.Server, comPrefix string) bool {
+	prefixes := server.VeteranIgnoredPrefixes
+	if prefixes == nil {
+		prefixes = defaultVeteranIgnoredPrefixes
+	}
+	// moebot's own commands never earn points
+	if strings.HasPrefix(content, comPrefix) {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(content, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func getVeteranSetting(setting sql.NullInt64, defaultValue int64) int64 {
+	if setting.Valid {
+		return setting.Int64
+	}
+	return defaultValue
+}
+
+func (vh *VeteranHandler) handleVeteranChange(session *discordgo.Session, userUid string, guildUid string, points int) {
 	vh.vBuffer.Lock()
 	vh.vBuffer.m[buildVeteranBufferKey(userUid, guildUid)] += points
 	vh.vBuffer.buffCooldown--
@@ -155,47 +294,176 @@ func (vh *VeteranHandler) handleVeteranChange(userUid string, guildUid string, p
 
 	// only actually go through and process the veterans that have been buffered if we pass our max
 	if buffCount < 0 {
-		var idsToUpdate []int
-		// we've got to read and write this one unfortunately
+		vh.flushBuffer(session)
+	}
+}
+
+/*
+Saves everything in the buffer and sends out any veteran or tier messages. If saving fails the points go back in the buffer for next time
+*/
+func (vh *VeteranHandler) flushBuffer(session *discordgo.Session) {
+	vh.vBuffer.flushLock.Lock()
+	defer vh.vBuffer.flushLock.Unlock()
+
+	// swap out the map so new points can keep coming in while we talk to the database
+	vh.vBuffer.Lock()
+	pending := vh.vBuffer.m
+	vh.vBuffer.m = make(map[string]int)
+	vh.vBuffer.buffCooldown = veteranBufferSizeMax
+	vh.vBuffer.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	changedUsers, promotions, err := vh.savePoints(pending)
+	if err != nil {
 		vh.vBuffer.Lock()
-		defer vh.vBuffer.Unlock()
-		for key, count := range vh.vBuffer.m {
-			uid, gid := splitVeteranBufferKey(key)
-			server, err := db.ServerQueryOrInsert(gid)
-			if err != nil {
-				log.Println("Error getting server during veteran change", err)
-				return nil, err
-			}
-			user, err := db.UserQueryOrInsert(uid)
-			if err != nil {
-				log.Println("Error getting user during veteran change", err)
-				return nil, err
-			}
-			id, newPoint, messageSent, err := db.UserServerRankUpdateOrInsert(user.Id, server.Id, count)
-			if err != nil {
-				// we had an error, just don't delete the user and their points
-				continue
-			}
-			if !messageSent && server.VeteranRank.Valid && server.BotChannel.Valid && int64(newPoint) >= server.VeteranRank.Int64 {
-				// we haven't had an error so the user was updated
-				users = append(users, types.UserServerRankWrapper{
-					UserUid:   uid,
-					ServerUid: gid,
-					Rank:      newPoint,
-					SendTo:    server.BotChannel.String,
-				})
-				idsToUpdate = append(idsToUpdate, id)
+		for key, count := range pending {
+			vh.vBuffer.m[key] += count
+		}
+		vh.vBuffer.Unlock()
+		session.ChannelMessageSend(vh.debugChannel, fmt.Sprint("An error occurred when trying to update veteran users ", err))
+		return
+	}
+	for _, user := range changedUsers {
+		// ignore the master from any rank related stuff. Could ignore them earlier, but this is the main "public" facing point
+		if user.UserUid != vh.masterId {
+			session.ChannelMessageSend(user.SendTo, "Congrats "+util.UserIdToMention(user.UserUid)+" you can become a server veteran! Type `"+
+				vh.comPrefix+" role veteran` In this channel.")
+		}
+	}
+	vh.announceTierPromotions(session, promotions)
+}
+
+func (vh *VeteranHandler) savePoints(pending map[string]int) (users []types.UserServerRankWrapper, promotions []tierPromotion, err error) {
+	var changes []types.UserServerRankChange
+	// parallel to changes, so we know who each result belongs to
+	var servers []types.Server
+	var userUids []string
+	for key, count := range pending {
+		uid, gid := splitVeteranBufferKey(key)
+		server, err := db.ServerQueryOrInsert(gid)
+		if err != nil {
+			log.Println("Error getting server during veteran change", err)
+			return nil, nil, err
+		}
+		user, err := db.UserQueryOrInsert(uid)
+		if err != nil {
+			log.Println("Error getting user during veteran change", err)
+			return nil, nil, err
+		}
+		changes = append(changes, types.UserServerRankChange{UserId: user.Id, ServerId: server.Id, Points: count})
+		servers = append(servers, server)
+		userUids = append(userUids, uid)
+	}
+	results, err := db.UserServerRankAddPoints(changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var idsToUpdate []int
+	// server ID -> tiers, so each server's tiers are only loaded once per flush
+	serverTiers := make(map[int][]types.RankTier)
+	for i, r := range results {
+		server := servers[i]
+		tiers, ok := serverTiers[server.Id]
+		if !ok {
+			tiers, _ = db.RankTierQueryServer(server.Id)
+			serverTiers[server.Id] = tiers
+		}
+		reached, _ := crossedTiers(tiers, r.Rank-r.Points, r.Rank)
+		for _, t := range reached {
+			promotions = append(promotions, tierPromotion{userUid: userUids[i], server: server, tier: t})
+		}
+		if !r.MessageSent && server.VeteranRank.Valid && server.BotChannel.Valid && int64(r.Rank) >= server.VeteranRank.Int64 {
+			users = append(users, types.UserServerRankWrapper{
+				UserUid:   userUids[i],
+				ServerUid: server.GuildUid,
+				Rank:      r.Rank,
+				SendTo:    server.BotChannel.String,
+			})
+			idsToUpdate = append(idsToUpdate, r.Id)
+		}
+	}
+	if len(idsToUpdate) > 0 {
+		db.UserServerRankSetMessageSent(idsToUpdate)
+	}
+	return users, promotions, nil
+}
+
+/*
+Points are only handed out once a server has set up either the veteran role or some rank tiers
+*/
+func (vh *VeteranHandler) pointsEnabled(server types.Server) bool {
+	if server.VeteranRank.Valid && server.VeteranRole.Valid {
+		return true
+	}
+	tiers, err := db.RankTierQueryServer(server.Id)
+	return err == nil && len(tiers) > 0
+}
+
+/*
+Works out which tiers a user reached and which they dropped below when their points went from oldRank to newRank
+*/
+func crossedTiers(tiers []types.RankTier, oldRank int, newRank int) (reached []types.RankTier, dropped []types.RankTier) {
+	for _, t := range tiers {
+		if oldRank < t.Threshold && newRank >= t.Threshold {
+			reached = append(reached, t)
+		} else if newRank < t.Threshold && oldRank >= t.Threshold {
+			dropped = append(dropped, t)
+		}
+	}
+	return
+}
+
+func (vh *VeteranHandler) announceTierPromotions(session *discordgo.Session, promotions []tierPromotion) {
+	announceTierPromotions(session, vh.comPrefix, vh.masterId, promotions)
+}
+
+/*
+Congratulates everyone that reached a new tier, giving them the tier's role if it's given out automatically. The master is left out
+*/
+func announceTierPromotions(session *discordgo.Session, comPrefix string, masterId string, promotions []tierPromotion) {
+	for _, p := range promotions {
+		if masterId != "" && p.userUid == masterId {
+			continue
+		}
+		var claimMessage string
+		if p.tier.RoleUid.Valid {
+			if p.tier.AutoGrant {
+				err := session.GuildMemberRoleAdd(p.server.GuildUid, p.userUid, p.tier.RoleUid.String)
+				if err != nil {
+					log.Println("Error giving tier role to user "+p.userUid, err)
+				}
+			} else if r, err := db.RoleQueryRoleUid(p.tier.RoleUid.String, p.server.Id); err == nil && r.Trigger.Valid {
+				claimMessage = " Type `" + comPrefix + " role " + r.Trigger.String + "` to claim your role."
 			}
 		}
-		if len(idsToUpdate) > 0 {
-			db.UserServerRankSetMessageSent(idsToUpdate)
+		if !p.server.BotChannel.Valid {
+			continue
+		}
+		mention := util.UserIdToMention(p.userUid)
+		if p.tier.Message.Valid {
+			session.ChannelMessageSend(p.server.BotChannel.String, strings.Replace(p.tier.Message.String, types.TierUserSearchText, mention, -1)+claimMessage)
+		} else {
+			session.ChannelMessageSend(p.server.BotChannel.String, "Congrats "+mention+" you've reached "+util.MakeStringBold(p.tier.Name)+"!"+claimMessage)
+		}
+	}
+}
+
+/*
+Takes away the role of every tier a user dropped below
+*/
+func removeTierRoles(session *discordgo.Session, guildUid string, userUid string, dropped []types.RankTier) {
+	for _, t := range dropped {
+		if !t.RoleUid.Valid {
+			continue
+		}
+		if err := session.GuildMemberRoleRemove(guildUid, userUid, t.RoleUid.String); err != nil {
+			// most likely they already left the server
+			log.Println("Failed to remove tier role "+t.RoleUid.String+" from User UID: "+userUid, err)
 		}
-		// clear the whole map
-		vh.vBuffer.m = make(map[string]int)
-		vh.vBuffer.buffCooldown = veteranBufferSizeMax
 	}
-	db.FlushServerCache()
-	return users, nil
 }
 
 /*
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+func TestVeteranHandler_CrossedTiers(t *testing.T) {
+	tiers := []types.RankTier{{Name: "bronze", Threshold: 100}, {Name: "silver", Threshold: 500}, {Name: "gold", Threshold: 1000}}
+	checks := []struct {
+		oldRank, newRank int
+		reached, dropped []string
+	}{
+		{0, 50, nil, nil},
+		{90, 100, []string{"bronze"}, nil},
+		{50, 1200, []string{"bronze", "silver", "gold"}, nil},
+		{600, 499, nil, []string{"silver"}},
+		{1000, 0, nil, []string{"bronze", "silver", "gold"}},
+		{500, 500, nil, nil},
+	}
+	names := func(tiers []types.RankTier) (n []string) {
+		for _, t := range tiers {
+			n = append(n, t.Name)
+		}
+		return
+	}
+	for _, c := range checks {
+		reached, dropped := crossedTiers(tiers, c.oldRank, c.newRank)
+		if fmt.Sprint(names(reached)) != fmt.Sprint(c.reached) || fmt.Sprint(names(dropped)) != fmt.Sprint(c.dropped) {
+			t.Errorf("%d -> %d: expected reached %v dropped %v, got %v %v", c.oldRank, c.newRank, c.reached, c.dropped, names(reached), names(dropped))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+// Placeholders that can go in welcome and goodbye messages, shown in the server config help
+const welcomePlaceholders = "{user} {username} {server} {membercount} {rules}"
+
+/*
+Sends the server's welcome message to someone that just joined, either in the welcome channel or by PM if there isn't one
+*/
+func SendWelcome(session *discordgo.Session, guild *discordgo.Guild, server types.Server, user *discordgo.User) {
+	if !server.WelcomeMessage.Valid {
+		return
+	}
+	channelUid := server.WelcomeChannel.String
+	if !server.WelcomeChannel.Valid {
+		dmChannel, err := session.UserChannelCreate(user.ID)
+		if err != nil {
+			log.Println("ERROR! Unable to make DM channel with userID ", user.ID)
+			return
+		}
+		channelUid = dmChannel.ID
+	}
+	session.ChannelMessageSendComplex(channelUid, makeWelcomeMessage(server.WelcomeMessage.String, guild, server, user))
+}
+
+/*
+Posts the server's goodbye message for someone that just left. They may not share a server with moebot anymore so this is never sent by PM,
+instead it falls back to the welcome channel
+*/
+func SendGoodbye(session *discordgo.Session, guild *discordgo.Guild, server types.Server, user *discordgo.User) {
+	if !server.GoodbyeMessage.Valid {
+		return
+	}
+	channelUid := server.GoodbyeChannel.String
+	if !server.GoodbyeChannel.Valid {
+		if !server.WelcomeChannel.Valid {
+			return
+		}
+		channelUid = server.WelcomeChannel.String
+	}
+	session.ChannelMessageSendComplex(channelUid, makeWelcomeMessage(server.GoodbyeMessage.String, guild, server, user))
+}
+
+/*
+Fills in a welcome or goodbye message for the given user, as an embed if the server wants one
+*/
+func makeWelcomeMessage(template string, guild *discordgo.Guild, server types.Server, user *discordgo.User) *discordgo.MessageSend {
+	text := formatWelcomeMessage(template, guild, server, user)
+	if !server.WelcomeEmbed {
+		return &discordgo.MessageSend{Content: text}
+	}
+	accent := util.DefaultCardAccent
+	if c, ok := util.ParseHexColor(server.ProfileAccentColor.String); server.ProfileAccentColor.Valid && ok {
+		accent = c
+	}
+	embed := &discordgo.MessageEmbed{
+		Description: text,
+		Color:       int(accent.R)<<16 | int(accent.G)<<8 | int(accent.B),
+	}
+	if user.Avatar != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: discordgo.EndpointUserAvatar(user.ID, user.Avatar)}
+	}
+	return &discordgo.MessageSend{Embed: embed}
+}
+
+/*
+Replaces the placeholders in a welcome or goodbye message. Anything in braces that isn't a placeholder is left alone
+*/
+func formatWelcomeMessage(template string, guild *discordgo.Guild, server types.Server, user *discordgo.User) string {
+	rules := "the rules channel"
+	if server.RulesChannel.Valid {
+		rules = "<#" + server.RulesChannel.String + ">"
+	}
+	return strings.NewReplacer(
+		"{user}", user.Mention(),
+		"{username}", user.Username,
+		"{server}", guild.Name,
+		"{membercount}", strconv.Itoa(guild.MemberCount),
+		"{rules}", rules,
+	).Replace(template)
+}
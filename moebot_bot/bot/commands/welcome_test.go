@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+func TestWelcome_FormatWelcomeMessage(t *testing.T) {
+	guild := &discordgo.Guild{Name: "Moe Land", MemberCount: 42}
+	user := &discordgo.User{ID: "123", Username: "moe"}
+	server := types.Server{}
+
+	actual := formatWelcomeMessage("Hi {user} ({username})! Welcome to {server}, you're member {membercount}. Read {rules} {unknown}", guild, server, user)
+	expected := "Hi <@123> (moe)! Welcome to Moe Land, you're member 42. Read the rules channel {unknown}"
+	if actual != expected {
+		t.Errorf("Unexpected welcome message: %q, expected %q", actual, expected)
+	}
+
+	server.RulesChannel.Scan("456")
+	if actual = formatWelcomeMessage("{rules}{rules}", guild, server, user); actual != "<#456><#456>" {
+		t.Errorf("Expected every rules placeholder to be a channel mention, got %q", actual)
+	}
+}
+
+func TestWelcome_MakeWelcomeMessage(t *testing.T) {
+	guild := &discordgo.Guild{Name: "Moe Land"}
+	user := &discordgo.User{ID: "123", Username: "moe"}
+	server := types.Server{}
+
+	if m := makeWelcomeMessage("Bye {username}", guild, server, user); m.Content != "Bye moe" || m.Embed != nil {
+		t.Errorf("Expected a plain message, got %+v", m)
+	}
+	server.WelcomeEmbed = true
+	m := makeWelcomeMessage("Bye {username}", guild, server, user)
+	if m.Content != "" || m.Embed == nil || m.Embed.Description != "Bye moe" || m.Embed.Color != 0x7289da {
+		t.Errorf("Expected an embed in the default accent, got %+v", m)
+	}
+}
@@ -67,5 +67,6 @@ func main() {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
 
+	bot.ShutdownMoebot(discord)
 	fmt.Println("Exited moebot! Seeya later!")
 }
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/gomono"
+)
+
+const (
+	heatmapCellSize    = 22
+	heatmapCellGap     = 2
+	heatmapLabelWidth  = 52
+	heatmapTitleHeight = 44
+	heatmapHourHeight  = 22
+	heatmapMargin      = 16
+	heatmapWidth       = heatmapMargin*2 + heatmapLabelWidth + 24*heatmapCellSize
+	heatmapHeight      = heatmapTitleHeight + heatmapHourHeight + 7*heatmapCellSize + heatmapMargin*2 + 16
+)
+
+// Sunday first to match how postgres numbers days of the week
+var HeatmapDayNames = [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+/*
+Draws a week of activity as a grid of days by hours, with busier hours closer to the accent colour. Encoded as a PNG
+*/
+func MakeActivityHeatmap(title string, heatmap [7][24]int, accent color.RGBA) ([]byte, error) {
+	fnt, err := truetype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, err
+	}
+	titleFace := truetype.NewFace(fnt, &truetype.Options{Size: 18.0})
+	textFace := truetype.NewFace(fnt, &truetype.Options{Size: 12.0})
+
+	img := image.NewRGBA(image.Rect(0, 0, heatmapWidth, heatmapHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.ZP, draw.Src)
+	drawCardText(img, titleFace, cardTextColor, heatmapMargin, heatmapMargin+18, fitCardText(title, titleFace, heatmapWidth-heatmapMargin*2))
+
+	gridX := heatmapMargin + heatmapLabelWidth
+	gridY := heatmapTitleHeight + heatmapHourHeight
+	for hour := 0; hour < 24; hour += 3 {
+		drawCardText(img, textFace, cardSubtleText, gridX+hour*heatmapCellSize, gridY-6, strconv.Itoa(hour))
+	}
+	max := HeatmapMax(heatmap)
+	for day := 0; day < 7; day++ {
+		y := gridY + day*heatmapCellSize
+		drawCardText(img, textFace, cardSubtleText, heatmapMargin, y+heatmapCellSize-7, HeatmapDayNames[day])
+		for hour := 0; hour < 24; hour++ {
+			x := gridX + hour*heatmapCellSize
+			cell := image.Rect(x, y, x+heatmapCellSize-heatmapCellGap, y+heatmapCellSize-heatmapCellGap)
+			cellColor := blendColor(cardBarColor, accent, float64(HeatLevel(heatmap[day][hour], max, 256))/255)
+			draw.Draw(img, cell, image.NewUniform(cellColor), image.ZP, draw.Src)
+		}
+	}
+	legend := "Hours in UTC. Busiest hour: " + strconv.Itoa(max) + " messages"
+	drawCardText(img, textFace, cardSubtleText, gridX, gridY+7*heatmapCellSize+18, legend)
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+The busiest hour in the heatmap
+*/
+func HeatmapMax(heatmap [7][24]int) (max int) {
+	for _, day := range heatmap {
+		for _, count := range day {
+			if count > max {
+				max = count
+			}
+		}
+	}
+	return
+}
+
+/*
+Splits counts from 0 to max into the given number of levels. Anything above 0 is at least level 1 so quiet hours still show up
+*/
+func HeatLevel(count int, max int, levels int) int {
+	if count <= 0 || max <= 0 || levels <= 1 {
+		return 0
+	}
+	if count >= max {
+		return levels - 1
+	}
+	level := count * (levels - 1) / max
+	if level < 1 {
+		return 1
+	}
+	return level
+}
+
+func blendColor(from color.RGBA, to color.RGBA, amount float64) color.RGBA {
+	mix := func(a uint8, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*amount)
+	}
+	return color.RGBA{mix(from.R, to.R), mix(from.G, to.G), mix(from.B, to.B), 0xff}
+}
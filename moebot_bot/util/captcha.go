@@ -0,0 +1,119 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"math/rand"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	captchaCharWidth  = 34
+	captchaHeight     = 80
+	captchaMargin     = 20
+	captchaNoiseLines = 6
+	captchaNoiseDots  = 400
+	// how far, in pixels, the wave can pull the text around
+	captchaWaveSize = 4.0
+)
+
+// Letters and numbers that are hard to mix up with each other, no 0/O, 1/I/L, 5/S or 2/Z
+var captchaAlphabet = []rune("ABCDEFGHJKMNPQRTUVWXY346789")
+
+/*
+Makes a random captcha answer of the given length
+*/
+func MakeCaptchaText(length int) string {
+	answer := make([]rune, length)
+	for i := range answer {
+		answer[i] = captchaAlphabet[rand.Intn(len(captchaAlphabet))]
+	}
+	return string(answer)
+}
+
+/*
+Draws the answer as wavy, uneven text with lines and dots over it so it's easy for people to read but not for bots. Encoded as a PNG
+*/
+func MakeCaptchaImage(answer string) ([]byte, error) {
+	fnt, err := truetype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, err
+	}
+	faces := []font.Face{
+		truetype.NewFace(fnt, &truetype.Options{Size: 30.0}),
+		truetype.NewFace(fnt, &truetype.Options{Size: 36.0}),
+		truetype.NewFace(fnt, &truetype.Options{Size: 42.0}),
+	}
+	width := captchaMargin*2 + captchaCharWidth*len([]rune(answer))
+
+	text := image.NewRGBA(image.Rect(0, 0, width, captchaHeight))
+	draw.Draw(text, text.Bounds(), image.NewUniform(cardBackground), image.ZP, draw.Src)
+	for i, r := range []rune(answer) {
+		d := &font.Drawer{
+			Dst:  text,
+			Src:  image.NewUniform(randomCaptchaColor()),
+			Face: faces[rand.Intn(len(faces))],
+			Dot:  fixed.P(captchaMargin+i*captchaCharWidth+rand.Intn(7)-3, captchaHeight/2+14+rand.Intn(17)-8),
+		}
+		d.DrawString(string(r))
+	}
+
+	// bend the whole thing with a couple of sine waves so the letters can't just be cut apart
+	img := image.NewRGBA(text.Bounds())
+	xPeriod := 10 + rand.Float64()*10
+	yPeriod := 20 + rand.Float64()*20
+	phase := rand.Float64() * 2 * math.Pi
+	for y := 0; y < captchaHeight; y++ {
+		for x := 0; x < width; x++ {
+			sx := x + int(captchaWaveSize*math.Sin(float64(y)/xPeriod+phase))
+			sy := y + int(captchaWaveSize*math.Sin(float64(x)/yPeriod+phase))
+			if image.Pt(sx, sy).In(text.Bounds()) {
+				img.Set(x, y, text.At(sx, sy))
+			} else {
+				img.Set(x, y, cardBackground)
+			}
+		}
+	}
+
+	for i := 0; i < captchaNoiseLines; i++ {
+		drawCaptchaLine(img, image.Pt(rand.Intn(width), rand.Intn(captchaHeight)), image.Pt(rand.Intn(width), rand.Intn(captchaHeight)),
+			randomCaptchaColor())
+	}
+	for i := 0; i < captchaNoiseDots; i++ {
+		img.Set(rand.Intn(width), rand.Intn(captchaHeight), randomCaptchaColor())
+	}
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+A light colour so it always stands out from the dark background
+*/
+func randomCaptchaColor() color.RGBA {
+	return color.RGBA{uint8(140 + rand.Intn(116)), uint8(140 + rand.Intn(116)), uint8(140 + rand.Intn(116)), 0xff}
+}
+
+func drawCaptchaLine(img draw.Image, from image.Point, to image.Point, lineColor color.RGBA) {
+	steps := int(math.Max(math.Abs(float64(to.X-from.X)), math.Abs(float64(to.Y-from.Y))))
+	if steps == 0 {
+		img.Set(from.X, from.Y, lineColor)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		x := from.X + (to.X-from.X)*i/steps
+		y := from.Y + (to.Y-from.Y)*i/steps
+		img.Set(x, y, lineColor)
+	}
+}
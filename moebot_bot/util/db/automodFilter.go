@@ -0,0 +1,90 @@
+package db
+
+import (
+	"log"
+	"sync"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/lib/pq"
+)
+
+const (
+	automodFilterTable = `CREATE TABLE IF NOT EXISTS automod_filter(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		Filter VARCHAR(10) NOT NULL,
+		Enabled BOOLEAN NOT NULL DEFAULT false,
+		Action VARCHAR(10) NOT NULL,
+		MuteMinutes INTEGER,
+		Threshold INTEGER,
+		Patterns TEXT[] NOT NULL DEFAULT '{}',
+		UNIQUE (ServerId, Filter)
+	)`
+
+	AutomodMaxPatterns       = 100
+	AutomodMaxPatternsString = "100"
+
+	automodFilterQueryServer = `SELECT Id, ServerId, Filter, Enabled, Action, MuteMinutes, Threshold, Patterns FROM automod_filter WHERE ServerId = $1`
+	automodFilterUpsert      = `INSERT INTO automod_filter(ServerId, Filter, Enabled, Action, MuteMinutes, Threshold, Patterns)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, '{}'))
+		ON CONFLICT (ServerId, Filter) DO UPDATE SET Enabled = EXCLUDED.Enabled, Action = EXCLUDED.Action, MuteMinutes = EXCLUDED.MuteMinutes,
+		Threshold = EXCLUDED.Threshold, Patterns = EXCLUDED.Patterns`
+)
+
+var (
+	// automod checks every message, so filters are kept in memory until they change. server ID -> filters
+	automodFilterBuffer = struct {
+		sync.RWMutex
+		m map[int][]types.AutomodFilter
+	}{m: make(map[int][]types.AutomodFilter)}
+)
+
+/*
+Gets every filter set up for a server. The result is shared, so it shouldn't be changed
+*/
+func AutomodFilterQueryServer(serverId int) (filters []types.AutomodFilter, err error) {
+	automodFilterBuffer.RLock()
+	filters, ok := automodFilterBuffer.m[serverId]
+	automodFilterBuffer.RUnlock()
+	if ok {
+		return filters, nil
+	}
+	rows, err := moeDb.Query(automodFilterQueryServer, serverId)
+	if err != nil {
+		log.Println("Error querying for automod filters", err)
+		return
+	}
+	defer rows.Close()
+	filters = []types.AutomodFilter{}
+	for rows.Next() {
+		var f types.AutomodFilter
+		if err = rows.Scan(&f.Id, &f.ServerId, &f.Filter, &f.Enabled, &f.Action, &f.MuteMinutes, &f.Threshold, pq.Array(&f.Patterns)); err != nil {
+			log.Println("Error scanning automod filter", err)
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	automodFilterBuffer.Lock()
+	automodFilterBuffer.m[serverId] = filters
+	automodFilterBuffer.Unlock()
+	return
+}
+
+func AutomodFilterInsertOrUpdate(f types.AutomodFilter) (err error) {
+	_, err = moeDb.Exec(automodFilterUpsert, f.ServerId, f.Filter, f.Enabled, f.Action, f.MuteMinutes, f.Threshold, pq.Array(f.Patterns))
+	if err != nil {
+		log.Println("Error inserting or updating automod filter", err)
+		return
+	}
+	automodFilterBuffer.Lock()
+	delete(automodFilterBuffer.m, f.ServerId)
+	automodFilterBuffer.Unlock()
+	return
+}
+
+func automodFilterCreateTable() {
+	_, err := moeDb.Exec(automodFilterTable)
+	if err != nil {
+		log.Println("Error creating automod filter table", err)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	// Days of activity kept for servers that haven't set their own retention
+	ChannelActivityDefaultRetention = 90
+
+	channelActivityTable = `CREATE TABLE IF NOT EXISTS channel_activity(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		ChannelUid VARCHAR(20) NOT NULL,
+		Hour TIMESTAMP NOT NULL,
+		MessageCount INTEGER NOT NULL DEFAULT 0,
+		UserCount INTEGER NOT NULL DEFAULT 0,
+		UNIQUE (ServerId, ChannelUid, Hour)
+	)`
+
+	// Messages are only ever new ones since the last save, but users are everyone seen in the hour so far so they can't be added up
+	channelActivityUpsert = `INSERT INTO channel_activity(ServerId, ChannelUid, Hour, MessageCount, UserCount) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (ServerId, ChannelUid, Hour) DO UPDATE SET MessageCount = channel_activity.MessageCount + EXCLUDED.MessageCount,
+		UserCount = GREATEST(channel_activity.UserCount, EXCLUDED.UserCount)`
+	channelActivityQueryHeatmap = `SELECT EXTRACT(DOW FROM Hour)::INTEGER, EXTRACT(HOUR FROM Hour)::INTEGER, SUM(MessageCount)::INTEGER
+		FROM channel_activity WHERE ServerId = $1 AND Hour >= $2 GROUP BY 1, 2`
+	channelActivityQuerySummary = `SELECT ChannelUid, SUM(CASE WHEN Hour >= $2 THEN MessageCount ELSE 0 END)::INTEGER AS Messages,
+		SUM(CASE WHEN Hour < $2 THEN MessageCount ELSE 0 END)::INTEGER, MAX(CASE WHEN Hour >= $2 THEN UserCount ELSE 0 END)
+		FROM channel_activity WHERE ServerId = $1 AND Hour >= $3 GROUP BY ChannelUid ORDER BY Messages DESC`
+	channelActivityDeleteOld = `DELETE FROM channel_activity AS ca USING server AS s WHERE s.Id = ca.ServerId
+		AND ca.Hour < $1 - MAKE_INTERVAL(days => GREATEST(1, COALESCE(s.StatsRetentionDays, $2)))`
+)
+
+/*
+Saves many hours of channel activity at once in a single transaction. If anything fails nothing is saved, so the caller can try again later
+*/
+func ChannelActivityAdd(activity []types.ChannelActivity) (err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for channel activity", err)
+		return
+	}
+	for _, a := range activity {
+		if _, err = tx.Exec(channelActivityUpsert, a.ServerId, a.ChannelUid, a.Hour.UTC(), a.MessageCount, a.UserCount); err != nil {
+			log.Println("Error saving channel activity", err)
+			tx.Rollback()
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing channel activity", err)
+	}
+	return
+}
+
+/*
+Gets the messages sent since the given time, added up by day of the week (Sunday first) and hour in UTC
+*/
+func ChannelActivityQueryHeatmap(serverId int, since time.Time) (heatmap [7][24]int, err error) {
+	rows, err := moeDb.Query(channelActivityQueryHeatmap, serverId, since.UTC())
+	if err != nil {
+		log.Println("Error querying for channel activity heatmap", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day, hour, messages int
+		if err = rows.Scan(&day, &hour, &messages); err != nil {
+			log.Println("Error scanning channel activity heatmap", err)
+			return
+		}
+		heatmap[day][hour] = messages
+	}
+	return
+}
+
+/*
+Gets every channel's activity since the given time, busiest first. PreviousMessages covers from previousSince up until since
+*/
+func ChannelActivityQuerySummary(serverId int, since time.Time, previousSince time.Time) (summaries []types.ChannelActivitySummary, err error) {
+	rows, err := moeDb.Query(channelActivityQuerySummary, serverId, since.UTC(), previousSince.UTC())
+	if err != nil {
+		log.Println("Error querying for channel activity summary", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s types.ChannelActivitySummary
+		if err = rows.Scan(&s.ChannelUid, &s.MessageCount, &s.PreviousMessages, &s.PeakUsers); err != nil {
+			log.Println("Error scanning channel activity summary", err)
+			return
+		}
+		summaries = append(summaries, s)
+	}
+	return
+}
+
+/*
+Throws away activity older than each server's retention period
+*/
+func ChannelActivityDeleteOld() (err error) {
+	_, err = moeDb.Exec(channelActivityDeleteOld, time.Now().UTC(), ChannelActivityDefaultRetention)
+	if err != nil {
+		log.Println("Error deleting old channel activity", err)
+	}
+	return
+}
+
+func channelActivityCreateTable() {
+	_, err := moeDb.Exec(channelActivityTable)
+	if err != nil {
+		log.Println("Error creating channel activity table", err)
+	}
+}
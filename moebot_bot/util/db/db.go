@@ -53,6 +53,7 @@ func createTables() {
 	// USER
 	userCreateTable()
 	userServerRankCreateTable()
+	pointEventCreateTable()
 	// ROLE
 	roleGroupCreateTable()
 	roleCreateTable()
@@ -69,8 +70,29 @@ func createTables() {
 	scheduledOperationCreateTable()
 	//CHANNEL ROTATION SCHEDULER
 	channelRotationCreateTable()
+	//POINT DECAY SCHEDULER
+	pointDecayCreateTable()
 	//ROLE GROUP RELATION TABLE
 	groupMembershipCreateTable()
+	// ROLE CONFIRMATION
+	roleConfirmationCreateTable()
+	// RANK TIER
+	rankTierCreateTable()
+	// VOICE EARNING
+	voiceEarningCreateTable()
+	// SHOP
+	shopItemCreateTable()
+	shopPurchaseCreateTable()
+	// CHANNEL ACTIVITY
+	channelActivityCreateTable()
+	// MODERATION
+	modCaseCreateTable()
+	automodFilterCreateTable()
+	// STARBOARD
+	starboardEntryCreateTable()
+	// LOCKDOWN
+	channelLockdownCreateTable()
+	slowmodeTimerCreateTable()
 }
 
 /*
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	channelLockdownTable = `CREATE TABLE IF NOT EXISTS channel_lockdown(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		ChannelUid VARCHAR(20) NOT NULL UNIQUE,
+		PreviousAllow BIGINT NOT NULL,
+		PreviousDeny BIGINT NOT NULL
+	)`
+
+	channelLockdownSelect       = `SELECT Id, ServerId, ChannelUid, PreviousAllow, PreviousDeny FROM channel_lockdown `
+	channelLockdownQueryChannel = channelLockdownSelect + `WHERE ChannelUid = $1`
+	channelLockdownQueryServer  = channelLockdownSelect + `WHERE ServerId = $1`
+	channelLockdownInsert       = `INSERT INTO channel_lockdown(ServerId, ChannelUid, PreviousAllow, PreviousDeny) VALUES ($1, $2, $3, $4) RETURNING Id`
+	channelLockdownDelete       = `DELETE FROM channel_lockdown WHERE Id = $1`
+
+	slowmodeTimerTable = `CREATE TABLE IF NOT EXISTS slowmode_timer(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		ChannelUid VARCHAR(20) NOT NULL UNIQUE,
+		PreviousSeconds INTEGER NOT NULL,
+		ExpiresAt TIMESTAMP NOT NULL
+	)`
+
+	// a second timed change on the same channel only pushes back the expiry, so it still goes back to what it was before the first
+	slowmodeTimerUpsert = `INSERT INTO slowmode_timer(ServerId, ChannelUid, PreviousSeconds, ExpiresAt) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (ChannelUid) DO UPDATE SET ExpiresAt = EXCLUDED.ExpiresAt`
+	slowmodeTimerQueryExpired = `SELECT Id, ServerId, ChannelUid, PreviousSeconds, ExpiresAt FROM slowmode_timer WHERE ExpiresAt < $1`
+	slowmodeTimerDelete       = `DELETE FROM slowmode_timer WHERE ChannelUid = $1`
+)
+
+/*
+Gets the lockdown on a channel. Returns sql.ErrNoRows if it isn't locked
+*/
+func ChannelLockdownQueryChannel(channelUid string) (l types.ChannelLockdown, err error) {
+	err = moeDb.QueryRow(channelLockdownQueryChannel, channelUid).Scan(&l.Id, &l.ServerId, &l.ChannelUid, &l.PreviousAllow, &l.PreviousDeny)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for channel lockdown", err)
+	}
+	return
+}
+
+/*
+Gets every locked channel in a server
+*/
+func ChannelLockdownQueryServer(serverId int) (lockdowns []types.ChannelLockdown, err error) {
+	rows, err := moeDb.Query(channelLockdownQueryServer, serverId)
+	if err != nil {
+		log.Println("Error querying for server lockdowns", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l types.ChannelLockdown
+		if err = rows.Scan(&l.Id, &l.ServerId, &l.ChannelUid, &l.PreviousAllow, &l.PreviousDeny); err != nil {
+			log.Println("Error scanning channel lockdown", err)
+			return nil, err
+		}
+		lockdowns = append(lockdowns, l)
+	}
+	return lockdowns, rows.Err()
+}
+
+func ChannelLockdownInsert(l types.ChannelLockdown) (types.ChannelLockdown, error) {
+	err := moeDb.QueryRow(channelLockdownInsert, l.ServerId, l.ChannelUid, l.PreviousAllow, l.PreviousDeny).Scan(&l.Id)
+	if err != nil {
+		log.Println("Error inserting channel lockdown", err)
+	}
+	return l, err
+}
+
+func ChannelLockdownDelete(id int) (err error) {
+	if _, err = moeDb.Exec(channelLockdownDelete, id); err != nil {
+		log.Println("Error deleting channel lockdown", err)
+	}
+	return
+}
+
+func SlowmodeTimerUpsert(t types.SlowmodeTimer) (err error) {
+	if _, err = moeDb.Exec(slowmodeTimerUpsert, t.ServerId, t.ChannelUid, t.PreviousSeconds, t.ExpiresAt.UTC()); err != nil {
+		log.Println("Error upserting slowmode timer", err)
+	}
+	return
+}
+
+/*
+Gets every timed slowmode that has run out but hasn't been undone yet
+*/
+func SlowmodeTimerQueryExpired() (timers []types.SlowmodeTimer, err error) {
+	rows, err := moeDb.Query(slowmodeTimerQueryExpired, time.Now().UTC())
+	if err != nil {
+		log.Println("Error querying for expired slowmode timers", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t types.SlowmodeTimer
+		if err = rows.Scan(&t.Id, &t.ServerId, &t.ChannelUid, &t.PreviousSeconds, &t.ExpiresAt); err != nil {
+			log.Println("Error scanning slowmode timer", err)
+			return nil, err
+		}
+		timers = append(timers, t)
+	}
+	return timers, rows.Err()
+}
+
+func SlowmodeTimerDelete(channelUid string) (err error) {
+	if _, err = moeDb.Exec(slowmodeTimerDelete, channelUid); err != nil {
+		log.Println("Error deleting slowmode timer", err)
+	}
+	return
+}
+
+func channelLockdownCreateTable() {
+	_, err := moeDb.Exec(channelLockdownTable)
+	if err != nil {
+		log.Println("Error creating channel lockdown table", err)
+	}
+}
+
+func slowmodeTimerCreateTable() {
+	_, err := moeDb.Exec(slowmodeTimerTable)
+	if err != nil {
+		log.Println("Error creating slowmode timer table", err)
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/lib/pq"
+)
+
+const (
+	modCaseTable = `CREATE TABLE IF NOT EXISTS mod_case(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		CaseNumber INTEGER NOT NULL,
+		Action VARCHAR(10) NOT NULL,
+		UserId INTEGER NOT NULL REFERENCES user_profile(Id) ON DELETE CASCADE,
+		ModeratorId INTEGER NOT NULL REFERENCES user_profile(Id) ON DELETE CASCADE,
+		Reason VARCHAR(500),
+		DurationMinutes INTEGER,
+		ExpiresAt TIMESTAMP,
+		Resolved BOOLEAN NOT NULL DEFAULT false,
+		CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		LogChannelUid VARCHAR(20),
+		LogMessageUid VARCHAR(20),
+		UNIQUE (ServerId, CaseNumber)
+	)`
+
+	ModCaseMaxReasonLength       = 500
+	ModCaseMaxReasonLengthString = "500"
+
+	// Locking the server keeps two cases from being given the same number
+	modCaseLockServer = `SELECT Id FROM server WHERE Id = $1 FOR UPDATE`
+	modCaseInsert     = `INSERT INTO mod_case(ServerId, CaseNumber, Action, UserId, ModeratorId, Reason, DurationMinutes, ExpiresAt, CreatedAt)
+		VALUES ($1, (SELECT COALESCE(MAX(CaseNumber), 0) + 1 FROM mod_case WHERE ServerId = $1), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING Id, CaseNumber`
+
+	modCaseSelect = `SELECT mc.Id, mc.ServerId, s.GuildUid, mc.CaseNumber, mc.Action, mc.UserId, u.UserUid, mc.ModeratorId, m.UserUid, mc.Reason,
+		mc.DurationMinutes, mc.ExpiresAt, mc.Resolved, mc.CreatedAt, mc.LogChannelUid, mc.LogMessageUid FROM mod_case AS mc
+		JOIN server AS s ON s.Id = mc.ServerId
+		JOIN user_profile AS u ON u.Id = mc.UserId
+		JOIN user_profile AS m ON m.Id = mc.ModeratorId `
+	modCaseQuery        = modCaseSelect + `WHERE mc.ServerId = $1 AND mc.CaseNumber = $2`
+	modCaseQueryUser    = modCaseSelect + `WHERE mc.ServerId = $1 AND mc.UserId = $2 ORDER BY mc.CaseNumber DESC LIMIT $3`
+	modCaseQueryExpired = modCaseSelect + `WHERE mc.ExpiresAt < $1 AND NOT mc.Resolved`
+
+	modCaseSetReason     = `UPDATE mod_case SET Reason = $3 WHERE ServerId = $1 AND CaseNumber = $2`
+	modCaseSetLogMessage = `UPDATE mod_case SET LogChannelUid = $2, LogMessageUid = $3 WHERE Id = $1`
+	modCaseResolve       = `UPDATE mod_case SET Resolved = true WHERE Id = $1`
+	modCaseResolveUser   = `UPDATE mod_case SET Resolved = true WHERE ServerId = $1 AND UserId = $2 AND Action = ANY ($3) AND NOT Resolved`
+)
+
+/*
+Records a new case, filling in its ID, case number and when it was created
+*/
+func ModCaseInsert(c types.ModCase) (result types.ModCase, err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for mod case", err)
+		return
+	}
+	if _, err = tx.Exec(modCaseLockServer, c.ServerId); err != nil {
+		log.Println("Error locking server for mod case", err)
+		tx.Rollback()
+		return
+	}
+	c.CreatedAt = time.Now().UTC()
+	expires := pq.NullTime{Time: c.ExpiresAt.UTC(), Valid: !c.ExpiresAt.IsZero()}
+	err = tx.QueryRow(modCaseInsert, c.ServerId, c.Action, c.UserId, c.ModeratorId, c.Reason, c.DurationMinutes, expires, c.CreatedAt).
+		Scan(&c.Id, &c.CaseNumber)
+	if err != nil {
+		log.Println("Error inserting mod case", err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing mod case", err)
+		return
+	}
+	return c, nil
+}
+
+/*
+Gets a case by its number in the server. Returns sql.ErrNoRows if there isn't one
+*/
+func ModCaseQuery(serverId int, caseNumber int) (c types.ModCase, err error) {
+	if err = modCaseScan(moeDb.QueryRow(modCaseQuery, serverId, caseNumber), &c); err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for mod case", err)
+	}
+	return
+}
+
+/*
+Gets the latest cases against a user, newest first
+*/
+func ModCaseQueryUser(serverId int, userId int, limit int) (cases []types.ModCase, err error) {
+	return modCaseQueryMany(modCaseQueryUser, serverId, userId, limit)
+}
+
+/*
+Gets every timed mute and tempban that has run out but hasn't been lifted yet
+*/
+func ModCaseQueryExpired() (cases []types.ModCase, err error) {
+	return modCaseQueryMany(modCaseQueryExpired, time.Now().UTC())
+}
+
+/*
+Changes a case's reason, returning false if there's no case with that number
+*/
+func ModCaseSetReason(serverId int, caseNumber int, reason sql.NullString) (updated bool, err error) {
+	result, err := moeDb.Exec(modCaseSetReason, serverId, caseNumber, reason)
+	if err != nil {
+		log.Println("Error updating mod case reason", err)
+		return
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+func ModCaseSetLogMessage(caseId int, channelUid string, messageUid string) (err error) {
+	_, err = moeDb.Exec(modCaseSetLogMessage, caseId, channelUid, messageUid)
+	if err != nil {
+		log.Println("Error updating mod case log message", err)
+	}
+	return
+}
+
+func ModCaseResolve(caseId int) (err error) {
+	_, err = moeDb.Exec(modCaseResolve, caseId)
+	if err != nil {
+		log.Println("Error resolving mod case", err)
+	}
+	return
+}
+
+/*
+Marks any of the user's cases with the given actions as lifted, so they don't get lifted again once they run out
+*/
+func ModCaseResolveUser(serverId int, userId int, actions []string) (err error) {
+	_, err = moeDb.Exec(modCaseResolveUser, serverId, userId, pq.Array(actions))
+	if err != nil {
+		log.Println("Error resolving mod cases for user", err)
+	}
+	return
+}
+
+func modCaseQueryMany(query string, args ...interface{}) (cases []types.ModCase, err error) {
+	rows, err := moeDb.Query(query, args...)
+	if err != nil {
+		log.Println("Error querying for mod cases", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c types.ModCase
+		if err = modCaseScan(rows, &c); err != nil {
+			log.Println("Error scanning mod case", err)
+			return
+		}
+		cases = append(cases, c)
+	}
+	return
+}
+
+func modCaseScan(row scanner, c *types.ModCase) error {
+	var expires pq.NullTime
+	err := row.Scan(&c.Id, &c.ServerId, &c.GuildUid, &c.CaseNumber, &c.Action, &c.UserId, &c.UserUid, &c.ModeratorId, &c.ModeratorUid, &c.Reason,
+		&c.DurationMinutes, &expires, &c.Resolved, &c.CreatedAt, &c.LogChannelUid, &c.LogMessageUid)
+	if expires.Valid {
+		c.ExpiresAt = expires.Time
+	}
+	return err
+}
+
+func modCaseCreateTable() {
+	_, err := moeDb.Exec(modCaseTable)
+	if err != nil {
+		log.Println("Error creating mod case table", err)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"log"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	pointDecayTable = `CREATE TABLE IF NOT EXISTS point_decay(
+		operation_id INTEGER NOT NULL PRIMARY KEY REFERENCES scheduled_operation(id) ON DELETE CASCADE,
+		amount INTEGER NOT NULL,
+		is_percent BOOLEAN NOT NULL DEFAULT false,
+		inactive_days INTEGER NOT NULL,
+		remove_tier_roles BOOLEAN NOT NULL DEFAULT false
+	)`
+
+	pointDecayQuery = `SELECT point_decay.operation_id, point_decay.amount, point_decay.is_percent, point_decay.inactive_days, point_decay.remove_tier_roles,
+							scheduled_operation.server_id
+							FROM point_decay
+							INNER JOIN scheduled_operation ON scheduled_operation.id = point_decay.operation_id
+							WHERE operation_id = $1`
+
+	pointDecayInsert = `INSERT INTO point_decay (operation_id, amount, is_percent, inactive_days, remove_tier_roles) VALUES($1, $2, $3, $4, $5)`
+)
+
+func pointDecayCreateTable() {
+	_, err := moeDb.Exec(pointDecayTable)
+	if err != nil {
+		log.Println("Error creating point decay table", err)
+	}
+}
+
+func PointDecayQuery(operationID int64) (*types.PointDecay, error) {
+	pd := &types.PointDecay{}
+	row := moeDb.QueryRow(pointDecayQuery, operationID)
+	if e := row.Scan(&pd.ID, &pd.Amount, &pd.IsPercent, &pd.InactiveDays, &pd.RemoveTierRoles, &pd.ServerID); e != nil {
+		return nil, e
+	}
+	pd.Type = SchedulerPointDecay
+	return pd, nil
+}
+
+func PointDecayAdd(serverID int, decay types.PointDecay, interval string) error {
+	operation, err := scheduledOperationInsertNew(serverID, SchedulerPointDecay, interval)
+	if err != nil {
+		return err
+	}
+	_, err = moeDb.Exec(pointDecayInsert, operation.ID, decay.Amount, decay.IsPercent, decay.InactiveDays, decay.RemoveTierRoles)
+	if err != nil {
+		log.Println("Error inserting point decay", err)
+	}
+	return err
+}
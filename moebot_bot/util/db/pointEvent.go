@@ -0,0 +1,154 @@
+package db
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	pointEventTable = `CREATE TABLE IF NOT EXISTS point_event(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		UserId INTEGER NOT NULL REFERENCES user_profile(Id) ON DELETE CASCADE,
+		Points INTEGER NOT NULL,
+		CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		Source VARCHAR(10) NOT NULL DEFAULT 'activity',
+		ModeratorId INTEGER REFERENCES user_profile(Id) ON DELETE SET NULL,
+		Reason VARCHAR(500)
+	)`
+
+	pointEventIndex = `CREATE INDEX IF NOT EXISTS point_event_server_created ON point_event(ServerId, CreatedAt)`
+
+	pointEventInsert       = `INSERT INTO point_event(ServerId, UserId, Points, CreatedAt) VALUES ($1, $2, $3, $4)`
+	pointEventInsertManual = `INSERT INTO point_event(ServerId, UserId, Points, CreatedAt, Source, ModeratorId, Reason) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	// Activity earns points in lots of tiny events, so those get added up per day. Everything else is shown one by one
+	pointEventQueryHistory = `SELECT CreatedAt, Source, Points, ModeratorUid, Reason, COUNT(*) OVER () AS Total FROM (
+			SELECT DATE_TRUNC('day', CreatedAt) AS CreatedAt, Source, SUM(Points) AS Points, NULL AS ModeratorUid, NULL AS Reason
+			FROM point_event WHERE ServerId = $1 AND UserId = $2 AND Source = 'activity'
+			GROUP BY DATE_TRUNC('day', CreatedAt), Source
+			UNION ALL
+			SELECT pe.CreatedAt, pe.Source, pe.Points, up.UserUid AS ModeratorUid, pe.Reason
+			FROM point_event AS pe LEFT JOIN user_profile AS up ON up.Id = pe.ModeratorId
+			WHERE pe.ServerId = $1 AND pe.UserId = $2 AND pe.Source <> 'activity'
+		) AS history ORDER BY CreatedAt DESC LIMIT $3 OFFSET $4`
+
+	// Every leaderboard query is built from one of these, which need to select UserUid and Points for everyone that should be on the board
+	leaderboardAllTime = `SELECT up.UserUid, usr.Rank AS Points FROM user_server_rank AS usr
+		JOIN user_profile AS up ON up.Id = usr.UserId
+		WHERE usr.ServerId = $1 AND NOT usr.LeaderboardOptOut AND usr.Rank > 0`
+	// Only points that were earned count here, points a mod gave or that decayed away would skew who was most active
+	leaderboardSince = `SELECT up.UserUid, SUM(pe.Points) AS Points FROM point_event AS pe
+		JOIN user_profile AS up ON up.Id = pe.UserId
+		LEFT JOIN user_server_rank AS usr ON usr.ServerId = pe.ServerId AND usr.UserId = pe.UserId
+		WHERE pe.ServerId = $1 AND pe.CreatedAt >= $2 AND pe.Source = 'activity' AND NOT COALESCE(usr.LeaderboardOptOut, false)
+		GROUP BY up.UserUid HAVING SUM(pe.Points) > 0`
+)
+
+var (
+	pointEventUpdateTable = []string{
+		`ALTER TABLE point_event ADD COLUMN IF NOT EXISTS Source VARCHAR(10) NOT NULL DEFAULT 'activity'`,
+		`ALTER TABLE point_event ADD COLUMN IF NOT EXISTS ModeratorId INTEGER REFERENCES user_profile(Id) ON DELETE SET NULL`,
+		`ALTER TABLE point_event ADD COLUMN IF NOT EXISTS Reason VARCHAR(500)`,
+	}
+)
+
+/*
+Records points that were given to a user, so that points can be looked at over a period of time instead of just the running total
+*/
+func PointEventInsert(userId int, serverId int, points int) (err error) {
+	_, err = moeDb.Exec(pointEventInsert, serverId, userId, points, time.Now().UTC())
+	if err != nil {
+		log.Println("Error inserting point event", err)
+	}
+	return
+}
+
+/*
+Gets one page of a user's point history in a server, newest first, along with the total number of entries
+*/
+func PointEventQueryHistory(userId int, serverId int, limit int, offset int) (events []types.PointEvent, total int, err error) {
+	rows, err := moeDb.Query(pointEventQueryHistory, serverId, userId, limit, offset)
+	if err != nil {
+		log.Println("Error querying for point history", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e types.PointEvent
+		if err = rows.Scan(&e.CreatedAt, &e.Source, &e.Points, &e.ModeratorUid, &e.Reason, &total); err != nil {
+			log.Println("Error scanning point history", err)
+			return
+		}
+		events = append(events, e)
+	}
+	return
+}
+
+/*
+Gets one page of a server's leaderboard, along with the total number of users on the board.
+A zero since gives the all time leaderboard, otherwise only points earned after since are counted.
+*/
+func PointEventLeaderboard(serverId int, since time.Time, limit int, offset int) (entries []types.LeaderboardEntry, total int, err error) {
+	query, args := buildLeaderboardQuery(serverId, since)
+	query += ` ORDER BY Position, UserUid LIMIT $` + strconv.Itoa(len(args)+1) + ` OFFSET $` + strconv.Itoa(len(args)+2)
+	rows, err := moeDb.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		log.Println("Error querying for leaderboard", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e types.LeaderboardEntry
+		if err = rows.Scan(&e.UserUid, &e.Points, &e.Position, &total); err != nil {
+			log.Println("Error scanning leaderboard entry", err)
+			return
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+/*
+Gets where a single user is on a server's leaderboard. Returns sql.ErrNoRows if they aren't on it
+*/
+func PointEventLeaderboardPosition(serverId int, since time.Time, userUid string) (e types.LeaderboardEntry, total int, err error) {
+	query, args := buildLeaderboardQuery(serverId, since)
+	query = `SELECT * FROM (` + query + `) AS ranked WHERE UserUid = $` + strconv.Itoa(len(args)+1)
+	err = moeDb.QueryRow(query, append(args, userUid)...).Scan(&e.UserUid, &e.Points, &e.Position, &total)
+	return
+}
+
+func buildLeaderboardQuery(serverId int, since time.Time) (query string, args []interface{}) {
+	base := leaderboardAllTime
+	args = []interface{}{serverId}
+	if !since.IsZero() {
+		base = leaderboardSince
+		args = append(args, since.UTC())
+	}
+	query = `SELECT UserUid, Points, RANK() OVER (ORDER BY Points DESC) AS Position, COUNT(*) OVER () AS Total FROM (` + base + `) AS board`
+	return
+}
+
+func pointEventCreateTable() {
+	_, err := moeDb.Exec(pointEventTable)
+	if err != nil {
+		log.Println("Error creating point event table", err)
+		return
+	}
+	for _, alter := range pointEventUpdateTable {
+		_, err = moeDb.Exec(alter)
+		if err != nil {
+			log.Println("Error alterting point event table", err)
+			return
+		}
+	}
+	_, err = moeDb.Exec(pointEventIndex)
+	if err != nil {
+		log.Println("Error creating point event index", err)
+		return
+	}
+}
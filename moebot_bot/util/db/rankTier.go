@@ -0,0 +1,114 @@
+package db
+
+import (
+	"log"
+	"sync"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	rankTierTable = `CREATE TABLE IF NOT EXISTS rank_tier(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		Name VARCHAR(100) NOT NULL,
+		Threshold INTEGER NOT NULL,
+		RoleUid VARCHAR(20),
+		AutoGrant BOOLEAN NOT NULL DEFAULT false,
+		Message VARCHAR(1900),
+		UNIQUE (ServerId, Name)
+	)`
+
+	RankTierMaxNameLength       = 100
+	RankTierMaxNameLengthString = "100"
+
+	rankTierQueryServer = `SELECT Id, ServerId, Name, Threshold, RoleUid, AutoGrant, Message FROM rank_tier WHERE ServerId = $1 ORDER BY Threshold, Name`
+	rankTierUpsert      = `INSERT INTO rank_tier(ServerId, Name, Threshold, RoleUid, AutoGrant, Message) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (ServerId, Name) DO UPDATE SET Threshold = EXCLUDED.Threshold, RoleUid = EXCLUDED.RoleUid, AutoGrant = EXCLUDED.AutoGrant,
+		Message = EXCLUDED.Message`
+	rankTierDelete = `DELETE FROM rank_tier WHERE ServerId = $1 AND UPPER(Name) = UPPER($2)`
+)
+
+var (
+	// Tiers are checked on every message, reaction, and voice tick so they're kept in memory like servers are.
+	// Server ID -> tiers, cleared for a server whenever its tiers change
+	rankTierMemoryBuffer = struct {
+		sync.RWMutex
+		m map[int][]types.RankTier
+	}{m: make(map[int][]types.RankTier)}
+)
+
+/*
+Gets all the tiers for a server, lowest threshold first
+*/
+func RankTierQueryServer(serverId int) (tiers []types.RankTier, err error) {
+	rankTierMemoryBuffer.RLock()
+	if memTiers, ok := rankTierMemoryBuffer.m[serverId]; ok {
+		rankTierMemoryBuffer.RUnlock()
+		// copied so callers can't change what's in the buffer
+		return append([]types.RankTier(nil), memTiers...), nil
+	}
+	rankTierMemoryBuffer.RUnlock()
+	rows, err := moeDb.Query(rankTierQueryServer, serverId)
+	if err != nil {
+		log.Println("Error querying for rank tiers", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t types.RankTier
+		if err = rows.Scan(&t.Id, &t.ServerId, &t.Name, &t.Threshold, &t.RoleUid, &t.AutoGrant, &t.Message); err != nil {
+			log.Println("Error scanning from rank tier table", err)
+			return
+		}
+		tiers = append(tiers, t)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("Error reading rank tier rows", err)
+		return
+	}
+	rankTierMemoryBuffer.Lock()
+	rankTierMemoryBuffer.m[serverId] = append([]types.RankTier(nil), tiers...)
+	rankTierMemoryBuffer.Unlock()
+	return
+}
+
+func RankTierInsertOrUpdate(t types.RankTier) (err error) {
+	_, err = moeDb.Exec(rankTierUpsert, t.ServerId, t.Name, t.Threshold, t.RoleUid, t.AutoGrant, t.Message)
+	rankTierForget(t.ServerId)
+	if err != nil {
+		log.Println("Error inserting or updating rank tier", err)
+	}
+	return
+}
+
+/*
+Deletes the tier with the given name, returning false if there wasn't one to delete
+*/
+func RankTierDelete(serverId int, name string) (deleted bool, err error) {
+	result, err := moeDb.Exec(rankTierDelete, serverId, name)
+	rankTierForget(serverId)
+	if err != nil {
+		log.Println("Error deleting rank tier", err)
+		return
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+/*
+Drops a server's tiers from memory so the next lookup reads them fresh
+*/
+func rankTierForget(serverId int) {
+	rankTierMemoryBuffer.Lock()
+	delete(rankTierMemoryBuffer.m, serverId)
+	rankTierMemoryBuffer.Unlock()
+}
+
+func rankTierCreateTable() {
+	_, err := moeDb.Exec(rankTierTable)
+	if err != nil {
+		log.Println("Error creating rank tier table", err)
+		return
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/lib/pq"
 
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
@@ -20,24 +21,26 @@ const (
 		Permission SMALLINT NOT NULL DEFAULT 2,
 		ConfirmationMessage VARCHAR CONSTRAINT role_confirmation_message_length CHECK (char_length(ConfirmationMessage) <= 1900),
 		ConfirmationSecurityAnswer VARCHAR CONSTRAINT role_confirmation_security_answer_length CHECK (char_length(ConfirmationMessage) <= 1900),
-		Trigger TEXT CONSTRAINT role_trigger_length CHECK(char_length(Trigger) <= 100)
+		Trigger TEXT CONSTRAINT role_trigger_length CHECK(char_length(Trigger) <= 100),
+		Aliases TEXT[] NOT NULL DEFAULT '{}'
 	)`
 
 	RoleMaxTriggerLength       = 100
 	RoleMaxTriggerLengthString = "100"
 
-	roleQueryServerRole = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger FROM role WHERE RoleUid = $1 AND ServerId = $2`
-	roleQueryServer     = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger FROM role WHERE ServerId = $1`
-	roleQuery           = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger FROM role WHERE Id = $1`
-	roleQueryTrigger    = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger FROM role WHERE UPPER(Trigger) = UPPER($1) AND ServerId = $2`
-	roleQueryGroup      = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger FROM role 
+	roleQueryServerRole = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases FROM role WHERE RoleUid = $1 AND ServerId = $2`
+	roleQueryServer     = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases FROM role WHERE ServerId = $1`
+	roleQuery           = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases FROM role WHERE Id = $1`
+	roleQueryTrigger    = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases FROM role WHERE (UPPER(Trigger) = UPPER($1) OR EXISTS (SELECT 1 FROM unnest(Aliases) AS alias WHERE UPPER(alias) = UPPER($1))) AND ServerId = $2`
+	roleQueryGroup      = `SELECT Id, ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases FROM role 
 							INNER JOIN group_membership ON group_membership.role_id = role.Id
 							WHERE group_membership.group_id = $1`
 	roleQueryPermissions = `SELECT Permission FROM role WHERE RoleUid = ANY ($1::varchar[])`
 
-	roleUpdate = `UPDATE role SET Permission = $2, ConfirmationMessage = $3, ConfirmationSecurityAnswer = $4, Trigger = $5 WHERE Id = $1`
+	roleUpdate = `UPDATE role SET Permission = $2, ConfirmationMessage = $3, ConfirmationSecurityAnswer = $4, Trigger = $5, Aliases = COALESCE($6, '{}') WHERE Id = $1`
 
-	roleInsert = `INSERT INTO role(ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger) VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	roleInsert = `INSERT INTO role(ServerId, RoleUid, Permission, ConfirmationMessage, ConfirmationSecurityAnswer, Trigger, Aliases) VALUES($1, $2, $3, $4, $5, $6,
+		COALESCE($7, '{}')) RETURNING id`
 
 	roleDelete = `DELETE FROM role WHERE role.RoleUid = $1 AND role.ServerId = (SELECT server.id FROM server WHERE server.guilduid = $2)`
 )
@@ -56,13 +59,14 @@ var (
 		`ALTER TABLE role ADD CONSTRAINT role_confirmation_security_answer_length CHECK(char_length(ConfirmationSecurityAnswer) <= 1900)`,
 		`ALTER TABLE role ADD COLUMN IF NOT EXISTS GroupId INTEGER REFERENCES role_group(Id) ON DELETE CASCADE`,
 		`ALTER TABLE role ALTER COLUMN Permission SET DEFAULT 2`,
+		`ALTER TABLE role ADD COLUMN IF NOT EXISTS Aliases TEXT[] NOT NULL DEFAULT '{}'`,
 	}
 )
 
 func RoleInsertOrUpdate(role types.Role) error {
 	row := moeDb.QueryRow(roleQueryServerRole, role.RoleUid, role.ServerId)
 	var r types.Role
-	if err := row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger); err != nil {
+	if err := row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger, pq.Array(&r.Aliases)); err != nil {
 		if err == sql.ErrNoRows {
 			// no row, so insert it add in default values
 			if role.Permission == -1 {
@@ -71,7 +75,7 @@ func RoleInsertOrUpdate(role types.Role) error {
 			tx, _ := moeDb.Begin()
 			var insertID int
 			err = moeDb.QueryRow(roleInsert, role.ServerId, strings.TrimSpace(role.RoleUid), role.Permission, role.ConfirmationMessage,
-				role.ConfirmationSecurityAnswer, role.Trigger).Scan(&insertID)
+				role.ConfirmationSecurityAnswer, role.Trigger, pq.Array(role.Aliases)).Scan(&insertID)
 			if err != nil {
 				log.Println("Error inserting role to db", err)
 				tx.Rollback()
@@ -109,8 +113,11 @@ func RoleInsertOrUpdate(role types.Role) error {
 		if role.Trigger.Valid {
 			r.Trigger = role.Trigger
 		}
+		if role.Aliases != nil {
+			r.Aliases = role.Aliases
+		}
 		tx, _ := moeDb.Begin()
-		_, err = moeDb.Exec(roleUpdate, r.Id, r.Permission, r.ConfirmationMessage, r.ConfirmationSecurityAnswer, r.Trigger)
+		_, err = moeDb.Exec(roleUpdate, r.Id, r.Permission, r.ConfirmationMessage, r.ConfirmationSecurityAnswer, r.Trigger, pq.Array(r.Aliases))
 		if err != nil {
 			log.Println("Error updating role to db: Id "+strconv.Itoa(r.Id), err)
 			tx.Rollback()
@@ -140,8 +147,8 @@ func RoleInsertOrUpdate(role types.Role) error {
 }
 
 func RoleQueryOrInsert(role types.Role) (r types.Role, err error) {
-	row := moeDb.QueryRow(roleQueryServerRole, role.ServerId, role.RoleUid)
-	if err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger); err != nil {
+	row := moeDb.QueryRow(roleQueryServerRole, role.RoleUid, role.ServerId)
+	if err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger, pq.Array(&r.Aliases)); err != nil {
 		if err == sql.ErrNoRows {
 			// no row, so insert it add in default values
 			if role.Permission == -1 {
@@ -149,7 +156,7 @@ func RoleQueryOrInsert(role types.Role) (r types.Role, err error) {
 			}
 			tx, _ := moeDb.Begin()
 			err = moeDb.QueryRow(roleInsert, role.ServerId, strings.TrimSpace(role.RoleUid), role.Permission, role.ConfirmationMessage,
-				role.ConfirmationSecurityAnswer, role.Trigger).Scan(&role.Id)
+				role.ConfirmationSecurityAnswer, role.Trigger, pq.Array(role.Aliases)).Scan(&role.Id)
 			if err != nil {
 				log.Println("Error inserting role to db")
 				tx.Rollback()
@@ -183,7 +190,7 @@ func RoleQueryServer(s types.Server) (roles []types.Role, err error) {
 	for rows.Next() {
 		var r types.Role
 		if err = rows.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer,
-			&r.Trigger); err != nil {
+			&r.Trigger, pq.Array(&r.Aliases)); err != nil {
 
 			log.Println("Error scanning from role table:", err)
 			return
@@ -207,7 +214,7 @@ func RoleQueryGroup(groupId int) (roles []types.Role, err error) {
 	for rows.Next() {
 		var r types.Role
 		if err = rows.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer,
-			&r.Trigger); err != nil {
+			&r.Trigger, pq.Array(&r.Aliases)); err != nil {
 
 			log.Println("Error scanning from role table:", err)
 			return
@@ -223,9 +230,12 @@ func RoleQueryGroup(groupId int) (roles []types.Role, err error) {
 
 func RoleQueryTrigger(trigger string, serverId int) (r types.Role, err error) {
 	row := moeDb.QueryRow(roleQueryTrigger, trigger, serverId)
-	err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger)
-	if err != nil && err != sql.ErrNoRows {
-		log.Println("Error querying for role by trigger", err)
+	err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger, pq.Array(&r.Aliases))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Error querying for role by trigger", err)
+		}
+		return
 	}
 	if r.Groups, err = groupMembershipQueryByRoleID(r.Id); err != nil {
 		log.Println("Error scanning from role group relation table:", err)
@@ -237,7 +247,7 @@ func RoleQueryTrigger(trigger string, serverId int) (r types.Role, err error) {
 
 func RoleQueryRoleUid(roleUid string, serverId int) (r types.Role, err error) {
 	row := moeDb.QueryRow(roleQueryServerRole, roleUid, serverId)
-	err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger)
+	err = row.Scan(&r.Id, &r.ServerId, &r.RoleUid, &r.Permission, &r.ConfirmationMessage, &r.ConfirmationSecurityAnswer, &r.Trigger, pq.Array(&r.Aliases))
 	if err == nil {
 		if r.Groups, err = groupMembershipQueryByRoleID(r.Id); err != nil {
 			log.Println("Error scanning from role group relation table:", err)
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	roleConfirmationTable = `CREATE TABLE IF NOT EXISTS role_confirmation(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		RoleId INTEGER NOT NULL REFERENCES role(Id) ON DELETE CASCADE,
+		UserUid VARCHAR(20) NOT NULL,
+		IssuedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FailedAttempts INTEGER NOT NULL DEFAULT 0,
+		LastFailure TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (RoleId, UserUid)
+	)`
+
+	roleConfirmationColumns = `Id, RoleId, UserUid, IssuedAt, FailedAttempts, LastFailure`
+
+	roleConfirmationQuery = `SELECT ` + roleConfirmationColumns + ` FROM role_confirmation WHERE RoleId = $1 AND UserUid = $2`
+	// Re-issuing a code doesn't touch the failed attempts, otherwise asking for a new code would skip past the cooldown
+	roleConfirmationIssue = `INSERT INTO role_confirmation(RoleId, UserUid, IssuedAt) VALUES ($1, $2, $3)
+		ON CONFLICT (RoleId, UserUid) DO UPDATE SET IssuedAt = EXCLUDED.IssuedAt
+		RETURNING ` + roleConfirmationColumns
+	roleConfirmationFail   = `UPDATE role_confirmation SET FailedAttempts = FailedAttempts + 1, LastFailure = $2 WHERE Id = $1 RETURNING FailedAttempts`
+	roleConfirmationReset  = `UPDATE role_confirmation SET FailedAttempts = 0 WHERE Id = $1`
+	roleConfirmationDelete = `DELETE FROM role_confirmation WHERE Id = $1`
+)
+
+/*
+Stores a newly issued confirmation code for the given role and user, replacing any code they were previously given
+*/
+func RoleConfirmationIssue(roleId int, userUid string) (rc types.RoleConfirmation, err error) {
+	// codes are derived from the issue time, so drop anything smaller than a second to make sure it survives the round trip
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	err = roleConfirmationScan(moeDb.QueryRow(roleConfirmationIssue, roleId, userUid, issuedAt), &rc)
+	if err != nil {
+		log.Println("Error issuing role confirmation", err)
+	}
+	return
+}
+
+func RoleConfirmationQuery(roleId int, userUid string) (rc types.RoleConfirmation, err error) {
+	err = roleConfirmationScan(moeDb.QueryRow(roleConfirmationQuery, roleId, userUid), &rc)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for role confirmation", err)
+	}
+	return
+}
+
+/*
+Records a failed attempt at entering a confirmation code, returning the total number of failed attempts
+*/
+func RoleConfirmationFail(id int) (attempts int, err error) {
+	err = moeDb.QueryRow(roleConfirmationFail, id, time.Now().UTC()).Scan(&attempts)
+	if err != nil {
+		log.Println("Error updating role confirmation failed attempts", err)
+	}
+	return
+}
+
+func RoleConfirmationResetAttempts(id int) (err error) {
+	_, err = moeDb.Exec(roleConfirmationReset, id)
+	if err != nil {
+		log.Println("Error resetting role confirmation failed attempts", err)
+	}
+	return
+}
+
+func RoleConfirmationDelete(id int) (err error) {
+	_, err = moeDb.Exec(roleConfirmationDelete, id)
+	if err != nil {
+		log.Println("Error deleting role confirmation", err)
+	}
+	return
+}
+
+func roleConfirmationScan(row *sql.Row, rc *types.RoleConfirmation) error {
+	return row.Scan(&rc.Id, &rc.RoleId, &rc.UserUid, &rc.IssuedAt, &rc.FailedAttempts, &rc.LastFailure)
+}
+
+func roleConfirmationCreateTable() {
+	_, err := moeDb.Exec(roleConfirmationTable)
+	if err != nil {
+		log.Println("Error creating role confirmation table", err)
+		return
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 const (
 	SchedulerChannelRotation types.SchedulerType = 1
+	SchedulerPointDecay      types.SchedulerType = 2
 )
 
 const (
@@ -20,9 +21,9 @@ const (
 		execution_interval INTERVAL NOT NULL
 	)`
 
-	scheduledOperationQueryNow = `SELECT id, server_id, planned_execution_time FROM scheduled_operation WHERE planned_execution_time < CURRENT_TIMESTAMP`
+	scheduledOperationQueryNow = `SELECT id, server_id, type, planned_execution_time FROM scheduled_operation WHERE planned_execution_time < CURRENT_TIMESTAMP`
 
-	scheduledOperationQueryServer = `SELECT id, server_id, planned_execution_time FROM scheduled_operation WHERE server_id = $1`
+	scheduledOperationQueryServer = `SELECT id, server_id, type, planned_execution_time FROM scheduled_operation WHERE server_id = $1`
 
 	scheduledOperationUpdate = `UPDATE scheduled_operation SET planned_execution_time = CURRENT_TIMESTAMP + execution_interval WHERE id = $1 RETURNING planned_execution_time`
 
@@ -44,7 +45,7 @@ func ScheduledOperationQueryNow() ([]*types.ScheduledOperation, error) {
 	var result []*types.ScheduledOperation
 	for rows.Next() {
 		operation := new(types.ScheduledOperation)
-		err = rows.Scan(&operation.ID, &operation.ServerID, &operation.PlannedExecutionTime)
+		err = rows.Scan(&operation.ID, &operation.ServerID, &operation.Type, &operation.PlannedExecutionTime)
 		if err != nil {
 			log.Println("Error querying for current scheduled operations", err)
 			return nil, err
@@ -63,7 +64,7 @@ func ScheduledOperationQueryServer(serverID int) ([]*types.ScheduledOperation, e
 	var result []*types.ScheduledOperation
 	for rows.Next() {
 		operation := new(types.ScheduledOperation)
-		err = rows.Scan(&operation.ID, &operation.ServerID, &operation.PlannedExecutionTime)
+		err = rows.Scan(&operation.ID, &operation.ServerID, &operation.Type, &operation.PlannedExecutionTime)
 		if err != nil {
 			log.Println("Error querying for server scheduled operations", err)
 			return nil, err
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/lib/pq"
 )
 
 const (
@@ -22,18 +23,74 @@ const (
 		Enabled BOOLEAN NOT NULL DEFAULT TRUE,
 		WelcomeChannel VARCHAR(20),
 		StarterRole VARCHAR(20),
-		BaseRole VARCHAR(20)
+		BaseRole VARCHAR(20),
+		RoleCodeExpiry INTEGER,
+		RoleCodeSecret VARCHAR(64),
+		VeteranMessagePoints INTEGER,
+		VeteranReactionPoints INTEGER,
+		VeteranMessageCooldown INTEGER,
+		VeteranReactionCooldown INTEGER,
+		VeteranIgnoredPrefixes TEXT[],
+		VeteranExcludedChannels TEXT[],
+		VeteranExcludedRoles TEXT[],
+		VeteranVoicePoints INTEGER,
+		VeteranVoiceDailyCap INTEGER,
+		ProfileAccentColor VARCHAR(7),
+		StatsRetentionDays INTEGER,
+		MuteRole VARCHAR(20),
+		ModLogChannel VARCHAR(20),
+		AutomodExemptChannels TEXT[],
+		AutomodExemptRoles TEXT[],
+		MessageLogChannel VARCHAR(20),
+		MessageLogExcludedChannels TEXT[],
+		RaidJoinCount INTEGER,
+		RaidJoinSeconds INTEGER,
+		RaidAccountAgeDays INTEGER,
+		RaidModeMinutes INTEGER,
+		UnverifiedRemindHours INTEGER,
+		UnverifiedKickHours INTEGER,
+		CaptchaEnabled BOOLEAN NOT NULL DEFAULT FALSE,
+		CaptchaMinutes INTEGER,
+		CaptchaAttempts INTEGER,
+		StarboardChannel VARCHAR(20),
+		StarboardEmoji VARCHAR(64),
+		StarboardThreshold INTEGER,
+		StarboardSelfStar BOOLEAN NOT NULL DEFAULT FALSE,
+		StarboardAllowNsfw BOOLEAN NOT NULL DEFAULT FALSE,
+		WelcomeEmbed BOOLEAN NOT NULL DEFAULT FALSE,
+		GoodbyeMessage VARCHAR(1900),
+		GoodbyeChannel VARCHAR(20),
+		RulesChannel VARCHAR(20)
 	)`
 
-	serverColumnNames        = `GuildUid, WelcomeMessage, RuleAgreement, VeteranRank, VeteranRole, BotChannel, Enabled, WelcomeChannel, StarterRole, BaseRole`
+	serverColumnNames = `GuildUid, WelcomeMessage, RuleAgreement, VeteranRank, VeteranRole, BotChannel, Enabled, WelcomeChannel, StarterRole, BaseRole, RoleCodeExpiry,
+		VeteranMessagePoints, VeteranReactionPoints, VeteranMessageCooldown, VeteranReactionCooldown, VeteranIgnoredPrefixes, VeteranExcludedChannels,
+		VeteranExcludedRoles, VeteranVoicePoints, VeteranVoiceDailyCap, ProfileAccentColor, StatsRetentionDays, MuteRole,
+		ModLogChannel, AutomodExemptChannels, AutomodExemptRoles, MessageLogChannel, MessageLogExcludedChannels,
+		RaidJoinCount, RaidJoinSeconds, RaidAccountAgeDays, RaidModeMinutes, UnverifiedRemindHours, UnverifiedKickHours,
+		CaptchaEnabled, CaptchaMinutes, CaptchaAttempts, StarboardChannel, StarboardEmoji, StarboardThreshold, StarboardSelfStar,
+		StarboardAllowNsfw, WelcomeEmbed, GoodbyeMessage, GoodbyeChannel, RulesChannel`
 	serverInsertColumnNames  = `GuildUid, WelcomeMessage, RuleAgreement, VeteranRank, VeteranRole, BotChannel, WelcomeChannel, StarterRole, BaseRole`
 	serverInsertColumnParams = `$1, $2, $3, $4, $5, $6, $7, $8, $9`
-	serverSetParams          = `WelcomeMessage = $2, RuleAgreement = $3, VeteranRank = $4, VeteranRole = $5, BotChannel = $6, Enabled = $7, StarterRole = $8, BaseRole = $9, WelcomeChannel = $10`
+	serverSetParams          = `WelcomeMessage = $2, RuleAgreement = $3, VeteranRank = $4, VeteranRole = $5, BotChannel = $6, Enabled = $7, StarterRole = $8, BaseRole = $9, WelcomeChannel = $10, RoleCodeExpiry = $11,
+		VeteranMessagePoints = $12, VeteranReactionPoints = $13, VeteranMessageCooldown = $14, VeteranReactionCooldown = $15, VeteranIgnoredPrefixes = $16,
+		VeteranExcludedChannels = $17, VeteranExcludedRoles = $18, VeteranVoicePoints = $19, VeteranVoiceDailyCap = $20, ProfileAccentColor = $21,
+		StatsRetentionDays = $22, MuteRole = $23, ModLogChannel = $24,
+		AutomodExemptChannels = $25, AutomodExemptRoles = $26, MessageLogChannel = $27,
+		MessageLogExcludedChannels = $28, RaidJoinCount = $29, RaidJoinSeconds = $30, RaidAccountAgeDays = $31,
+		RaidModeMinutes = $32, UnverifiedRemindHours = $33, UnverifiedKickHours = $34,
+		CaptchaEnabled = $35, CaptchaMinutes = $36, CaptchaAttempts = $37,
+		StarboardChannel = $38, StarboardEmoji = $39, StarboardThreshold = $40, StarboardSelfStar = $41, StarboardAllowNsfw = $42,
+		WelcomeEmbed = $43, GoodbyeMessage = $44, GoodbyeChannel = $45, RulesChannel = $46`
 
 	serverQuery      = `SELECT Id, ` + serverColumnNames + ` FROM server WHERE Id = $1`
 	serverQueryGuild = `SELECT Id, ` + serverColumnNames + ` FROM server WHERE GuildUid = $1`
 	serverInsert     = `INSERT INTO server(` + serverInsertColumnNames + `) VALUES (` + serverInsertColumnParams + `) RETURNING id`
 	serverUpdate     = `UPDATE server SET ` + serverSetParams + ` WHERE Id = $1`
+
+	// The secret is kept out of the Server struct so that a full update with a stale server can never overwrite it
+	serverRoleCodeSecretSet   = `UPDATE server SET RoleCodeSecret = COALESCE(RoleCodeSecret, $2) WHERE Id = $1 RETURNING RoleCodeSecret`
+	serverRoleCodeSecretClear = `UPDATE server SET RoleCodeSecret = NULL WHERE Id = $1`
 )
 
 var (
@@ -47,6 +104,43 @@ var (
 		`ALTER TABLE server ADD COLUMN IF NOT EXISTS BaseRole VARCHAR(20)`,
 		`ALTER TABLE server DROP CONSTRAINT IF EXISTS server_defaultpinchannelid_fkey`,
 		`ALTER TABLE server DROP COLUMN IF EXISTS DefaultPinChannelId`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RoleCodeExpiry INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RoleCodeSecret VARCHAR(64)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranMessagePoints INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranReactionPoints INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranMessageCooldown INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranReactionCooldown INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranIgnoredPrefixes TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranExcludedChannels TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranExcludedRoles TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranVoicePoints INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS VeteranVoiceDailyCap INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS ProfileAccentColor VARCHAR(7)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StatsRetentionDays INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS MuteRole VARCHAR(20)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS ModLogChannel VARCHAR(20)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS AutomodExemptChannels TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS AutomodExemptRoles TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS MessageLogChannel VARCHAR(20)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS MessageLogExcludedChannels TEXT[]`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RaidJoinCount INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RaidJoinSeconds INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RaidAccountAgeDays INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RaidModeMinutes INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS UnverifiedRemindHours INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS UnverifiedKickHours INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS CaptchaEnabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS CaptchaMinutes INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS CaptchaAttempts INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StarboardChannel VARCHAR(20)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StarboardEmoji VARCHAR(64)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StarboardThreshold INTEGER`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StarboardSelfStar BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS StarboardAllowNsfw BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS WelcomeEmbed BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS GoodbyeMessage VARCHAR(1900)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS GoodbyeChannel VARCHAR(20)`,
+		`ALTER TABLE server ADD COLUMN IF NOT EXISTS RulesChannel VARCHAR(20)`,
 	}
 
 	serverMemoryBuffer = struct {
@@ -86,7 +180,12 @@ func ServerQueryOrInsert(guildUid string) (s types.Server, e error) {
 			return s, e
 		}
 	}
-	// normal flow of querying a row
+	if e == nil {
+		// normal flow of querying a row
+		serverMemoryBuffer.Lock()
+		serverMemoryBuffer.m[guildUid] = s
+		serverMemoryBuffer.Unlock()
+	}
 	return
 }
 
@@ -101,7 +200,14 @@ func ServerQueryById(id int) (s types.Server, e error) {
 
 func serverScan(row *sql.Row, s *types.Server) error {
 	return row.Scan(&s.Id, &s.GuildUid, &s.WelcomeMessage, &s.RuleAgreement, &s.VeteranRank, &s.VeteranRole, &s.BotChannel, &s.Enabled,
-		&s.WelcomeChannel, &s.StarterRole, &s.BaseRole)
+		&s.WelcomeChannel, &s.StarterRole, &s.BaseRole, &s.RoleCodeExpiry, &s.VeteranMessagePoints, &s.VeteranReactionPoints, &s.VeteranMessageCooldown,
+		&s.VeteranReactionCooldown, pq.Array(&s.VeteranIgnoredPrefixes), pq.Array(&s.VeteranExcludedChannels), pq.Array(&s.VeteranExcludedRoles),
+		&s.VeteranVoicePoints, &s.VeteranVoiceDailyCap, &s.ProfileAccentColor, &s.StatsRetentionDays, &s.MuteRole, &s.ModLogChannel,
+		pq.Array(&s.AutomodExemptChannels), pq.Array(&s.AutomodExemptRoles), &s.MessageLogChannel,
+		pq.Array(&s.MessageLogExcludedChannels), &s.RaidJoinCount, &s.RaidJoinSeconds, &s.RaidAccountAgeDays, &s.RaidModeMinutes,
+		&s.UnverifiedRemindHours, &s.UnverifiedKickHours, &s.CaptchaEnabled, &s.CaptchaMinutes, &s.CaptchaAttempts,
+		&s.StarboardChannel, &s.StarboardEmoji, &s.StarboardThreshold, &s.StarboardSelfStar, &s.StarboardAllowNsfw,
+		&s.WelcomeEmbed, &s.GoodbyeMessage, &s.GoodbyeChannel, &s.RulesChannel)
 }
 
 func ServerSprint(s types.Server) (out string) {
@@ -178,9 +284,130 @@ func ServerSprint(s types.Server) (out string) {
 			buf.WriteString("{!!! MISCONFIG !!!: `veteran role provided but no rank provided!`}")
 		}
 	}
+	if s.RoleCodeExpiry.Valid {
+		buf.WriteString("{RoleCodeExpiry: `")
+		buf.WriteString(strconv.Itoa(int(s.RoleCodeExpiry.Int64)))
+		buf.WriteString("`}")
+	}
+	sprintNullInt(&buf, "VeteranMessagePoints", s.VeteranMessagePoints)
+	sprintNullInt(&buf, "VeteranReactionPoints", s.VeteranReactionPoints)
+	sprintNullInt(&buf, "VeteranMessageCooldown", s.VeteranMessageCooldown)
+	sprintNullInt(&buf, "VeteranReactionCooldown", s.VeteranReactionCooldown)
+	if s.VeteranIgnoredPrefixes != nil {
+		buf.WriteString("{VeteranIgnoredPrefixes: `")
+		buf.WriteString(strings.Join(s.VeteranIgnoredPrefixes, " "))
+		buf.WriteString("`}")
+	}
+	if len(s.VeteranExcludedChannels) > 0 {
+		buf.WriteString("{VeteranExcludedChannels: `")
+		buf.WriteString(strings.Join(s.VeteranExcludedChannels, ", "))
+		buf.WriteString("`}")
+	}
+	if len(s.VeteranExcludedRoles) > 0 {
+		buf.WriteString("{VeteranExcludedRoles: `")
+		buf.WriteString(strings.Join(s.VeteranExcludedRoles, ", "))
+		buf.WriteString("`}")
+	}
+	sprintNullInt(&buf, "VeteranVoicePoints", s.VeteranVoicePoints)
+	sprintNullInt(&buf, "VeteranVoiceDailyCap", s.VeteranVoiceDailyCap)
+	if s.ProfileAccentColor.Valid {
+		buf.WriteString("{ProfileAccentColor: `")
+		buf.WriteString(s.ProfileAccentColor.String)
+		buf.WriteString("`}")
+	}
+	sprintNullInt(&buf, "StatsRetentionDays", s.StatsRetentionDays)
+	if s.MuteRole.Valid {
+		buf.WriteString("{MuteRole: `")
+		buf.WriteString(s.MuteRole.String)
+		buf.WriteString("`}")
+	}
+	if s.ModLogChannel.Valid {
+		buf.WriteString("{ModLogChannel: `")
+		buf.WriteString(s.ModLogChannel.String)
+		buf.WriteString("`}")
+	}
+	if len(s.AutomodExemptChannels) > 0 {
+		buf.WriteString("{AutomodExemptChannels: `")
+		buf.WriteString(strings.Join(s.AutomodExemptChannels, ", "))
+		buf.WriteString("`}")
+	}
+	if len(s.AutomodExemptRoles) > 0 {
+		buf.WriteString("{AutomodExemptRoles: `")
+		buf.WriteString(strings.Join(s.AutomodExemptRoles, ", "))
+		buf.WriteString("`}")
+	}
+	if s.MessageLogChannel.Valid {
+		buf.WriteString("{MessageLogChannel: `")
+		buf.WriteString(s.MessageLogChannel.String)
+		buf.WriteString("`}")
+	}
+	if len(s.MessageLogExcludedChannels) > 0 {
+		buf.WriteString("{MessageLogExcludedChannels: `")
+		buf.WriteString(strings.Join(s.MessageLogExcludedChannels, ", "))
+		buf.WriteString("`}")
+	}
+	sprintNullInt(&buf, "RaidJoinCount", s.RaidJoinCount)
+	sprintNullInt(&buf, "RaidJoinSeconds", s.RaidJoinSeconds)
+	sprintNullInt(&buf, "RaidAccountAgeDays", s.RaidAccountAgeDays)
+	sprintNullInt(&buf, "RaidModeMinutes", s.RaidModeMinutes)
+	sprintNullInt(&buf, "UnverifiedRemindHours", s.UnverifiedRemindHours)
+	sprintNullInt(&buf, "UnverifiedKickHours", s.UnverifiedKickHours)
+	if s.CaptchaEnabled {
+		buf.WriteString("{CaptchaEnabled: `true`}")
+	}
+	sprintNullInt(&buf, "CaptchaMinutes", s.CaptchaMinutes)
+	sprintNullInt(&buf, "CaptchaAttempts", s.CaptchaAttempts)
+	if s.StarboardChannel.Valid {
+		buf.WriteString("{StarboardChannel: `")
+		buf.WriteString(s.StarboardChannel.String)
+		buf.WriteString("`}")
+	}
+	if s.StarboardEmoji.Valid {
+		buf.WriteString("{StarboardEmoji: `")
+		buf.WriteString(s.StarboardEmoji.String)
+		buf.WriteString("`}")
+	}
+	sprintNullInt(&buf, "StarboardThreshold", s.StarboardThreshold)
+	if s.StarboardSelfStar {
+		buf.WriteString("{StarboardSelfStar: `true`}")
+	}
+	if s.StarboardAllowNsfw {
+		buf.WriteString("{StarboardAllowNsfw: `true`}")
+	}
+	if s.WelcomeEmbed {
+		buf.WriteString("{WelcomeEmbed: `true`}")
+	}
+	if s.GoodbyeMessage.Valid {
+		buf.WriteString("{GoodbyeMessage: `")
+		if len(s.GoodbyeMessage.String) > 25 {
+			buf.WriteString(s.GoodbyeMessage.String[0:25])
+			buf.WriteString("...")
+		} else {
+			buf.WriteString(s.GoodbyeMessage.String)
+		}
+		buf.WriteString("`}")
+	}
+	if s.GoodbyeChannel.Valid {
+		buf.WriteString("{GoodbyeChannel: `")
+		buf.WriteString(s.GoodbyeChannel.String)
+		buf.WriteString("`}")
+	}
+	if s.RulesChannel.Valid {
+		buf.WriteString("{RulesChannel: `")
+		buf.WriteString(s.RulesChannel.String)
+		buf.WriteString("`}")
+	}
 	return buf.String()
 }
 
+func sprintNullInt(buf *strings.Builder, name string, i sql.NullInt64) {
+	if i.Valid {
+		buf.WriteString("{" + name + ": `")
+		buf.WriteString(strconv.FormatInt(i.Int64, 10))
+		buf.WriteString("`}")
+	}
+}
+
 func FlushServerCache() {
 	serverMemoryBuffer.Lock()
 	defer serverMemoryBuffer.Unlock()
@@ -189,9 +416,44 @@ func FlushServerCache() {
 
 func ServerFullUpdate(s types.Server) (err error) {
 	_, err = moeDb.Exec(serverUpdate, s.Id, s.WelcomeMessage, s.RuleAgreement, s.VeteranRank, s.VeteranRole, s.BotChannel, s.Enabled,
-		s.StarterRole, s.BaseRole, s.WelcomeChannel)
+		s.StarterRole, s.BaseRole, s.WelcomeChannel, s.RoleCodeExpiry, s.VeteranMessagePoints, s.VeteranReactionPoints, s.VeteranMessageCooldown,
+		s.VeteranReactionCooldown, pq.Array(s.VeteranIgnoredPrefixes), pq.Array(s.VeteranExcludedChannels), pq.Array(s.VeteranExcludedRoles),
+		s.VeteranVoicePoints, s.VeteranVoiceDailyCap, s.ProfileAccentColor, s.StatsRetentionDays, s.MuteRole, s.ModLogChannel,
+		pq.Array(s.AutomodExemptChannels), pq.Array(s.AutomodExemptRoles), s.MessageLogChannel,
+		pq.Array(s.MessageLogExcludedChannels), s.RaidJoinCount, s.RaidJoinSeconds, s.RaidAccountAgeDays, s.RaidModeMinutes,
+		s.UnverifiedRemindHours, s.UnverifiedKickHours, s.CaptchaEnabled, s.CaptchaMinutes, s.CaptchaAttempts,
+		s.StarboardChannel, s.StarboardEmoji, s.StarboardThreshold, s.StarboardSelfStar, s.StarboardAllowNsfw,
+		s.WelcomeEmbed, s.GoodbyeMessage, s.GoodbyeChannel, s.RulesChannel)
 	if err != nil {
 		log.Println("There was an error updating the server table", err)
+		return
+	}
+	// keep the cache in sync so the change shows up right away
+	serverMemoryBuffer.Lock()
+	serverMemoryBuffer.m[s.GuildUid] = s
+	serverMemoryBuffer.Unlock()
+	return
+}
+
+/*
+Returns the secret used to sign role confirmation codes for the given server. If the server doesn't have a secret yet then the given
+candidate is stored and returned instead.
+*/
+func ServerRoleCodeSecret(serverId int, candidate string) (secret string, err error) {
+	err = moeDb.QueryRow(serverRoleCodeSecretSet, serverId, candidate).Scan(&secret)
+	if err != nil {
+		log.Println("Error fetching the role code secret for server", err)
+	}
+	return
+}
+
+/*
+Removes the role code secret for the given server, invalidating every confirmation code that was previously handed out
+*/
+func ServerClearRoleCodeSecret(serverId int) (err error) {
+	_, err = moeDb.Exec(serverRoleCodeSecretClear, serverId)
+	if err != nil {
+		log.Println("Error clearing the role code secret for server", err)
 	}
 	return
 }
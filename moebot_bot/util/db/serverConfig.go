@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/lib/pq"
+)
+
+const (
+	serverConfigGroupMembershipClear = `DELETE FROM group_membership WHERE role_id = $1`
+)
+
+/*
+Applies a full role and group configuration to a server in a single transaction. Groups are matched by name and roles by their UID,
+anything that already exists is updated and everything else is inserted. Nothing that isn't part of the given configuration is touched.
+roleGroupNames maps a role UID to the names of every group that role should belong to, which replaces any existing group membership.
+*/
+func ServerRoleConfigApply(s types.Server, groups []types.RoleGroup, roles []types.Role, roleGroupNames map[string][]string) (err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for server config apply", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	groupIds := make(map[string]int)
+	rows, err := tx.Query(roleGroupQueryByServer, s.Id)
+	if err != nil {
+		log.Println("Error querying role groups for server config apply", err)
+		return err
+	}
+	for rows.Next() {
+		var rg types.RoleGroup
+		if err = rows.Scan(&rg.Id, &rg.ServerId, &rg.Name, &rg.Type); err != nil {
+			rows.Close()
+			log.Println("Error scanning role groups for server config apply", err)
+			return err
+		}
+		groupIds[rg.Name] = rg.Id
+	}
+	rows.Close()
+
+	for _, g := range groups {
+		if id, ok := groupIds[g.Name]; ok {
+			_, err = tx.Exec(roleGroupUpdate, id, g.Name, g.Type)
+		} else {
+			var newId int
+			err = tx.QueryRow(roleGroupInsert, s.Id, g.Name, g.Type).Scan(&newId)
+			groupIds[g.Name] = newId
+		}
+		if err != nil {
+			log.Println("Error saving role group "+g.Name+" for server config apply", err)
+			return err
+		}
+	}
+
+	for _, r := range roles {
+		var existing types.Role
+		err = tx.QueryRow(roleQueryServerRole, r.RoleUid, s.Id).Scan(&existing.Id, &existing.ServerId, &existing.RoleUid, &existing.Permission,
+			&existing.ConfirmationMessage, &existing.ConfirmationSecurityAnswer, &existing.Trigger, pq.Array(&existing.Aliases))
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(roleInsert, s.Id, strings.TrimSpace(r.RoleUid), r.Permission, r.ConfirmationMessage, r.ConfirmationSecurityAnswer,
+				r.Trigger, pq.Array(r.Aliases)).Scan(&existing.Id)
+		} else if err == nil {
+			_, err = tx.Exec(roleUpdate, existing.Id, r.Permission, r.ConfirmationMessage, r.ConfirmationSecurityAnswer, r.Trigger, pq.Array(r.Aliases))
+		}
+		if err != nil {
+			log.Println("Error saving role "+r.RoleUid+" for server config apply", err)
+			return err
+		}
+		if _, err = tx.Exec(serverConfigGroupMembershipClear, existing.Id); err != nil {
+			log.Println("Error clearing group membership for server config apply", err)
+			return err
+		}
+		for _, groupName := range roleGroupNames[r.RoleUid] {
+			if _, err = tx.Exec(groupMembershipInsert, existing.Id, groupIds[groupName]); err != nil {
+				log.Println("Error adding group membership for server config apply", err)
+				return err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing server config apply", err)
+	}
+	return err
+}
@@ -0,0 +1,324 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+	"github.com/lib/pq"
+)
+
+const (
+	shopItemTable = `CREATE TABLE IF NOT EXISTS shop_item(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		Name VARCHAR(100) NOT NULL,
+		Type VARCHAR(10) NOT NULL,
+		Price INTEGER NOT NULL,
+		RoleUid VARCHAR(20),
+		DurationMinutes INTEGER
+	)`
+
+	// Names are looked up ignoring case, so they need to be unique ignoring case too
+	shopItemIndex = `CREATE UNIQUE INDEX IF NOT EXISTS shop_item_server_name ON shop_item(ServerId, UPPER(Name))`
+
+	shopPurchaseTable = `CREATE TABLE IF NOT EXISTS shop_purchase(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		UserId INTEGER NOT NULL REFERENCES user_profile(Id) ON DELETE CASCADE,
+		ItemName VARCHAR(100) NOT NULL,
+		ItemType VARCHAR(10) NOT NULL,
+		Price INTEGER NOT NULL,
+		RoleUid VARCHAR(20),
+		CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		ExpiresAt TIMESTAMP,
+		Expired BOOLEAN NOT NULL DEFAULT false,
+		Refunded BOOLEAN NOT NULL DEFAULT false,
+		RefundedBy INTEGER REFERENCES user_profile(Id) ON DELETE SET NULL,
+		HadRole BOOLEAN NOT NULL DEFAULT false
+	)`
+
+	ShopItemMaxNameLength       = 100
+	ShopItemMaxNameLengthString = "100"
+
+	shopItemSelect      = `SELECT Id, ServerId, Name, Type, Price, RoleUid, DurationMinutes FROM shop_item `
+	shopItemQueryServer = shopItemSelect + `WHERE ServerId = $1 ORDER BY Price, Name`
+	shopItemQueryName   = shopItemSelect + `WHERE ServerId = $1 AND UPPER(Name) = UPPER($2)`
+	shopItemUpsert      = `INSERT INTO shop_item(ServerId, Name, Type, Price, RoleUid, DurationMinutes) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (ServerId, UPPER(Name)) DO UPDATE SET Type = EXCLUDED.Type, Price = EXCLUDED.Price, RoleUid = EXCLUDED.RoleUid,
+		DurationMinutes = EXCLUDED.DurationMinutes`
+	shopItemDelete = `DELETE FROM shop_item WHERE ServerId = $1 AND UPPER(Name) = UPPER($2)`
+
+	shopPurchaseSelect = `SELECT sp.Id, sp.ServerId, s.GuildUid, sp.UserId, up.UserUid, sp.ItemName, sp.ItemType, sp.Price, sp.RoleUid, sp.CreatedAt,
+		sp.ExpiresAt, sp.Expired, sp.Refunded, sp.HadRole FROM shop_purchase AS sp
+		JOIN server AS s ON s.Id = sp.ServerId
+		JOIN user_profile AS up ON up.Id = sp.UserId `
+	shopPurchaseQuery     = shopPurchaseSelect + `WHERE sp.Id = $1 AND sp.ServerId = $2`
+	shopPurchaseQueryUser = shopPurchaseSelect + `WHERE sp.ServerId = $1 AND sp.UserId = $2 ORDER BY sp.CreatedAt DESC LIMIT $3`
+	// Only expire a temp role once there's no other purchase still holding onto the same role, permanent ones never expire
+	shopPurchaseQueryExpired = shopPurchaseSelect + `WHERE sp.ExpiresAt < $1 AND NOT sp.Expired AND NOT sp.Refunded AND NOT EXISTS (
+			SELECT 1 FROM shop_purchase AS other WHERE other.ServerId = sp.ServerId AND other.UserId = sp.UserId AND other.RoleUid = sp.RoleUid
+			AND (other.ExpiresAt IS NULL OR other.ExpiresAt >= $1) AND NOT other.Refunded)`
+	shopPurchaseQueryColorRole = `SELECT RoleUid FROM shop_purchase WHERE ServerId = $1 AND UserId = $2 AND ItemType = $3 AND NOT Refunded
+		AND RoleUid IS NOT NULL ORDER BY CreatedAt DESC LIMIT 1`
+	shopPurchaseCountActive = `SELECT COUNT(*), COALESCE(BOOL_OR(HadRole), false) FROM shop_purchase WHERE ServerId = $1 AND UserId = $2 AND RoleUid = $3
+		AND NOT Refunded AND NOT Expired`
+	shopPurchaseInsert = `INSERT INTO shop_purchase(ServerId, UserId, ItemName, ItemType, Price, RoleUid, CreatedAt, ExpiresAt, HadRole)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING Id`
+	shopPurchaseSetRole    = `UPDATE shop_purchase SET RoleUid = $2 WHERE Id = $1`
+	shopPurchaseSetExpired = `UPDATE shop_purchase SET Expired = true WHERE Id = $1`
+	shopPurchaseRefund     = `UPDATE shop_purchase SET Refunded = true, RefundedBy = $3 WHERE Id = $1 AND ServerId = $2 AND NOT Refunded
+		RETURNING UserId, Price`
+
+	// Balance is only ever spent here, so it can't go negative
+	userServerRankSpend  = `UPDATE user_server_rank SET Balance = Balance - $3 WHERE ServerId = $1 AND UserId = $2 AND Balance >= $3 RETURNING Balance`
+	userServerRankRefund = `UPDATE user_server_rank SET Balance = Balance + $3 WHERE ServerId = $1 AND UserId = $2`
+)
+
+// Returned when a user tries to buy something they can't afford
+var ErrNotEnoughBalance = errors.New("not enough balance")
+
+func ShopItemQueryServer(serverId int) (items []types.ShopItem, err error) {
+	rows, err := moeDb.Query(shopItemQueryServer, serverId)
+	if err != nil {
+		log.Println("Error querying for shop items", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i types.ShopItem
+		if err = rows.Scan(&i.Id, &i.ServerId, &i.Name, &i.Type, &i.Price, &i.RoleUid, &i.DurationMinutes); err != nil {
+			log.Println("Error scanning shop item", err)
+			return
+		}
+		items = append(items, i)
+	}
+	return
+}
+
+/*
+Gets a shop item by name, ignoring case. Returns sql.ErrNoRows if there isn't one
+*/
+func ShopItemQueryName(serverId int, name string) (i types.ShopItem, err error) {
+	err = moeDb.QueryRow(shopItemQueryName, serverId, name).Scan(&i.Id, &i.ServerId, &i.Name, &i.Type, &i.Price, &i.RoleUid, &i.DurationMinutes)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for shop item", err)
+	}
+	return
+}
+
+func ShopItemInsertOrUpdate(i types.ShopItem) (err error) {
+	_, err = moeDb.Exec(shopItemUpsert, i.ServerId, i.Name, i.Type, i.Price, i.RoleUid, i.DurationMinutes)
+	if err != nil {
+		log.Println("Error inserting or updating shop item", err)
+	}
+	return
+}
+
+/*
+Deletes the item with the given name, returning false if there wasn't one to delete. Purchases of the item are kept
+*/
+func ShopItemDelete(serverId int, name string) (deleted bool, err error) {
+	result, err := moeDb.Exec(shopItemDelete, serverId, name)
+	if err != nil {
+		log.Println("Error deleting shop item", err)
+		return
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+/*
+Takes the item's price out of the user's balance and records the purchase. Returns ErrNotEnoughBalance if they can't afford it.
+hadRole is whether the user already had the role without the shop, so it's left alone when the purchase runs out or is refunded
+*/
+func ShopPurchaseBuy(userId int, serverId int, item types.ShopItem, roleUid sql.NullString, expiresAt time.Time,
+	hadRole bool) (p types.ShopPurchase, balance int, err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for shop purchase", err)
+		return
+	}
+	err = tx.QueryRow(userServerRankSpend, serverId, userId, item.Price).Scan(&balance)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return p, 0, ErrNotEnoughBalance
+	} else if err != nil {
+		log.Println("Error spending balance for shop purchase", err)
+		tx.Rollback()
+		return
+	}
+	p = types.ShopPurchase{
+		ServerId:  serverId,
+		UserId:    userId,
+		ItemName:  item.Name,
+		ItemType:  item.Type,
+		Price:     item.Price,
+		RoleUid:   roleUid,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+		HadRole:   hadRole,
+	}
+	expires := pq.NullTime{Time: expiresAt.UTC(), Valid: !expiresAt.IsZero()}
+	err = tx.QueryRow(shopPurchaseInsert, serverId, userId, item.Name, item.Type, item.Price, roleUid, p.CreatedAt, expires,
+		hadRole).Scan(&p.Id)
+	if err != nil {
+		log.Println("Error inserting shop purchase", err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing shop purchase", err)
+	}
+	return
+}
+
+/*
+Gets a purchase by ID, making sure it's from the given server. Returns sql.ErrNoRows if there isn't one
+*/
+func ShopPurchaseQuery(purchaseId int, serverId int) (p types.ShopPurchase, err error) {
+	row := moeDb.QueryRow(shopPurchaseQuery, purchaseId, serverId)
+	if err = shopPurchaseScan(row, &p); err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for shop purchase", err)
+	}
+	return
+}
+
+/*
+Gets the latest purchases a user has made in a server, newest first
+*/
+func ShopPurchaseQueryUser(userId int, serverId int, limit int) (purchases []types.ShopPurchase, err error) {
+	return shopPurchaseQueryMany(shopPurchaseQueryUser, serverId, userId, limit)
+}
+
+/*
+Gets every temp role purchase that has run out and still needs its role taken away
+*/
+func ShopPurchaseQueryExpired() (purchases []types.ShopPurchase, err error) {
+	return shopPurchaseQueryMany(shopPurchaseQueryExpired, time.Now().UTC())
+}
+
+/*
+Gets the custom colour role the user bought last, if they have one
+*/
+func ShopPurchaseQueryColorRole(userId int, serverId int) (roleUid string, err error) {
+	err = moeDb.QueryRow(shopPurchaseQueryColorRole, serverId, userId, types.ShopItemColor).Scan(&roleUid)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying for shop colour role", err)
+	}
+	return
+}
+
+/*
+Counts purchases that are still giving the user the given role, and whether the user had the role before any of them
+*/
+func ShopPurchaseCountActive(userId int, serverId int, roleUid string) (count int, hadRole bool, err error) {
+	err = moeDb.QueryRow(shopPurchaseCountActive, serverId, userId, roleUid).Scan(&count, &hadRole)
+	if err != nil {
+		log.Println("Error counting active shop purchases", err)
+	}
+	return
+}
+
+func ShopPurchaseSetRole(purchaseId int, roleUid string) (err error) {
+	_, err = moeDb.Exec(shopPurchaseSetRole, purchaseId, roleUid)
+	if err != nil {
+		log.Println("Error setting shop purchase role", err)
+	}
+	return
+}
+
+func ShopPurchaseSetExpired(purchaseId int) (err error) {
+	_, err = moeDb.Exec(shopPurchaseSetExpired, purchaseId)
+	if err != nil {
+		log.Println("Error expiring shop purchase", err)
+	}
+	return
+}
+
+/*
+Marks a purchase as refunded and gives the user their points back. Returns false if it was already refunded
+*/
+func ShopPurchaseRefund(purchaseId int, serverId int, moderatorId int) (refunded bool, err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for shop refund", err)
+		return
+	}
+	var userId, price int
+	err = tx.QueryRow(shopPurchaseRefund, purchaseId, serverId, moderatorId).Scan(&userId, &price)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return false, nil
+	} else if err != nil {
+		log.Println("Error refunding shop purchase", err)
+		tx.Rollback()
+		return
+	}
+	if _, err = tx.Exec(userServerRankRefund, serverId, userId, price); err != nil {
+		log.Println("Error refunding balance for shop purchase", err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing shop refund", err)
+		return
+	}
+	return true, nil
+}
+
+func shopPurchaseQueryMany(query string, args ...interface{}) (purchases []types.ShopPurchase, err error) {
+	rows, err := moeDb.Query(query, args...)
+	if err != nil {
+		log.Println("Error querying for shop purchases", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p types.ShopPurchase
+		if err = shopPurchaseScan(rows, &p); err != nil {
+			log.Println("Error scanning shop purchase", err)
+			return
+		}
+		purchases = append(purchases, p)
+	}
+	return
+}
+
+/*
+Anything that can be scanned, so the same scan works for both a single row and many rows
+*/
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func shopPurchaseScan(row scanner, p *types.ShopPurchase) error {
+	var expires pq.NullTime
+	err := row.Scan(&p.Id, &p.ServerId, &p.GuildUid, &p.UserId, &p.UserUid, &p.ItemName, &p.ItemType, &p.Price, &p.RoleUid, &p.CreatedAt, &expires,
+		&p.Expired, &p.Refunded, &p.HadRole)
+	if expires.Valid {
+		p.ExpiresAt = expires.Time
+	}
+	return err
+}
+
+func shopItemCreateTable() {
+	_, err := moeDb.Exec(shopItemTable)
+	if err != nil {
+		log.Println("Error creating shop item table", err)
+		return
+	}
+	_, err = moeDb.Exec(shopItemIndex)
+	if err != nil {
+		log.Println("Error creating shop item index", err)
+	}
+}
+
+func shopPurchaseCreateTable() {
+	_, err := moeDb.Exec(shopPurchaseTable)
+	if err != nil {
+		log.Println("Error creating shop purchase table", err)
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"log"
+
+	"github.com/camd67/moebot/moebot_bot/util/db/types"
+)
+
+const (
+	starboardEntryTable = `CREATE TABLE IF NOT EXISTS starboard_entry(
+		Id SERIAL NOT NULL PRIMARY KEY,
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		ChannelUid VARCHAR(20) NOT NULL,
+		MessageUid VARCHAR(20) NOT NULL UNIQUE,
+		AuthorUid VARCHAR(20) NOT NULL,
+		StarboardMessageUid VARCHAR(20),
+		StarCount INTEGER NOT NULL
+	)`
+
+	starboardEntryQueryMessage = `SELECT Id, ServerId, ChannelUid, MessageUid, AuthorUid, StarboardMessageUid, StarCount FROM starboard_entry
+		WHERE MessageUid = $1`
+	starboardEntryInsert = `INSERT INTO starboard_entry(ServerId, ChannelUid, MessageUid, AuthorUid, StarboardMessageUid, StarCount)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING Id`
+	starboardEntryUpdate = `UPDATE starboard_entry SET StarboardMessageUid = $2, StarCount = $3 WHERE Id = $1`
+)
+
+/*
+Gets the starboard entry for a message. Returns sql.ErrNoRows if the message was never starred enough to be reposted
+*/
+func StarboardEntryQueryMessage(messageUid string) (e types.StarboardEntry, err error) {
+	row := moeDb.QueryRow(starboardEntryQueryMessage, messageUid)
+	err = row.Scan(&e.Id, &e.ServerId, &e.ChannelUid, &e.MessageUid, &e.AuthorUid, &e.StarboardMessageUid, &e.StarCount)
+	return
+}
+
+func StarboardEntryInsert(e types.StarboardEntry) (types.StarboardEntry, error) {
+	err := moeDb.QueryRow(starboardEntryInsert, e.ServerId, e.ChannelUid, e.MessageUid, e.AuthorUid, e.StarboardMessageUid, e.StarCount).Scan(&e.Id)
+	if err != nil {
+		log.Println("Error inserting starboard entry", err)
+	}
+	return e, err
+}
+
+func StarboardEntryUpdate(e types.StarboardEntry) (err error) {
+	_, err = moeDb.Exec(starboardEntryUpdate, e.Id, e.StarboardMessageUid, e.StarCount)
+	if err != nil {
+		log.Println("Error updating starboard entry", err)
+	}
+	return
+}
+
+func starboardEntryCreateTable() {
+	_, err := moeDb.Exec(starboardEntryTable)
+	if err != nil {
+		log.Println("Error creating starboard entry table", err)
+	}
+}
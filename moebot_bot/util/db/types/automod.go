@@ -0,0 +1,33 @@
+package types
+
+import "database/sql"
+
+// The kinds of messages automod looks for
+const (
+	AutomodFilterWords    = "words"
+	AutomodFilterInvites  = "invites"
+	AutomodFilterMentions = "mentions"
+	AutomodFilterRepeats  = "repeats"
+	AutomodFilterCaps     = "caps"
+)
+
+// What automod does when a filter catches a message. Every action deletes the message
+const (
+	AutomodActionDelete = "delete"
+	AutomodActionWarn   = "warn"
+	AutomodActionMute   = "mute"
+)
+
+/*
+One automod filter's settings for a server. Filters without a row are off
+*/
+type AutomodFilter struct {
+	Id          int
+	ServerId    int
+	Filter      string
+	Enabled     bool
+	Action      string
+	MuteMinutes sql.NullInt64 // How long a mute lasts. If null, the default is used
+	Threshold   sql.NullInt64 // Mentions per message, repeats in a row, or percent caps depending on the filter. If null, the default is used
+	Patterns    []string      // Banned words, or regex wrapped in slashes like /fr[e3]e nitro/. Only used by the words filter
+}
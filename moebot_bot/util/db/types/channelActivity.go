@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+/*
+How busy a channel was over one hour. Only counts are kept, never what was said or who said it
+*/
+type ChannelActivity struct {
+	ServerId     int
+	ChannelUid   string
+	Hour         time.Time
+	MessageCount int
+	UserCount    int
+}
+
+/*
+A channel's messages over a stats period, along with the period before it so mods can see which channels are slowing down
+*/
+type ChannelActivitySummary struct {
+	ChannelUid       string
+	MessageCount     int
+	PreviousMessages int
+	PeakUsers        int
+}
@@ -0,0 +1,26 @@
+package types
+
+import "time"
+
+/*
+A channel that's locked down, along with what @everyone's overwrite was before so unlocking can put it back exactly
+*/
+type ChannelLockdown struct {
+	Id         int
+	ServerId   int
+	ChannelUid string
+	// Both 0 means there was no overwrite, and it's removed on unlock
+	PreviousAllow int
+	PreviousDeny  int
+}
+
+/*
+A slowmode change that gets undone once it runs out
+*/
+type SlowmodeTimer struct {
+	Id              int
+	ServerId        int
+	ChannelUid      string
+	PreviousSeconds int
+	ExpiresAt       time.Time
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"database/sql"
+	"time"
+)
+
+// What a moderator did in a case
+const (
+	ModActionWarn    = "warn"
+	ModActionMute    = "mute"
+	ModActionUnmute  = "unmute"
+	ModActionKick    = "kick"
+	ModActionBan     = "ban"
+	ModActionTempBan = "tempban"
+	ModActionUnban   = "unban"
+)
+
+/*
+A single moderation action against a user. Cases are numbered per server, starting from 1
+*/
+type ModCase struct {
+	Id           int
+	ServerId     int
+	GuildUid     string
+	CaseNumber   int
+	Action       string
+	UserId       int
+	UserUid      string
+	ModeratorId  int
+	ModeratorUid string
+	Reason       sql.NullString
+	// How long a mute or tempban lasts, if it ever ends
+	DurationMinutes sql.NullInt64
+	// Zero if the case never ends on its own
+	ExpiresAt time.Time
+	// True once a timed mute or tempban has been lifted, either when it ran out or by hand
+	Resolved  bool
+	CreatedAt time.Time
+	// The message posted in the mod log, so it can be edited when the reason changes
+	LogChannelUid sql.NullString
+	LogMessageUid sql.NullString
+}
@@ -0,0 +1,19 @@
+package types
+
+import "database/sql"
+
+// Replaced with a mention of the user when sending a tier's congratulation message
+const TierUserSearchText = "[user]"
+
+/*
+A named step on a server's rank ladder, reached once a user has earned Threshold points
+*/
+type RankTier struct {
+	Id        int
+	ServerId  int
+	Name      string
+	Threshold int
+	RoleUid   sql.NullString // Role that goes along with this tier, if any
+	AutoGrant bool           // If true the role is given out as soon as the tier is reached, otherwise it can be claimed with the role command
+	Message   sql.NullString // Sent to the bot channel when someone reaches this tier. If null a default message is used
+}
@@ -1,6 +1,9 @@
 package types
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
 
 // Permission enum
 type Permission int
@@ -30,4 +33,18 @@ type Role struct {
 	ConfirmationMessage        sql.NullString
 	ConfirmationSecurityAnswer sql.NullString
 	Trigger                    sql.NullString
+	// Other triggers that can be used for this role, matched the same way as Trigger
+	Aliases []string
+}
+
+/*
+A confirmation code that was handed out to a user for a role, along with any failed attempts at entering it
+*/
+type RoleConfirmation struct {
+	Id             int
+	RoleId         int
+	UserUid        string
+	IssuedAt       time.Time
+	FailedAttempts int
+	LastFailure    time.Time
 }
@@ -11,6 +11,15 @@ type ScheduledOperation struct {
 	PlannedExecutionTime time.Time
 }
 
+type PointDecay struct {
+	// Either a flat number of points or a percent of the user's points, depending on IsPercent
+	Amount          int
+	IsPercent       bool
+	InactiveDays    int
+	RemoveTierRoles bool
+	ScheduledOperation
+}
+
 type ChannelRotation struct {
 	ChannelUIDList    []string
 	CurrentChannelUID string
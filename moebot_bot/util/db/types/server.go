@@ -17,4 +17,47 @@ type Server struct {
 	WelcomeChannel sql.NullString // Channel to post a welcome message. If null, send via PM's
 	StarterRole    sql.NullString // The role that is added when someone first joins a server
 	BaseRole       sql.NullString // The role that is added when someone types the RuleAgreement message. Should only exist when RuleAgreement isn't null
+	RoleCodeExpiry sql.NullInt64  // Minutes a role confirmation code stays valid for. If null, the default expiry is used
+	// Veteran point settings, any that are null use moebot's defaults
+	VeteranMessagePoints    sql.NullInt64  // Points given for a message
+	VeteranReactionPoints   sql.NullInt64  // Points given for a reaction
+	VeteranMessageCooldown  sql.NullInt64  // Seconds before another message can earn points
+	VeteranReactionCooldown sql.NullInt64  // Seconds before another reaction can earn points
+	VeteranIgnoredPrefixes  []string       // Messages starting with these don't earn points, usually other bots' prefixes. Nil uses the default prefixes
+	VeteranExcludedChannels []string       // Channel IDs where nothing earns points
+	VeteranExcludedRoles    []string       // Role IDs that can't earn points
+	VeteranVoicePoints      sql.NullInt64  // Points given for each minute in voice with someone else. Voice doesn't earn anything by default
+	VeteranVoiceDailyCap    sql.NullInt64  // Most points that can be earned from voice in a day
+	ProfileAccentColor      sql.NullString // Hex colour like #7289da used on profile cards
+	StatsRetentionDays      sql.NullInt64  // Days of channel activity to keep for stats. If null, the default is used
+	MuteRole                sql.NullString // Role given to muted members. It's up to the server to take away permissions from it
+	ModLogChannel           sql.NullString // Where moderation cases get posted. If null, cases are only kept in the database
+	AutomodExemptChannels   []string       // Channel IDs automod leaves alone
+	AutomodExemptRoles      []string       // Role IDs automod leaves alone
+	// Edits and deletes get posted to the message log channel, apart from in the excluded channels. If null, nothing is logged
+	MessageLogChannel          sql.NullString
+	MessageLogExcludedChannels []string
+	// Anti-raid settings. Raid detection is off while RaidJoinCount is null, the rest use moebot's defaults when null
+	RaidJoinCount      sql.NullInt64 // Joins within RaidJoinSeconds that start raid mode. Accounts that look new count twice
+	RaidJoinSeconds    sql.NullInt64 // How far back joins are counted
+	RaidAccountAgeDays sql.NullInt64 // Accounts younger than this many days count as new
+	RaidModeMinutes    sql.NullInt64 // Raid mode ends after this many minutes without another suspicious join
+	// Members still in the StarterRole this long after joining get reminded, then kicked. Either can be null to skip it
+	UnverifiedRemindHours sql.NullInt64
+	UnverifiedKickHours   sql.NullInt64
+	// New members get DM'd a captcha instead of typing the RuleAgreement, and move from the StarterRole to the BaseRole once they solve it
+	CaptchaEnabled  bool
+	CaptchaMinutes  sql.NullInt64 // How long they have to solve it before being kicked
+	CaptchaAttempts sql.NullInt64 // How many wrong answers before being kicked
+	// Messages that get StarboardThreshold reactions of the StarboardEmoji are reposted to the StarboardChannel. Off while the channel is null
+	StarboardChannel   sql.NullString
+	StarboardEmoji     sql.NullString // Either a unicode emoji or name:id for a custom one. If null, ⭐ is used
+	StarboardThreshold sql.NullInt64  // If null, the default is used
+	StarboardSelfStar  bool           // Whether the author's own reaction counts
+	StarboardAllowNsfw bool           // Whether messages in NSFW channels can be reposted
+	// Welcome and goodbye messages can use placeholders like {user} and {server}, see the server command help
+	WelcomeEmbed   bool           // Whether welcome and goodbye messages are sent as an embed instead of plain text
+	GoodbyeMessage sql.NullString // Posted when someone leaves the server
+	GoodbyeChannel sql.NullString // Channel to post the goodbye message. If null, the WelcomeChannel is used. Never sent by PM
+	RulesChannel   sql.NullString // Channel filled in for {rules}
 }
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"database/sql"
+	"time"
+)
+
+// What a shop item does when it's bought
+const (
+	ShopItemRole     = "role"
+	ShopItemTempRole = "temprole"
+	ShopItemColor    = "color"
+	ShopItemRaffle   = "raffle"
+)
+
+type ShopItem struct {
+	Id       int
+	ServerId int
+	Name     string
+	Type     string
+	Price    int
+	// The role given out for role and temprole items
+	RoleUid sql.NullString
+	// How long a temprole lasts
+	DurationMinutes sql.NullInt64
+}
+
+type ShopPurchase struct {
+	Id       int
+	ServerId int
+	GuildUid string
+	UserId   int
+	UserUid  string
+	// The item's name, type and price are copied over so purchases still make sense after an item is changed or deleted
+	ItemName  string
+	ItemType  string
+	Price     int
+	RoleUid   sql.NullString
+	CreatedAt time.Time
+	// Zero if the purchase never expires
+	ExpiresAt time.Time
+	Expired   bool
+	Refunded  bool
+	// The user already had the role before buying it, so it shouldn't be taken away when the purchase ends
+	HadRole bool
+}
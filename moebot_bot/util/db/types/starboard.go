@@ -0,0 +1,17 @@
+package types
+
+import "database/sql"
+
+/*
+A message that has been reposted to a server's starboard
+*/
+type StarboardEntry struct {
+	Id         int
+	ServerId   int
+	ChannelUid string
+	MessageUid string
+	AuthorUid  string
+	// The repost in the star channel, null if posting it failed
+	StarboardMessageUid sql.NullString
+	StarCount           int
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"database/sql"
+	"time"
+)
+
 type UserProfile struct {
 	Id      int
 	UserUid string
@@ -11,6 +16,53 @@ type UserServerRank struct {
 	UserId      int
 	Rank        int
 	MessageSent bool
+	// Hides the user from the server's leaderboard
+	LeaderboardOptOut bool
+}
+
+/*
+Points to add to a user in a server. Id, Rank and MessageSent are filled in with the user's totals once the points are saved
+*/
+type UserServerRankChange struct {
+	UserId      int
+	ServerId    int
+	Points      int
+	Id          int
+	Rank        int
+	MessageSent bool
+}
+
+/*
+A user that lost points to decay, along with their points before and after
+*/
+type UserServerRankDecay struct {
+	UserUid string
+	OldRank int
+	NewRank int
+}
+
+// Where a point event came from
+const (
+	PointSourceActivity = "activity"
+	PointSourceManual   = "manual"
+	PointSourceDecay    = "decay"
+)
+
+/*
+A change to a user's points. Activity events in a history are a whole day's worth of points added together
+*/
+type PointEvent struct {
+	CreatedAt    time.Time
+	Source       string
+	Points       int
+	ModeratorUid sql.NullString
+	Reason       sql.NullString
+}
+
+type LeaderboardEntry struct {
+	UserUid  string
+	Points   int
+	Position int
 }
 
 type UserServerRankWrapper struct {
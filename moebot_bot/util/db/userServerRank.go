@@ -5,6 +5,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
@@ -15,52 +16,192 @@ const (
 		ServerId INTEGER NOT NULL REFERENCES server(id) ON DELETE CASCADE,
 		UserId INTEGER NOT NULL REFERENCES user_profile(id) ON DELETE CASCADE,
 		Rank INTEGER NOT NULL DEFAULT 0,
-		MessageSent BOOLEAN NOT NULL DEFAULT false
+		MessageSent BOOLEAN NOT NULL DEFAULT false,
+		LeaderboardOptOut BOOLEAN NOT NULL DEFAULT false,
+		LastActive TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		Balance INTEGER NOT NULL DEFAULT 0
 	)`
 
-	userServerRankQuery = `SELECT user_server_rank.Id, user_server_rank.ServerId, user_server_rank.UserId, user_server_rank.Rank, user_server_rank.MessageSent FROM user_server_rank
+	userServerRankQuery = `SELECT user_server_rank.Id, user_server_rank.ServerId, user_server_rank.UserId, user_server_rank.Rank, user_server_rank.MessageSent,
+		user_server_rank.LeaderboardOptOut FROM user_server_rank
 		JOIN server ON server.Id = user_server_rank.ServerId
 		JOIN user_profile ON user_profile.Id = user_server_rank.UserId
 		WHERE server.GuildUid = $1 AND user_profile.UserUid = $2`
-	userServerRankQueryId       = `SELECT Id, Rank, MessageSent FROM user_server_rank WHERE ServerId = $1 AND UserId = $2`
-	userServerRankUpdate        = `UPDATE user_server_rank SET Rank = Rank + $2 WHERE Id = $1 RETURNING user_server_rank.Id, user_server_rank.Rank, user_server_rank.MessageSent`
-	userServerRankInsert        = `INSERT INTO user_server_rank(ServerId, UserId, Rank) VALUES ($1, $2, $3) RETURNING user_server_rank.Id, user_server_rank.Rank, user_server_rank.MessageSent`
+	userServerRankUpdate        = `UPDATE user_server_rank SET Rank = Rank + $3, Balance = Balance + $3, LastActive = $4 WHERE ServerId = $1 AND UserId = $2 RETURNING user_server_rank.Id, user_server_rank.Rank, user_server_rank.MessageSent`
+	userServerRankInsert        = `INSERT INTO user_server_rank(ServerId, UserId, Rank, Balance, LastActive) VALUES ($1, $2, $3, $3, $4) RETURNING user_server_rank.Id, user_server_rank.Rank, user_server_rank.MessageSent`
 	userServerRankUpdateMessage = `UPDATE user_server_rank SET MessageSent = true WHERE Id = ANY ($1::integer[])`
+	userServerRankUpdateOptOut  = `UPDATE user_server_rank SET LeaderboardOptOut = $3 WHERE ServerId = $1 AND UserId = $2`
+	userServerRankInsertOptOut  = `INSERT INTO user_server_rank(ServerId, UserId, LeaderboardOptOut) VALUES ($1, $2, $3)`
+
+	userServerRankQueryForUpdate = `SELECT Rank FROM user_server_rank WHERE ServerId = $1 AND UserId = $2 FOR UPDATE`
+	userServerRankSetRank        = `UPDATE user_server_rank SET Rank = $3 WHERE ServerId = $1 AND UserId = $2`
+	userServerRankInsertRank     = `INSERT INTO user_server_rank(ServerId, UserId, Rank) VALUES ($1, $2, $3)`
+	userServerRankQueryBalance   = `SELECT Balance FROM user_server_rank WHERE ServerId = $1 AND UserId = $2`
+
+	// Percent decay always takes at least 1 point, otherwise small ranks would never go down. Every loss gets a point event too
+	userServerRankDecay = `WITH old AS (SELECT Id, Rank FROM user_server_rank WHERE ServerId = $1 AND LastActive < $2 AND Rank > 0 FOR UPDATE),
+		decayed AS (UPDATE user_server_rank AS usr SET Rank = GREATEST(0, old.Rank - CASE WHEN $4 THEN CEIL(old.Rank * $3 / 100.0)::INTEGER ELSE $3 END)
+			FROM old, user_profile AS up WHERE usr.Id = old.Id AND up.Id = usr.UserId
+			RETURNING usr.UserId, up.UserUid, old.Rank AS OldRank, usr.Rank AS NewRank),
+		events AS (INSERT INTO point_event(ServerId, UserId, Points, CreatedAt, Source)
+			SELECT $1, UserId, NewRank - OldRank, $5, $6 FROM decayed WHERE NewRank <> OldRank)
+		SELECT UserUid, OldRank, NewRank FROM decayed`
+)
+
+var (
+	userServerRankUpdateTable = []string{
+		`ALTER TABLE user_server_rank ADD COLUMN IF NOT EXISTS LeaderboardOptOut BOOLEAN NOT NULL DEFAULT false`,
+		// everyone counts as active from when this was added, so nobody decays right away
+		`ALTER TABLE user_server_rank ADD COLUMN IF NOT EXISTS LastActive TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+		// balances start empty, points earned before the shop existed can't be spent
+		`ALTER TABLE user_server_rank ADD COLUMN IF NOT EXISTS Balance INTEGER NOT NULL DEFAULT 0`,
+	}
 )
 
 func UserServerRankQuery(userUid string, guildUid string) (usr *types.UserServerRank, err error) {
 	row := moeDb.QueryRow(userServerRankQuery, guildUid, userUid)
 	u := types.UserServerRank{}
-	err = row.Scan(&u.Id, &u.ServerId, &u.UserId, &u.Rank, &u.MessageSent)
+	err = row.Scan(&u.Id, &u.ServerId, &u.UserId, &u.Rank, &u.MessageSent, &u.LeaderboardOptOut)
 	return &u, err
 }
 
-func UserServerRankUpdateOrInsert(userId int, serverId int, points int) (id int, newPoint int, messageSent bool, err error) {
-	u := types.UserServerRank{
-		ServerId: serverId,
-		UserId:   userId,
+/*
+Sets if the user should be hidden from the server's leaderboard. Users that haven't earned any points yet still get a row, so the setting
+sticks once they do
+*/
+func UserServerRankSetLeaderboardOptOut(userId int, serverId int, optOut bool) (err error) {
+	result, err := moeDb.Exec(userServerRankUpdateOptOut, serverId, userId, optOut)
+	if err != nil {
+		log.Println("Error updating leaderboard opt out", err)
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated > 0 {
+		return
+	}
+	_, err = moeDb.Exec(userServerRankInsertOptOut, serverId, userId, optOut)
+	if err != nil {
+		log.Println("Error inserting leaderboard opt out", err)
+	}
+	return
+}
+
+/*
+Adds points to many users at once in a single transaction, inserting ranks for anyone that doesn't have one yet. Each change also gets a
+point event, and the points go into the user's shop balance too. If anything fails nothing is saved, so the caller can hold onto the points
+and try again later
+*/
+func UserServerRankAddPoints(changes []types.UserServerRankChange) (results []types.UserServerRankChange, err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for user server ranks", err)
+		return
 	}
-	row := moeDb.QueryRow(userServerRankQueryId, u.ServerId, u.UserId)
-	if err = row.Scan(&u.Id, &u.Rank, &u.MessageSent); err != nil {
+	now := time.Now().UTC()
+	for _, c := range changes {
+		err = tx.QueryRow(userServerRankUpdate, c.ServerId, c.UserId, c.Points, now).Scan(&c.Id, &c.Rank, &c.MessageSent)
 		if err == sql.ErrNoRows {
-			// no row, so insert it
-			err = moeDb.QueryRow(userServerRankInsert, serverId, userId, points).Scan(&id, &newPoint, &messageSent)
-			if err != nil {
-				log.Println("Error inserting userServerRank to db ", err)
-				return
-			}
-			return
+			err = tx.QueryRow(userServerRankInsert, c.ServerId, c.UserId, c.Points, now).Scan(&c.Id, &c.Rank, &c.MessageSent)
 		}
-	} else {
-		// already have a row, update it
-		err = moeDb.QueryRow(userServerRankUpdate, u.Id, points).Scan(&id, &newPoint, &messageSent)
 		if err != nil {
 			log.Println("Error updating userServerRank", err)
+			tx.Rollback()
+			return nil, err
+		}
+		if _, err = tx.Exec(pointEventInsert, c.ServerId, c.UserId, c.Points, now); err != nil {
+			log.Println("Error inserting point event", err)
+			tx.Rollback()
+			return nil, err
+		}
+		results = append(results, c)
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing user server ranks", err)
+		return nil, err
+	}
+	return
+}
+
+/*
+Takes points away from everyone in the server that hasn't earned any since inactiveSince, recording a point event for each. Returns everyone
+that lost points
+*/
+func UserServerRankDecay(serverId int, inactiveSince time.Time, amount int, isPercent bool) (decayed []types.UserServerRankDecay, err error) {
+	rows, err := moeDb.Query(userServerRankDecay, serverId, inactiveSince.UTC(), amount, isPercent, time.Now().UTC(), types.PointSourceDecay)
+	if err != nil {
+		log.Println("Error decaying user server ranks", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d types.UserServerRankDecay
+		if err = rows.Scan(&d.UserUid, &d.OldRank, &d.NewRank); err != nil {
+			log.Println("Error scanning decayed user server rank", err)
 			return
 		}
+		decayed = append(decayed, d)
+	}
+	return
+}
+
+/*
+Changes a user's points by hand, recording the change along with who made it and why. Adds points to the user's rank, or replaces it if set is
+true. Ranks can't go below 0. Manual changes don't count as activity, so they don't stop points from decaying
+*/
+func UserServerRankAdjust(userId int, serverId int, points int, set bool, moderatorId int, reason sql.NullString) (oldRank int, newRank int, err error) {
+	tx, err := moeDb.Begin()
+	if err != nil {
+		log.Println("Error beginning transaction for manual rank change", err)
+		return
+	}
+	err = tx.QueryRow(userServerRankQueryForUpdate, serverId, userId).Scan(&oldRank)
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		oldRank = 0
+		err = nil
+	}
+	if err == nil {
+		newRank = oldRank + points
+		if set {
+			newRank = points
+		}
+		if newRank < 0 {
+			newRank = 0
+		}
+		if exists {
+			_, err = tx.Exec(userServerRankSetRank, serverId, userId, newRank)
+		} else {
+			_, err = tx.Exec(userServerRankInsertRank, serverId, userId, newRank)
+		}
+	}
+	if err != nil {
+		log.Println("Error setting rank for manual rank change", err)
+		tx.Rollback()
 		return
 	}
-	return u.Id, u.Rank, u.MessageSent, nil
+	_, err = tx.Exec(pointEventInsertManual, serverId, userId, newRank-oldRank, time.Now().UTC(), types.PointSourceManual, moderatorId, reason)
+	if err != nil {
+		log.Println("Error inserting point event for manual rank change", err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Error committing manual rank change", err)
+		return
+	}
+	return
+}
+
+/*
+Gets how many points the user has to spend in the shop. Users without a rank have nothing to spend
+*/
+func UserServerRankQueryBalance(userId int, serverId int) (balance int, err error) {
+	err = moeDb.QueryRow(userServerRankQueryBalance, serverId, userId).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Println("Error querying for user balance", err)
+	}
+	return
 }
 
 func UserServerRankSetMessageSent(entries []int) (err error) {
@@ -82,4 +223,11 @@ func userServerRankCreateTable() {
 		log.Println("Error creating user server rank table", err)
 		return
 	}
+	for _, alter := range userServerRankUpdateTable {
+		_, err = moeDb.Exec(alter)
+		if err != nil {
+			log.Println("Error alterting user server rank table", err)
+			return
+		}
+	}
 }
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	voiceEarningTable = `CREATE TABLE IF NOT EXISTS voice_earning(
+		ServerId INTEGER NOT NULL REFERENCES server(Id) ON DELETE CASCADE,
+		UserUid VARCHAR(20) NOT NULL,
+		Day DATE NOT NULL,
+		Points INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (ServerId, UserUid, Day)
+	)`
+
+	// Both CTEs see the row from before the upsert, so the difference is what was actually given. Lowering the cap never takes points back
+	voiceEarningAdd = `WITH previous AS (SELECT Points FROM voice_earning WHERE ServerId = $1 AND UserUid = $2 AND Day = $3),
+		saved AS (INSERT INTO voice_earning(ServerId, UserUid, Day, Points) VALUES ($1, $2, $3, LEAST($4, $5))
+			ON CONFLICT (ServerId, UserUid, Day) DO UPDATE SET Points = LEAST(voice_earning.Points + $4, GREATEST(voice_earning.Points, $5))
+			RETURNING Points)
+		SELECT saved.Points - COALESCE((SELECT Points FROM previous), 0) FROM saved`
+	voiceEarningDeleteOld = `DELETE FROM voice_earning WHERE Day < $1`
+)
+
+/*
+Records voice points a user earned today, returning how many can actually be given without going over the daily cap.
+Days are in UTC, so everyone's cap starts over at the same time
+*/
+func VoiceEarningAdd(serverId int, userUid string, points int, dailyCap int) (earned int, err error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	err = moeDb.QueryRow(voiceEarningAdd, serverId, userUid, today, points, dailyCap).Scan(&earned)
+	if err != nil {
+		log.Println("Error adding voice earnings", err)
+	}
+	return
+}
+
+/*
+Deletes voice earnings from before today, they're only needed for the daily cap
+*/
+func VoiceEarningDeleteOld() (err error) {
+	_, err = moeDb.Exec(voiceEarningDeleteOld, time.Now().UTC().Truncate(24*time.Hour))
+	if err != nil {
+		log.Println("Error deleting old voice earnings", err)
+	}
+	return
+}
+
+func voiceEarningCreateTable() {
+	_, err := moeDb.Exec(voiceEarningTable)
+	if err != nil {
+		log.Println("Error creating voice earning table", err)
+	}
+}
@@ -1,8 +1,10 @@
 package moeDiscord
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
@@ -86,6 +88,34 @@ func RetrieveBasePermissions(session *discordgo.Session, channel *discordgo.Chan
 	return result
 }
 
+/*
+Gets the position of the highest of the given roles. With none of them found that's 0, the same as @everyone
+*/
+func HighestRolePosition(roles []*discordgo.Role, roleUIDs []string) (highest int) {
+	for _, id := range roleUIDs {
+		if r := FindRoleById(roles, id); r != nil && r.Position > highest {
+			highest = r.Position
+		}
+	}
+	return
+}
+
+/*
+Checks a role sits below both the member's highest role and moebot's, so nobody can use moebot to hand out a role they couldn't give out
+themselves. The server owner can hand out anything moebot can
+*/
+func CanManageRole(session *discordgo.Session, guild *discordgo.Guild, member *discordgo.Member, role *discordgo.Role) (bool, error) {
+	botMember, err := GetMember(session.State.User.ID, guild.ID, session)
+	if err != nil {
+		return false, err
+	}
+	if role.Position >= HighestRolePosition(guild.Roles, botMember.Roles) {
+		return false, nil
+	}
+	isOwner := member.User != nil && member.User.ID == guild.OwnerID
+	return isOwner || role.Position < HighestRolePosition(guild.Roles, member.Roles), nil
+}
+
 func GetEveryoneRoleForServer(session *discordgo.Session, serverID int) *discordgo.Role {
 	server, err := db.ServerQueryById(serverID)
 	if err != nil {
@@ -133,3 +163,57 @@ func mapPermissions(base map[int]bool, permission *discordgo.PermissionOverwrite
 		}
 	}
 }
+
+/*
+Gets every member in a guild. The state only knows about members that have been sent to us, so this goes straight to discord one page at a time
+*/
+func GetAllGuildMembers(session *discordgo.Session, guildUID string) ([]*discordgo.Member, error) {
+	var members []*discordgo.Member
+	after := ""
+	for {
+		page, err := session.GuildMembers(guildUID, after, 1000)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if len(page) < 1000 {
+			return members, nil
+		}
+		after = page[len(page)-1].User.ID
+	}
+}
+
+/*
+Gets a channel's slowmode in seconds. Goes straight to discord since the channel struct doesn't hold onto it
+*/
+func GetChannelSlowmode(session *discordgo.Session, channelUID string) (int, error) {
+	body, err := session.RequestWithBucketID("GET", discordgo.EndpointChannel(channelUID), nil, discordgo.EndpointChannel(channelUID))
+	if err != nil {
+		return 0, err
+	}
+	var channel struct {
+		RateLimitPerUser int `json:"rate_limit_per_user"`
+	}
+	err = json.Unmarshal(body, &channel)
+	return channel.RateLimitPerUser, err
+}
+
+/*
+Sets a channel's slowmode in seconds, 0 turns it off. The channel edit struct drops a 0, so this sends the change itself
+*/
+func SetChannelSlowmode(session *discordgo.Session, channelUID string, seconds int) error {
+	_, err := session.RequestWithBucketID("PATCH", discordgo.EndpointChannel(channelUID), map[string]int{"rate_limit_per_user": seconds},
+		discordgo.EndpointChannel(channelUID))
+	return err
+}
+
+/*
+Checks if an error from discord means what's being changed is gone, or moebot isn't allowed to touch it anymore. Trying again won't help either way
+*/
+func IsGoneOrForbidden(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	if !ok || restErr.Response == nil {
+		return false
+	}
+	return restErr.Response.StatusCode == http.StatusNotFound || restErr.Response.StatusCode == http.StatusForbidden
+}
@@ -38,7 +38,8 @@ func GetMember(memberUid string, guildUid string, session *discordgo.Session) (m
 			log.Println("Error getting member/guild: "+memberUid+"/"+guildUid, err)
 			return nil, err
 		}
-		// fetched a valid member, update the state and return it
+		// fetched a valid member, update the state and return it. The state files members by guild, which isn't always filled in
+		member.GuildID = guildUid
 		session.State.MemberAdd(member)
 		return
 	}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	cardWidth       = 600
+	cardHeight      = 200
+	cardAccentWidth = 8
+	cardAvatarSize  = 128
+	cardAvatarX     = 32
+	cardTextX       = cardAvatarX + cardAvatarSize + 24
+	cardBarWidth    = cardWidth - cardTextX - 24
+	cardBarHeight   = 18
+	cardBarY        = 152
+)
+
+var (
+	cardBackground = color.RGBA{0x2c, 0x2f, 0x33, 0xff}
+	cardBarColor   = color.RGBA{0x40, 0x44, 0x4b, 0xff}
+	cardTextColor  = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	cardSubtleText = color.RGBA{0xb9, 0xbb, 0xbe, 0xff}
+	// Discord's blurple, used when a server hasn't picked its own accent
+	DefaultCardAccent = color.RGBA{0x72, 0x89, 0xda, 0xff}
+)
+
+/*
+Everything that goes on a profile card. Progress is how far along to the next rank from 0 to 1
+*/
+type ProfileCard struct {
+	Avatar          image.Image
+	DisplayName     string
+	Tier            string
+	Progress        float64
+	ProgressText    string
+	PermissionLevel string
+	JoinDate        string
+	Accent          color.RGBA
+}
+
+/*
+Draws a profile card and encodes it as a PNG
+*/
+func MakeProfileCard(card ProfileCard) ([]byte, error) {
+	fnt, err := truetype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, err
+	}
+	nameFace := truetype.NewFace(fnt, &truetype.Options{Size: 24.0})
+	textFace := truetype.NewFace(fnt, &truetype.Options{Size: 15.0})
+
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, cardAccentWidth, cardHeight), image.NewUniform(card.Accent), image.ZP, draw.Src)
+	if card.Avatar != nil {
+		drawCircleAvatar(img, card.Avatar, image.Pt(cardAvatarX, (cardHeight-cardAvatarSize)/2), cardAvatarSize)
+	}
+
+	drawCardText(img, nameFace, cardTextColor, cardTextX, 52, fitCardText(card.DisplayName, nameFace, cardBarWidth))
+	drawCardText(img, textFace, card.Accent, cardTextX, 84, fitCardText(card.Tier, textFace, cardBarWidth))
+	drawCardText(img, textFace, cardSubtleText, cardTextX, 106, "Permission: "+card.PermissionLevel)
+	drawCardText(img, textFace, cardSubtleText, cardTextX, 128, "Joined: "+card.JoinDate)
+
+	progress := card.Progress
+	if progress < 0 {
+		progress = 0
+	} else if progress > 1 {
+		progress = 1
+	}
+	bar := image.Rect(cardTextX, cardBarY, cardTextX+cardBarWidth, cardBarY+cardBarHeight)
+	draw.Draw(img, bar, image.NewUniform(cardBarColor), image.ZP, draw.Src)
+	filled := bar
+	filled.Max.X = bar.Min.X + int(float64(cardBarWidth)*progress)
+	draw.Draw(img, filled, image.NewUniform(card.Accent), image.ZP, draw.Src)
+	drawCardText(img, textFace, cardSubtleText, cardTextX, cardBarY+cardBarHeight+18, fitCardText(card.ProgressText, textFace, cardBarWidth))
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawCardText(img draw.Image, face font.Face, textColor color.RGBA, x int, y int, text string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+/*
+Cuts text down with an ellipsis until it fits in the given width
+*/
+func fitCardText(text string, face font.Face, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && font.MeasureString(face, string(runes)+"...").Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+/*
+Scales the avatar to size x size (nearest neighbour is plenty for a small avatar) and cuts it into a circle
+*/
+func drawCircleAvatar(dst *image.RGBA, avatar image.Image, at image.Point, size int) {
+	bounds := avatar.Bounds()
+	// drawn to its own image first so transparent avatars get blended onto the background
+	circle := image.NewRGBA(image.Rect(0, 0, size, size))
+	radius := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx := float64(x) + 0.5 - radius
+			dy := float64(y) + 0.5 - radius
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			srcX := bounds.Min.X + x*bounds.Dx()/size
+			srcY := bounds.Min.Y + y*bounds.Dy()/size
+			circle.Set(x, y, avatar.At(srcX, srcY))
+		}
+	}
+	draw.Draw(dst, image.Rect(at.X, at.Y, at.X+size, at.Y+size), circle, image.ZP, draw.Over)
+}
+
+/*
+Parses a colour like #7289da (the # is optional)
+*/
+func ParseHexColor(s string) (c color.RGBA, valid bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return c, false
+	}
+	value, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return c, false
+	}
+	return color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 0xff}, true
+}
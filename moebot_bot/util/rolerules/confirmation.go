@@ -1,17 +1,39 @@
 package rolerules
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/camd67/moebot/moebot_bot/util"
+	"github.com/camd67/moebot/moebot_bot/util/db"
 	"github.com/camd67/moebot/moebot_bot/util/db/types"
 )
 
+const (
+	// Minutes a confirmation code is valid for when the server hasn't configured an expiry
+	DefaultRoleCodeExpiry = 30
+
+	roleCodeMaxAttempts     = 5
+	roleCodeAttemptCooldown = 15 * time.Minute
+	roleCodeSecretLength    = 32
+)
+
 type Confirmation struct {
 	ComPrefix string
+	Server    *types.Server
+
+	// the confirmation that passed Check, so that it can be used up once the role is actually applied
+	confirmationId int
 }
 
 func (r *Confirmation) Check(session *discordgo.Session, action *RoleAction) (success bool, message string) {
@@ -26,50 +48,91 @@ func (r *Confirmation) Check(session *discordgo.Session, action *RoleAction) (su
 	}
 	// we only want to check for a confirmation when we have an actual confirmation message and they don't already have the role
 	if action.Role.ConfirmationMessage.Valid && action.Role.ConfirmationMessage.String != "" && !util.StrContains(action.Member.Roles, action.Role.RoleUid, util.CaseSensitive) {
+		if len(confirmCodes) > 0 {
+			// never leave a code sitting in a public channel, even if it turns out to be wrong
+			session.ChannelMessageDelete(action.Channel.ID, action.OriginalMessage.ID)
+		}
+
+		confirmation, err := db.RoleConfirmationQuery(action.Role.Id, action.Member.User.ID)
+		hasConfirmation := err == nil
+		if err != nil && err != sql.ErrNoRows {
+			return false, "Sorry, there was an issue checking your confirmation code. This is an issue with moebot not discord."
+		}
+		if hasConfirmation && confirmation.FailedAttempts >= roleCodeMaxAttempts {
+			remaining := time.Until(confirmation.LastFailure.Add(roleCodeAttemptCooldown))
+			if remaining > 0 {
+				return false, "Sorry, you've entered too many incorrect confirmation codes. Please try again in " + formatMinutes(remaining) + "."
+			}
+			// cooldown is over, give them a fresh set of attempts
+			db.RoleConfirmationResetAttempts(confirmation.Id)
+		}
+
 		// no confirm codes provided, given them their confirmation code
 		if len(confirmCodes) <= 0 {
-			err := r.sendConfirmationMessage(session, action.Channel, action.Role, action.Member)
+			confirmation, err = db.RoleConfirmationIssue(action.Role.Id, action.Member.User.ID)
+			if err != nil {
+				return false, "Sorry, there was an issue creating your confirmation code. This is an issue with moebot not discord."
+			}
+			err = r.sendConfirmationMessage(session, action.Role, action.Member, confirmation)
 			if err != nil {
 				return false, "Sorry, I couldn't send you a PM! Please check your settings to allow direct messages from users on this server."
 			}
-			return false, action.Member.User.Mention() + " check your PM's for further instructions!"
+			return false, action.Member.User.Mention() + " check your PM's for further instructions! Your code expires in " +
+				formatMinutes(r.codeExpiry()) + "."
 		}
 
-		session.ChannelMessageDelete(action.Channel.ID, action.OriginalMessage.ID)
+		if !hasConfirmation || time.Now().After(confirmation.IssuedAt.Add(r.codeExpiry())) {
+			return false, "Sorry, your confirmation code has expired. Use `" + r.ComPrefix + " role " + action.Role.Trigger.String +
+				"` to receive a new one."
+		}
+		roleCode, err := r.getRoleCode(action.Role.RoleUid, action.Member.User.ID, confirmation.IssuedAt)
+		if err != nil {
+			return false, "Sorry, there was an issue checking your confirmation code. This is an issue with moebot not discord."
+		}
 
 		if action.Role.ConfirmationSecurityAnswer.Valid && action.Role.ConfirmationSecurityAnswer.String != "" {
 			if len(confirmCodes) != 2 {
 				return false, "Sorry, you need to insert a confirmation code and security answer to access " +
-					"this role. Use `" + r.ComPrefix + " " + action.Role.Trigger.String + "` to receive a DM containing detailed instructions."
+					"this role. Use `" + r.ComPrefix + " role " + action.Role.Trigger.String + "` to receive a DM containing detailed instructions."
 			}
 			if !util.StrContains(confirmCodes, action.Role.ConfirmationSecurityAnswer.String, util.CaseSensitive) ||
-				!util.StrContains(confirmCodes, "-"+r.getRoleCode(action.Role.RoleUid, action.Member.User.ID), util.CaseSensitive) {
-				return false, "Sorry, you need to insert the correct confirmation code to access this role."
+				!containsRoleCode(confirmCodes, "-"+roleCode) {
+				return false, r.recordFailure(session, action, confirmation)
 			}
 		} else {
 			if len(confirmCodes) != 1 {
 				return false, "Sorry, you need to insert a confirmation code to access this role. Use `" +
-					r.ComPrefix + " " + action.Role.Trigger.String + "` to receive a DM containing detailed instructions."
+					r.ComPrefix + " role " + action.Role.Trigger.String + "` to receive a DM containing detailed instructions."
 			}
-			if !util.StrContains(confirmCodes, "-"+r.getRoleCode(action.Role.RoleUid, action.Member.User.ID), util.CaseSensitive) {
-				return false, "Sorry, you need to insert the correct confirmation code to access this role."
+			if !containsRoleCode(confirmCodes, "-"+roleCode) {
+				return false, r.recordFailure(session, action, confirmation)
 			}
 		}
+		r.confirmationId = confirmation.Id
 	}
 	return true, ""
 }
 
 func (r *Confirmation) Apply(session *discordgo.Session, action *RoleAction) (success bool, message string) {
+	if r.confirmationId != 0 {
+		// codes are single use, once the role is given out the code is gone
+		db.RoleConfirmationDelete(r.confirmationId)
+	}
 	return true, ""
 }
 
-func (r *Confirmation) sendConfirmationMessage(session *discordgo.Session, channel *discordgo.Channel, role *types.Role, member *discordgo.Member) error {
+func (r *Confirmation) sendConfirmationMessage(session *discordgo.Session, role *types.Role, member *discordgo.Member,
+	confirmation types.RoleConfirmation) error {
+
+	roleCode, err := r.getRoleCode(role.RoleUid, member.User.ID, confirmation.IssuedAt)
+	if err != nil {
+		return err
+	}
 	userChannel, err := session.UserChannelCreate(member.User.ID)
 	if err != nil {
 		// could log error creating user channel, but seems like it'll clutter the logs for a valid scenario..
 		return err
 	}
-	roleCode := r.getRoleCode(role.RoleUid, member.User.ID)
 	var messageText string
 	if strings.Contains(strings.ToLower(role.ConfirmationMessage.String), types.RoleCodeSearchText) {
 		messageText = strings.Replace(role.ConfirmationMessage.String, types.RoleCodeSearchText, roleCode, -1)
@@ -81,11 +144,69 @@ func (r *Confirmation) sendConfirmationMessage(session *discordgo.Session, chann
 }
 
 /*
-Returns a 6 character role code string that is unique per user and per role.
-This should NOT be used for security features as it is not a secure algorithm.
+Records a failed confirmation attempt, letting the server's mods know about it. Returns the message to give back to the user.
 */
-func (r *Confirmation) getRoleCode(roleUID, userUID string) string {
-	hash := sha256.New()
-	hash.Write([]byte(roleUID + userUID))
-	return string(fmt.Sprintf("%x", hash.Sum(nil))[0:types.RoleCodeLength])
+func (r *Confirmation) recordFailure(session *discordgo.Session, action *RoleAction, confirmation types.RoleConfirmation) string {
+	attempts, err := db.RoleConfirmationFail(confirmation.Id)
+	if err != nil {
+		// still count this as a failure for the message, we just couldn't save it
+		attempts = confirmation.FailedAttempts + 1
+	}
+	if r.Server.BotChannel.Valid {
+		session.ChannelMessageSend(r.Server.BotChannel.String, fmt.Sprintf("`%s` (%s) entered an incorrect confirmation code for the role `%s`. "+
+			"Failed attempts: %d/%d", action.Member.User.String(), action.Member.User.ID, action.Role.Trigger.String, attempts, roleCodeMaxAttempts))
+	}
+	if attempts >= roleCodeMaxAttempts {
+		return "Sorry, you've entered too many incorrect confirmation codes. Please try again in " + formatMinutes(roleCodeAttemptCooldown) + "."
+	}
+	return "Sorry, you need to insert the correct confirmation code to access this role. Attempts remaining: " +
+		strconv.Itoa(roleCodeMaxAttempts-attempts)
+}
+
+func (r *Confirmation) codeExpiry() time.Duration {
+	if r.Server.RoleCodeExpiry.Valid {
+		return time.Duration(r.Server.RoleCodeExpiry.Int64) * time.Minute
+	}
+	return DefaultRoleCodeExpiry * time.Minute
+}
+
+/*
+Returns a 6 character role code string that is unique per user, per role, and per time it was issued.
+Codes are signed with a secret that's unique to each server so they can't be worked out from the role and user IDs.
+*/
+func (r *Confirmation) getRoleCode(roleUID, userUID string, issuedAt time.Time) (string, error) {
+	candidate := make([]byte, roleCodeSecretLength)
+	if _, err := rand.Read(candidate); err != nil {
+		log.Println("Error generating a role code secret", err)
+		return "", err
+	}
+	// the candidate is only used if this server doesn't have a secret yet
+	secret, err := db.ServerRoleCodeSecret(r.Server.Id, hex.EncodeToString(candidate))
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(roleUID + ":" + userUID + ":" + strconv.FormatInt(issuedAt.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))[0:types.RoleCodeLength], nil
+}
+
+func containsRoleCode(codes []string, roleCode string) bool {
+	for _, c := range codes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(roleCode)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func formatMinutes(d time.Duration) string {
+	minutes := int(d / time.Minute)
+	if d%time.Minute != 0 {
+		// round up so we never tell someone to come back before they actually can
+		minutes++
+	}
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return strconv.Itoa(minutes) + " minutes"
 }
@@ -8,18 +8,24 @@ import (
 
 type Points struct {
 	PointsTreshold int
+	// Name of the rank tier this role belongs to. Empty for the server's veteran role
+	TierName string
 }
 
 func (r *Points) Check(session *discordgo.Session, action *RoleAction) (success bool, message string) {
 	if action.Action == RoleRemove {
 		return true, ""
 	}
+	goal := "veteran"
+	if r.TierName != "" {
+		goal = r.TierName
+	}
 	if action.UserRank == nil {
-		return false, "Sorry, you don't have enough veteran points yet! You're currently: Unranked"
+		return false, "Sorry, you don't have enough points for " + goal + " yet! You're currently: Unranked"
 	}
-	pointCountMessage := fmt.Sprintf("%.2f%% of the way to veteran", float64(action.UserRank.Rank)/float64(r.PointsTreshold)*100)
+	pointCountMessage := fmt.Sprintf("%.2f%% of the way to %s", float64(action.UserRank.Rank)/float64(r.PointsTreshold)*100, goal)
 	if action.UserRank.Rank < r.PointsTreshold {
-		return false, "Sorry, you don't have enough veteran points yet! You're currently: " + pointCountMessage
+		return false, "Sorry, you don't have enough points for " + goal + " yet! You're currently: " + pointCountMessage
 	}
 	return true, ""
 }
@@ -2,6 +2,7 @@ package rolerules
 
 import (
 	"log"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/camd67/moebot/moebot_bot/util/db"
@@ -40,8 +41,17 @@ func GetRulesForRole(server *types.Server, role *types.Role, comPrefix string) (
 	if server.VeteranRole.String == role.RoleUid && server.VeteranRank.Valid {
 		result = append(result, &Points{PointsTreshold: int(server.VeteranRank.Int64)})
 	}
+	tiers, err := db.RankTierQueryServer(server.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tiers {
+		if t.RoleUid.Valid && t.RoleUid.String == strings.TrimSpace(role.RoleUid) {
+			result = append(result, &Points{PointsTreshold: t.Threshold, TierName: t.Name})
+		}
+	}
 	if role.ConfirmationMessage.Valid {
-		result = append(result, &Confirmation{ComPrefix: comPrefix})
+		result = append(result, &Confirmation{ComPrefix: comPrefix, Server: server})
 	}
 	for _, gID := range role.Groups {
 		group, err := db.RoleGroupQueryId(gID)
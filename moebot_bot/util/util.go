@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -58,6 +59,28 @@ func ExtractChannelIdFromString(message string) (id string, valid bool) {
 	return id, err == nil
 }
 
+/*
+Gets a user ID out of a mention (<@1234> or <@!1234>), or just a plain user ID
+*/
+func ExtractUserIdFromString(message string) (id string, valid bool) {
+	id = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(message, "<@"), "!"), ">")
+	if id == "" || (id != message && !strings.HasPrefix(message, "<@")) {
+		return "", false
+	}
+	_, err := strconv.ParseUint(id, 10, 64)
+	return id, err == nil
+}
+
+/*
+Checks if one discord ID is older than another. IDs go up over time, and a longer one is always newer
+*/
+func SnowflakeLess(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
 func MakeStringBold(s string) string {
 	return "**" + s + "**"
 }
@@ -133,3 +156,61 @@ func ParseIntervalToISO(interval string) (string, error) {
 	intervalString := strings.Trim(b.String(), "T")
 	return intervalString, nil
 }
+
+/*
+Parses a length of time like 1W2D3h4m, in the same style as intervals. Any part can be left out, but there has to be at least one
+*/
+func ParseDuration(duration string) (time.Duration, error) {
+	rx := regexp.MustCompile("^(?:(\\d+)[Ww])?(?:(\\d+)[Dd])?(?:(\\d+)h)?(?:(\\d+)m)?$")
+	matches := rx.FindStringSubmatch(duration)
+	if duration == "" || matches == nil {
+		return 0, fmt.Errorf("Invalid duration string")
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute}
+	var result time.Duration
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+		value, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return 0, err
+		}
+		result += time.Duration(value) * unit
+	}
+	return result, nil
+}
+
+/*
+Gets the number of single character insertions, deletions, or substitutions needed to turn a into b, ignoring case.
+Useful for finding what a user most likely meant when they mistype something
+*/
+func EditDistance(a string, b string) int {
+	ra := []rune(strings.ToUpper(a))
+	rb := []rune(strings.ToUpper(b))
+	// only need to keep the previous row of the distance table around
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditDistance(t *testing.T) {
+	checks := []struct {
+		a, b     string
+		expected int
+	}{
+		{"veteran", "veteran", 0},
+		{"vetran", "veteran", 1},
+		{"VETERAN", "veteran", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+	}
+	for _, c := range checks {
+		if actual := EditDistance(c.a, c.b); actual != c.expected {
+			t.Errorf("EditDistance(%q, %q) = %d, expected %d", c.a, c.b, actual, c.expected)
+		}
+	}
+}
+
+func TestExtractUserIdFromString(t *testing.T) {
+	checks := []struct {
+		message string
+		id      string
+		valid   bool
+	}{
+		{"<@1234>", "1234", true},
+		{"<@!1234>", "1234", true},
+		{"1234", "1234", true},
+		{"<#1234>", "", false},
+		{"<@abc>", "abc", false},
+		{"", "", false},
+	}
+	for _, c := range checks {
+		id, valid := ExtractUserIdFromString(c.message)
+		if valid != c.valid || (valid && id != c.id) {
+			t.Errorf("ExtractUserIdFromString(%q) = %q, %v, expected %q, %v", c.message, id, valid, c.id, c.valid)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	checks := []struct {
+		s     string
+		c     color.RGBA
+		valid bool
+	}{
+		{"#7289da", color.RGBA{0x72, 0x89, 0xda, 0xff}, true},
+		{"FF0000", color.RGBA{0xff, 0x00, 0x00, 0xff}, true},
+		{"#fff", color.RGBA{}, false},
+		{"#gggggg", color.RGBA{}, false},
+		{"", color.RGBA{}, false},
+	}
+	for _, c := range checks {
+		actual, valid := ParseHexColor(c.s)
+		if valid != c.valid || (valid && actual != c.c) {
+			t.Errorf("ParseHexColor(%q) = %v, %v, expected %v, %v", c.s, actual, valid, c.c, c.valid)
+		}
+	}
+}
+
+func TestHeatLevel(t *testing.T) {
+	checks := []struct {
+		count, max, levels, expected int
+	}{
+		{0, 100, 5, 0},
+		{1, 100, 5, 1},
+		{50, 100, 5, 2},
+		{99, 100, 5, 3},
+		{100, 100, 5, 4},
+		{150, 100, 5, 4},
+		{10, 0, 5, 0},
+		{10, 10, 1, 0},
+	}
+	for _, c := range checks {
+		actual := HeatLevel(c.count, c.max, c.levels)
+		if actual != c.expected {
+			t.Errorf("HeatLevel(%d, %d, %d) = %d, expected %d", c.count, c.max, c.levels, actual, c.expected)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	checks := []struct {
+		s     string
+		d     time.Duration
+		valid bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"2h", 2 * time.Hour, true},
+		{"1D12h", 36 * time.Hour, true},
+		{"1W", 7 * 24 * time.Hour, true},
+		{"1w2d3h4m", 9*24*time.Hour + 3*time.Hour + 4*time.Minute, true},
+		{"", 0, false},
+		{"1h1D", 0, false},
+		{"5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, c := range checks {
+		actual, err := ParseDuration(c.s)
+		if (err == nil) != c.valid || actual != c.d {
+			t.Errorf("ParseDuration(%q) = %v, %v, expected %v, valid %v", c.s, actual, err, c.d, c.valid)
+		}
+	}
+}
+
+func TestMakeCaptchaText(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		text := MakeCaptchaText(6)
+		if len(text) != 6 {
+			t.Errorf("MakeCaptchaText(6) = %q, expected 6 characters", text)
+		}
+		if strings.ContainsAny(text, "0O1IL5S2Z") {
+			t.Errorf("MakeCaptchaText(6) = %q, shouldn't have characters that are easy to mix up", text)
+		}
+	}
+}
+
+func TestSnowflakeLess(t *testing.T) {
+	checks := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"1", "2", true},
+		{"2", "1", false},
+		{"999", "1000", true},
+		{"1000", "999", false},
+		{"5", "5", false},
+	}
+	for _, c := range checks {
+		if actual := SnowflakeLess(c.a, c.b); actual != c.expected {
+			t.Errorf("SnowflakeLess(%q, %q) = %v, expected %v", c.a, c.b, actual, c.expected)
+		}
+	}
+}